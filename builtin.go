@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuiltinFunc is an in-process stand-in for an external command.
+type BuiltinFunc func(ctx context.Context, args []string) error
+
+// lookBuiltin returns the builtin implementation for the named command, if
+// any. Only the base name is considered, so "/bin/echo" resolves to "echo".
+func lookBuiltin(name string) (BuiltinFunc, bool) {
+	switch filepath.Base(name) {
+	case "echo":
+		return builtinEcho, true
+	case "true":
+		return builtinTrue, true
+	default:
+		return nil, false
+	}
+}
+
+// builtinEcho writes its arguments separated by spaces to stdout.
+func builtinEcho(_ context.Context, args []string) error {
+	if _, err := fmt.Fprintln(os.Stdout, strings.Join(args, " ")); err != nil {
+		return fmt.Errorf("echo: %w", err)
+	}
+
+	return nil
+}
+
+// builtinTrue does nothing and succeeds.
+func builtinTrue(context.Context, []string) error {
+	return nil
+}
+
+// builtinCmd adapts a [BuiltinFunc] to the [Cmd] interface. It runs the
+// function in a goroutine between Start and Wait.
+type builtinCmd struct {
+	ctx  context.Context //nolint:containedctx // passed to the function on Start
+	fn   BuiltinFunc
+	name string
+	args []string
+	done chan struct{}
+	err  error
+}
+
+// newBuiltinCmd returns a [Cmd] running fn with args.
+func newBuiltinCmd(ctx context.Context, fn BuiltinFunc, name string, args []string) *builtinCmd {
+	return &builtinCmd{ctx: ctx, fn: fn, name: name, args: args, done: make(chan struct{})}
+}
+
+// Start implements [Cmd].
+func (c *builtinCmd) Start() error {
+	if err := c.ctx.Err(); err != nil {
+		return err //nolint:wrapcheck // context error
+	}
+
+	go func() {
+		defer close(c.done)
+		c.err = c.fn(c.ctx, c.args)
+	}()
+
+	return nil
+}
+
+// Wait implements [Cmd].
+func (c *builtinCmd) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// String implements [Cmd].
+func (c *builtinCmd) String() string {
+	return strings.Join(append([]string{"builtin", c.name}, c.args...), " ")
+}
+
+// Pid implements [Cmd].
+func (*builtinCmd) Pid() int {
+	return 0
+}
+
+// ProcessState implements [Cmd].
+func (*builtinCmd) ProcessState() *os.ProcessState {
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLookBuiltin tests resolving builtin commands by name.
+func TestLookBuiltin(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name   string
+		wantOK bool
+	}{
+		"echo":          {name: "echo", wantOK: true},
+		"true":          {name: "true", wantOK: true},
+		"absolute path": {name: "/bin/echo", wantOK: true},
+		"unknown":       {name: "false", wantOK: false},
+		"empty":         {name: "", wantOK: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			fn, ok := lookBuiltin(tt.name)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantOK, fn != nil)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// joinCgroup moves the process pid into the cgroup v2 directory path. The
+// cgroup.procs file is not created, so a path that is not a cgroup fails.
+func joinCgroup(path string, pid int) error {
+	procs := filepath.Join(path, "cgroup.procs")
+	f, err := os.OpenFile(procs, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup procs: %w", err)
+	}
+
+	if _, err := f.WriteString(strconv.Itoa(pid)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write cgroup procs: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close cgroup procs: %w", err)
+	}
+
+	return nil
+}
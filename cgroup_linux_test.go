@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJoinCgroup tests failing to join directories that are not cgroups,
+// without creating a cgroup.procs file in them.
+func TestJoinCgroup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.Error(t, joinCgroup(dir, 42))
+	assert.NoFileExists(t, filepath.Join(dir, "cgroup.procs"))
+
+	require.Error(t, joinCgroup(filepath.Join(dir, "missing"), 42))
+}
+
+// TestWithCgroup tests moving instances into a cgroup v2. It is skipped
+// unless a cgroup can be created below the cgroup of the test.
+func TestWithCgroup(t *testing.T) {
+	t.Parallel()
+
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Skipf("read own cgroup: %v", err)
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		t.Skip("cgroup v2 not available")
+	}
+	parent := filepath.Join("/sys/fs/cgroup", strings.TrimPrefix(strings.TrimSpace(string(self)), "0::"))
+	cgroup, err := os.MkdirTemp(parent, "cmdgroup-test-")
+	if err != nil {
+		t.Skipf("create cgroup: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(cgroup) })
+
+	group, err := New("sleep", WithArgs([]string{"60"}), WithCgroup(cgroup))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, group.Instances[0].Running, 5*time.Second, time.Millisecond)
+	procs, err := os.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(group.Instances[0].PID()), strings.TrimSpace(string(procs)))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// TestWithCgroupMissing tests stopping an instance that cannot join its
+// cgroup.
+func TestWithCgroupMissing(t *testing.T) {
+	t.Parallel()
+
+	group, err := New("sleep", WithArgs([]string{"60"}), WithCgroup(filepath.Join(t.TempDir(), "missing")))
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.ErrorContains(t, group.Run(t.Context()), "join cgroup")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Zero(t, group.Instances[0].Stats().Starts)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// joinCgroup is only supported on Linux.
+func joinCgroup(string, int) error {
+	return errors.ErrUnsupported
+}
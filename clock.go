@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+)
+
+type (
+	// clock tells the time and waits for it to pass. Groups and their
+	// instances use it for all their timing, such as restart delays,
+	// timeouts, polling, output flushing and event times, so tests can
+	// replace it with a fake clock they advance themselves. Only the
+	// [os/exec.Cmd.WaitDelay] of commands runs on the real clock.
+	clock interface {
+		// Now returns the current time.
+		Now() time.Time
+		// After waits for d to pass and then sends the current time on
+		// the returned channel, like [time.After].
+		After(d time.Duration) <-chan time.Time
+		// NewTimer returns a timer that sends the current time on its
+		// channel once d has passed, like [time.NewTimer].
+		NewTimer(d time.Duration) timer
+		// AfterFunc returns a timer that calls f in its own goroutine
+		// once d has passed, like [time.AfterFunc]. Its channel is nil.
+		AfterFunc(d time.Duration, f func()) timer
+		// NewTicker returns a ticker that sends the current time on its
+		// channel every d, like [time.NewTicker].
+		NewTicker(d time.Duration) ticker
+	}
+
+	// timer is a single event of a [clock].
+	timer interface {
+		// C returns the channel on which the time is sent.
+		C() <-chan time.Time
+		// Stop prevents the timer from firing, like [time.Timer.Stop].
+		Stop() bool
+	}
+
+	// ticker is a repeated event of a [clock].
+	ticker interface {
+		// C returns the channel on which the time is sent.
+		C() <-chan time.Time
+		// Stop turns off the ticker, like [time.Ticker.Stop].
+		Stop()
+	}
+
+	// realClock is the [clock] of the [time] package.
+	realClock struct{}
+
+	// realTimer is the [timer] of a [realClock].
+	realTimer struct {
+		*time.Timer
+	}
+
+	// realTicker is the [ticker] of a [realClock].
+	realTicker struct {
+		*time.Ticker
+	}
+)
+
+// Now implements [clock].
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements [clock].
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTimer implements [clock].
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// AfterFunc implements [clock].
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+// NewTicker implements [clock].
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// C implements [timer].
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// C implements [ticker].
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clock returns the clock of this group, or the real clock if unset.
+func (g *Group) clock() clock {
+	if g.clk == nil {
+		return realClock{}
+	}
+
+	return g.clk
+}
+
+// clock returns the clock of this instance, or the real clock if unset. The
+// clock is only set before [Instance.Run], so Run reads it without holding mu.
+func (i *Instance) clock() clock {
+	if i.clk == nil {
+		return realClock{}
+	}
+
+	return i.clk
+}
+
+// inheritClock sets the clock of this instance to clk unless it has its own.
+func (i *Instance) inheritClock(clk clock) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.clk == nil {
+		i.clk = clk
+	}
+}
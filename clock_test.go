@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// fakeClock is a [clock] whose time only passes when advanced.
+	fakeClock struct {
+		mu     sync.Mutex
+		now    time.Time
+		timers []*fakeTimer
+	}
+
+	// fakeTimer is the [timer] of a [fakeClock]. If period is positive, it
+	// fires repeatedly as a [ticker], and if f is set, it calls f instead of
+	// sending on c.
+	fakeTimer struct {
+		clock  *fakeClock
+		at     time.Time
+		c      chan time.Time
+		period time.Duration
+		f      func()
+	}
+
+	// fakeTicker is the [ticker] of a [fakeClock].
+	fakeTicker struct {
+		*fakeTimer
+	}
+)
+
+// newFakeClock returns a fake clock set to an arbitrary time.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now implements [clock].
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After implements [clock].
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer implements [clock].
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// AfterFunc implements [clock].
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	if d <= 0 {
+		go f()
+		return t
+	}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// NewTicker implements [clock].
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1), period: d}
+	c.timers = append(c.timers, t)
+
+	return fakeTicker{t}
+}
+
+// Advance moves the time forward by d and fires the timers that are due.
+// Tickers fire at most once, dropping ticks like [time.Ticker].
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.timers = slices.DeleteFunc(c.timers, func(t *fakeTimer) bool {
+		if t.at.After(c.now) {
+			return false
+		}
+
+		switch {
+		case t.f != nil:
+			go t.f()
+		case t.period > 0:
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		default:
+			t.c <- c.now
+		}
+		if t.period <= 0 {
+			return true
+		}
+
+		for !t.at.After(c.now) {
+			t.at = t.at.Add(t.period)
+		}
+
+		return false
+	})
+}
+
+// Waiters returns the number of timers that have not fired or been stopped.
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// C implements [timer].
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop implements [ticker].
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+// Stop implements [timer].
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	n := len(t.clock.timers)
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(other *fakeTimer) bool { return other == t })
+
+	return len(t.clock.timers) < n
+}
+
+// TestInstanceRunBackoff tests waiting for the growing restart delay of a
+// failing watched instance, driven by a fake clock.
+func TestInstanceRunBackoff(t *testing.T) {
+	t.Parallel()
+
+	falsePath, err := exec.LookPath("false")
+	require.NoError(t, err)
+
+	clk := newFakeClock()
+	instance := &Instance{
+		Name:          falsePath,
+		Watch:         true,
+		RestartPolicy: RestartPolicy{InitialDelay: time.Minute, Multiplier: 2},
+		clk:           clk,
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- instance.Run(ctx) }()
+
+	// waitDelay waits until the instance waits for its restart delay after
+	// the given number of starts.
+	waitDelay := func(starts int) {
+		t.Helper()
+
+		require.Eventually(t, func() bool {
+			return instance.Stats().Starts == starts && clk.Waiters() == 1
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	waitDelay(1)
+	for starts, delay := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		clk.Advance(delay - time.Second)
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, starts+1, instance.Stats().Starts, "restarted before %v", delay)
+
+		clk.Advance(time.Second)
+		waitDelay(starts + 2)
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+// TestGroupRunClock tests timing the initial delay and the maximum runtime of
+// a group with its clock, which its instances inherit.
+func TestGroupRunClock(t *testing.T) {
+	t.Parallel()
+
+	group, err := New("sleep",
+		WithArgs([]string{"60"}),
+		WithInitialDelay(time.Minute),
+		WithMaxRuntime(time.Hour),
+	)
+	require.NoError(t, err)
+	clk := newFakeClock()
+	group.clk = clk
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run(t.Context()) }()
+
+	// The maximum runtime and the initial delay.
+	require.Eventually(t, func() bool {
+		return clk.Waiters() == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.Zero(t, group.Instances[0].Stats().Starts)
+
+	clk.Advance(time.Minute)
+	require.Eventually(t, group.Instances[0].Running, 5*time.Second, time.Millisecond)
+	assert.Equal(t, clk, group.Instances[0].clock())
+
+	clk.Advance(time.Hour - time.Minute)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "not stopped by the maximum runtime")
+	}
+	assert.Equal(t, TerminationMaxRuntime, group.TerminationReason())
+}
+
+// TestRestartLimiterClock tests pausing restarts over the limit until the
+// window has passed on the clock of the limiter.
+func TestRestartLimiterClock(t *testing.T) {
+	t.Parallel()
+
+	clk := newFakeClock()
+	limiter := newRestartLimiter(slog.New(slog.DiscardHandler), clk, 1, time.Minute)
+	require.NoError(t, limiter.wait(t.Context()))
+
+	done := make(chan error, 1)
+	go func() { done <- limiter.wait(t.Context()) }()
+	require.Eventually(t, func() bool {
+		return clk.Waiters() == 1
+	}, 5*time.Second, time.Millisecond)
+
+	clk.Advance(time.Minute)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "restart not allowed after the window")
+	}
+}
+
+// TestWithRestartWindow tests deferring restarts outside of the restart window
+// until it opens on the clock of the instance.
+func TestWithRestartWindow(t *testing.T) {
+	t.Parallel()
+
+	// The fake clock starts at midnight.
+	tests := map[string]struct {
+		schedule  RestartSchedule
+		wantDefer time.Duration
+	}{
+		"inside": {
+			schedule: RestartSchedule{{Start: 0, End: 0}},
+		},
+		"outside": {
+			schedule:  RestartSchedule{{Start: 2 * time.Hour, End: 3 * time.Hour}},
+			wantDefer: 2 * time.Hour,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := New("true",
+				WithWatch("all"),
+				WithRestartWindow(tt.schedule),
+			)
+			require.NoError(t, err)
+			instance := group.Instances[0]
+			assert.Equal(t, tt.schedule, instance.RestartSchedule)
+
+			handler := &recordHandler{}
+			instance.Logger = slog.New(handler)
+			clk := newFakeClock()
+			group.clk = clk
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			done := make(chan error, 1)
+			go func() { done <- group.Run(ctx) }()
+
+			require.Eventually(t, func() bool {
+				return instance.Stats().Starts == 1 && clk.Waiters() == 1
+			}, 5*time.Second, time.Millisecond)
+
+			if tt.wantDefer > 0 {
+				assert.Equal(t, 1, handler.count("deferring restart"))
+				clk.Advance(tt.wantDefer - time.Second)
+				time.Sleep(50 * time.Millisecond)
+				assert.Equal(t, 1, instance.Stats().Starts, "restarted before the window")
+				assert.Zero(t, handler.count("restarting"))
+			} else {
+				assert.Zero(t, handler.count("deferring restart"))
+			}
+
+			// Past the window start, then through the restart delay.
+			require.Eventually(t, func() bool {
+				clk.Advance(time.Second)
+				return instance.Stats().Starts >= 2
+			}, 5*time.Second, time.Millisecond)
+			assert.Positive(t, handler.count("restarting"))
+
+			cancel()
+			require.NoError(t, <-done)
+		})
+	}
+}
+
+// TestWithHeartbeat tests logging the status at every heartbeat interval on
+// the clock of the group while running, and not after Run returned.
+func TestWithHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordHandler{}
+	group, err := New("sleep",
+		WithArgs([]string{"--", "60", "--", "60"}),
+		WithHeartbeat(time.Minute),
+	)
+	require.NoError(t, err)
+	group.Logger = slog.New(handler)
+	clk := newFakeClock()
+	group.clk = clk
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == 2 && clk.Waiters() == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Zero(t, handler.count("heartbeat"))
+
+	for n := 1; n <= 3; n++ {
+		clk.Advance(time.Minute)
+		require.Eventually(t, func() bool {
+			return handler.count("heartbeat") == n
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+
+	attrs := make(map[string]string)
+	for _, r := range handler.records {
+		if r.Message != "heartbeat" {
+			continue
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+	}
+	assert.Equal(t, map[string]string{
+		"running":   "2",
+		"instances": "2",
+		"statuses":  "[running running]",
+	}, attrs)
+
+	// No heartbeats after Run returned.
+	clk.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, handler.count("heartbeat"))
+}
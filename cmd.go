@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+type (
+	// Cmd is a single execution of an instance's command. Start and Wait
+	// behave like their [exec.Cmd] counterparts, and each Cmd is started at
+	// most once. The command must stop when the context passed to its
+	// [CommandFactory] is done.
+	Cmd interface {
+		Start() error
+		Wait() error
+		// String returns a human-readable description of the command.
+		String() string
+		// Pid returns the process ID after Start, or 0 if there is none.
+		Pid() int
+		// ProcessState returns the state of the exited process after Wait,
+		// or nil if there is none.
+		ProcessState() *os.ProcessState
+	}
+
+	// CommandFactory creates the [Cmd] for each start of an instance. It
+	// allows replacing process execution, for example with fakes in tests.
+	CommandFactory func(ctx context.Context, instance *Instance) Cmd
+
+	// execCmd adapts an [exec.Cmd] to the [Cmd] interface. Outputs are
+	// flushed once the command exits, and then their queue is closed,
+	// passing the number of records it dropped, if any, to dropped. If
+	// start is set, it starts the command in place of [exec.Cmd.Start].
+	// If stopKill is set, it is called once the command has been waited
+	// for, to stop escalating to the kill signal.
+	execCmd struct {
+		cmd      *exec.Cmd
+		outputs  []*lineLogger
+		queue    *outputQueue
+		dropped  func(n int64)
+		start    func(*exec.Cmd) error
+		stopKill func()
+	}
+)
+
+// Start implements [Cmd].
+func (c execCmd) Start() error {
+	start := (*exec.Cmd).Start
+	if c.start != nil {
+		start = c.start
+	}
+
+	err := start(c.cmd)
+	if err != nil && c.queue != nil {
+		// Not waited for, so the queue is closed here.
+		c.queue.close()
+	}
+
+	return err //nolint:wrapcheck // adapter
+}
+
+// Wait implements [Cmd].
+func (c execCmd) Wait() error {
+	err := c.cmd.Wait()
+	if c.stopKill != nil {
+		c.stopKill()
+	}
+	for _, output := range c.outputs {
+		output.Flush()
+	}
+	if c.queue != nil {
+		if n := c.queue.close(); n > 0 && c.dropped != nil {
+			c.dropped(n)
+		}
+	}
+
+	return err
+}
+
+// String implements [Cmd].
+func (c execCmd) String() string {
+	return c.cmd.String()
+}
+
+// Pid implements [Cmd].
+func (c execCmd) Pid() int {
+	if c.cmd.Process == nil {
+		return 0
+	}
+
+	return c.cmd.Process.Pid
+}
+
+// ProcessState implements [Cmd].
+func (c execCmd) ProcessState() *os.ProcessState {
+	return c.cmd.ProcessState
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"time"
+)
+
+const (
+	// configPollInterval is how often a watched config file is checked for
+	// changes.
+	configPollInterval = time.Second
+
+	// configDebounce is how long a watched config file must stay unchanged
+	// after a change before the group is reloaded, so the several writes
+	// of an editor saving it trigger a single reload.
+	configDebounce = 2 * time.Second
+)
+
+// fileState is what a change of a watched file is detected by.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+// statFile returns the state of the file at path. A file that cannot be
+// read, such as while an editor replaces it, is in the zero state.
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// watchConfigFile polls WatchConfigFile until ctx is done and reloads the
+// group once the file has changed and then stayed unchanged for the debounce
+// time.
+func (g *Group) watchConfigFile(ctx context.Context) {
+	interval := cmp.Or(g.configPollInterval, configPollInterval)
+	debounce := cmp.Or(g.configDebounce, configDebounce)
+
+	ticker := g.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	last := statFile(g.WatchConfigFile)
+	var changed time.Time
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C():
+		}
+
+		if current := statFile(g.WatchConfigFile); current != last {
+			last, changed = current, now
+			continue
+		}
+
+		if changed.IsZero() || now.Sub(changed) < debounce {
+			continue
+		}
+		changed = time.Time{}
+
+		g.logger().InfoContext(ctx, "config file changed, reloading",
+			"path", g.WatchConfigFile, "strategy", cmp.Or(g.ReloadStrategy, "parallel"))
+		if err := g.Reload(ctx); err != nil && ctx.Err() == nil {
+			g.logger().WarnContext(ctx, "reload failed", "path", g.WatchConfigFile, "error", err)
+		}
+	}
+}
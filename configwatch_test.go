@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWithWatchConfigFile tests reloading the group once after a watched file
+// was written several times in a row.
+func TestWithWatchConfigFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0o600))
+
+	handler := &recordHandler{}
+	group, err := New("sleep",
+		WithArgs([]string{"60"}),
+		WithLogger(slog.New(handler)),
+		WithWatchConfigFile(path),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, path, group.WatchConfigFile)
+	group.configPollInterval = 10 * time.Millisecond
+	group.configDebounce = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	instance := group.Instances[0]
+	require.NoError(t, group.WaitReady(ctx, 0))
+
+	// Like an editor saving through a temporary file.
+	for _, content := range []string{"", "b", "bc"} {
+		require.NoError(t, os.WriteFile(path+".tmp", []byte(content), 0o600))
+		require.NoError(t, os.Rename(path+".tmp", path))
+		time.Sleep(30 * time.Millisecond)
+	}
+	assert.Equal(t, 1, instance.Stats().Starts)
+
+	require.Eventually(t, func() bool {
+		return instance.Stats().Starts == 2 && instance.Running()
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, 2, instance.Stats().Starts)
+
+	var reloads int
+	for _, r := range handler.records {
+		if r.Message == "config file changed, reloading" {
+			reloads++
+		}
+	}
+	assert.Equal(t, 1, reloads)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+)
+
+// indexKey is the context key of the index of an instance.
+type indexKey struct{}
+
+// IndexFromContext returns the index in its group of the instance a context
+// was passed to by [Group.Run], such as to a hook set with [WithPreStart] or
+// [WithPostStop], so a hook shared by several instances can tell them apart.
+// It reports false for other contexts.
+func IndexFromContext(ctx context.Context) (int, bool) {
+	index, ok := ctx.Value(indexKey{}).(int)
+
+	return index, ok
+}
+
+// withIndex returns a copy of ctx carrying the index of an instance.
+func withIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, indexKey{}, index)
+}
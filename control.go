@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// controlServer serves the control socket of a group. Commands that change
+// the instances run one at a time; status may run alongside them.
+type controlServer struct {
+	group    *Group
+	listener net.Listener
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// listenControl listens on the Unix socket at path. A socket left over at path
+// by a previous run is removed first; any other file is not.
+func listenControl(ctx context.Context, group *Group, path string) (*controlServer, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("control socket: %w", err)
+		}
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+
+	return &controlServer{group: group, listener: listener}, nil
+}
+
+// serve accepts connections until ctx is done, then closes the listener, which
+// removes the socket, and waits for the connections to be handled.
+func (s *controlServer) serve(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.listener.Close()
+	})
+	defer stop()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.group.logger().ErrorContext(ctx, "control socket accept failed", "error", err)
+				_ = s.listener.Close()
+			}
+
+			break
+		}
+
+		s.wg.Go(func() {
+			s.handle(ctx, conn)
+		})
+	}
+
+	s.wg.Wait()
+}
+
+// handle reads one command per line from conn and writes one response line
+// for each, until the client closes the connection or ctx is done.
+func (s *controlServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close() //nolint:errcheck // nothing left to report to
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		response, err := s.execute(ctx, line)
+		if err != nil {
+			s.group.logger().WarnContext(ctx, "control command failed", "command", line, "error", err)
+			response = "error: " + err.Error()
+		} else {
+			s.group.logger().InfoContext(ctx, "control command", "command", line)
+		}
+
+		if _, err := fmt.Fprintln(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a control command and returns its response: "ok", or for
+// status the summary of the group as JSON.
+func (s *controlServer) execute(ctx context.Context, line string) (string, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	if name == "status" {
+		if arg != "" {
+			return "", fmt.Errorf("status: unexpected argument: %q", arg)
+		}
+
+		summary, err := json.Marshal(s.group.Summary())
+		if err != nil {
+			return "", fmt.Errorf("status: %w", err)
+		}
+
+		return string(summary), nil
+	}
+
+	if !s.mu.TryLock() {
+		return "", fmt.Errorf("%s: %w", name, errControlBusy)
+	}
+	defer s.mu.Unlock()
+
+	var err error
+	switch name {
+	case "reload":
+		if arg != "" {
+			return "", fmt.Errorf("reload: unexpected argument: %q", arg)
+		}
+		err = s.group.Reload(ctx)
+	case "restart":
+		err = s.indexed(name, arg, s.group.Restart)
+	case "stop":
+		err = s.indexed(name, arg, s.group.Stop)
+	case "pause":
+		err = s.indexed(name, arg, s.group.Pause)
+	case "resume":
+		err = s.indexed(name, arg, s.group.Resume)
+	default:
+		return "", fmt.Errorf("unknown command: %q", name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "ok", nil
+}
+
+// indexed calls the group method fn of the named command with the instance
+// whose index or label is arg.
+func (s *controlServer) indexed(name, arg string, fn func(index int) error) error {
+	if arg == "" {
+		return fmt.Errorf("%s: missing instance index", name)
+	}
+
+	index, ok := labelIndexes(s.group.Instances)[arg]
+	if !ok {
+		var err error
+		if index, err = strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("%s: not an index or instance label: %q", name, arg)
+		}
+	}
+
+	return fn(index)
+}
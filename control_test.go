@@ -0,0 +1,238 @@
+package main_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cmdgroup "github.com/tho/gokrazy-cmdgroup"
+)
+
+// controlClient sends commands over a control socket.
+type controlClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// dialControl connects to the control socket at path once it is listening.
+func dialControl(t *testing.T, path string) *controlClient {
+	t.Helper()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		var err error
+		conn, err = net.Dial("unix", path)
+
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return &controlClient{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// send sends a command and returns the response line.
+func (c *controlClient) send(t *testing.T, command string) string {
+	t.Helper()
+
+	_, err := c.conn.Write([]byte(command + "\n"))
+	require.NoError(t, err)
+	require.True(t, c.scanner.Scan(), "no response to %q: %v", command, c.scanner.Err())
+
+	return c.scanner.Text()
+}
+
+// TestWithControlSocket tests controlling the instances of a running group
+// through commands sent over its control socket.
+func TestWithControlSocket(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"--", "60", "--", "60"}),
+		cmdgroup.WithLabels([]string{"web", "worker"}),
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithControlSocket(path),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, path, group.ControlSocket)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	for idx := range group.Instances {
+		require.NoError(t, group.WaitReady(ctx, idx))
+	}
+	client := dialControl(t, path)
+
+	assert.Equal(t, "ok", client.send(t, "restart web"))
+	require.NoError(t, group.WaitReady(ctx, 0))
+	require.Eventually(t, func() bool {
+		return group.Instances[0].Stats().Starts == 2 && group.Instances[0].Running()
+	}, 5*time.Second, time.Millisecond)
+
+	assert.Equal(t, "ok", client.send(t, "pause 1"))
+	require.Eventually(t, func() bool {
+		return group.Instances[1].Paused() && !group.Instances[1].Running()
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "ok", client.send(t, "resume worker"))
+	require.NoError(t, group.WaitReady(ctx, 1))
+
+	assert.Equal(t, "ok", client.send(t, "stop 1"))
+	require.Eventually(t, func() bool {
+		return !group.Instances[1].Running()
+	}, 5*time.Second, time.Millisecond)
+
+	var summary cmdgroup.Summary
+	require.NoError(t, json.Unmarshal([]byte(client.send(t, "status")), &summary))
+	require.Len(t, summary.Instances, 2)
+	assert.Equal(t, "web", summary.Instances[0].Label)
+	assert.Equal(t, "running", summary.Instances[0].Status)
+	assert.Equal(t, "stopped", summary.Instances[1].Status)
+
+	assert.Equal(t, "ok", client.send(t, "reload"))
+
+	for command, want := range map[string]string{
+		"restart 2":   "error: restart: index out of range: 2",
+		"restart":     "error: restart: missing instance index",
+		"stop db":     `error: stop: not an index or instance label: "db"`,
+		"status now":  `error: status: unexpected argument: "now"`,
+		"reload 0":    `error: reload: unexpected argument: "0"`,
+		"kill 0":      `error: unknown command: "kill"`,
+		"restart web": "ok",
+	} {
+		assert.Equal(t, want, client.send(t, command), command)
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.NoFileExists(t, path)
+}
+
+// TestWithControlSocketBusy tests rejecting a control command while another
+// one is in progress, except for status.
+func TestWithControlSocketBusy(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	release := make(chan struct{})
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"60"}),
+		cmdgroup.WithReloadStrategy("rolling"),
+		cmdgroup.WithControlSocket(path),
+	)
+	require.NoError(t, err)
+
+	// Hold the second start of the instance, so the rolling reload waits.
+	var starts int
+	group.Instances[0].PreStart = func(ctx context.Context) error {
+		starts++
+		if starts == 1 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.NoError(t, group.WaitReady(ctx, 0))
+	reloading := dialControl(t, path)
+	other := dialControl(t, path)
+
+	reloaded := make(chan string, 1)
+	go func() {
+		_, err := reloading.conn.Write([]byte("reload\n"))
+		if err == nil && reloading.scanner.Scan() {
+			reloaded <- reloading.scanner.Text()
+		}
+		close(reloaded)
+	}()
+	require.Eventually(t, func() bool {
+		return !group.Instances[0].Running()
+	}, 5*time.Second, time.Millisecond)
+
+	assert.Equal(t, "error: restart: another operation is in progress", other.send(t, "restart 0"))
+	assert.Contains(t, other.send(t, "status"), `"status":"stopped"`)
+
+	close(release)
+	assert.Equal(t, "ok", <-reloaded)
+	assert.Equal(t, "ok", other.send(t, "restart 0"))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// TestWithControlSocketPath tests replacing a socket left over at the path of
+// the control socket, but no other file.
+func TestWithControlSocketPath(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		create  func(t *testing.T, path string)
+		wantErr bool
+	}{
+		"missing": {
+			create: func(*testing.T, string) {},
+		},
+		"stale socket": {
+			create: func(t *testing.T, path string) {
+				t.Helper()
+
+				listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+				require.NoError(t, err)
+				listener.SetUnlinkOnClose(false)
+				require.NoError(t, listener.Close())
+			},
+		},
+		"regular file": {
+			create: func(t *testing.T, path string) {
+				t.Helper()
+
+				require.NoError(t, os.WriteFile(path, nil, 0o600))
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "control.sock")
+			tt.create(t, path)
+
+			group, err := cmdgroup.New("true", cmdgroup.WithControlSocket(path))
+			require.NoError(t, err)
+
+			err = group.Run(t.Context())
+			if tt.wantErr {
+				require.ErrorContains(t, err, "control socket")
+				assert.Zero(t, group.Instances[0].Stats().Starts)
+				assert.FileExists(t, path)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NoFileExists(t, path)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// setCPUAffinity restricts the process pid to run on the given CPUs, using
+// sched_setaffinity. Threads and processes it creates afterwards inherit the
+// restriction.
+func setCPUAffinity(pid int, cpus []int) error {
+	const bitsPerWord = 64
+
+	mask := make([]uint64, slices.Max(cpus)/bitsPerWord+1)
+	for _, cpu := range cpus {
+		mask[cpu/bitsPerWord] |= 1 << (cpu % bitsPerWord)
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+		uintptr(pid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0]))) // #nosec G103 -- the mask outlives the call
+	if errno != 0 {
+		return fmt.Errorf("sched_setaffinity: %w", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWithCPUAffinity tests restricting an instance to a CPU, as reported by
+// the Cpus_allowed mask of its process.
+func TestWithCPUAffinity(t *testing.T) {
+	t.Parallel()
+
+	// CPU 0 may not be available to this process, such as in a
+	// container restricted to other CPUs.
+	own := cpusAllowed(t, os.Getpid())
+	if lowest, err := strconv.ParseUint(own[len(own)-1:], 16, 8); err != nil || lowest&1 == 0 {
+		t.Skipf("CPU 0 not available: %s", own)
+	}
+
+	group, err := New("sleep", WithArgs([]string{"60"}), WithCPUAffinity(0, []int{0}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, group.Instances[0].Running, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "1", strings.TrimLeft(cpusAllowed(t, group.Instances[0].PID()), "0,"))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// cpusAllowed returns the Cpus_allowed mask of the process pid.
+func cpusAllowed(t *testing.T, pid int) string {
+	t.Helper()
+
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	require.NoError(t, err)
+	for line := range strings.Lines(string(status)) {
+		if mask, ok := strings.CutPrefix(line, "Cpus_allowed:"); ok {
+			return strings.TrimSpace(mask)
+		}
+	}
+	require.FailNow(t, "no Cpus_allowed in status")
+
+	return ""
+}
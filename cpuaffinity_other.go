@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// setCPUAffinity is only supported on Linux.
+func setCPUAffinity(int, []int) error {
+	return errors.ErrUnsupported
+}
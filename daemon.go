@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// daemonPollInterval is how often a daemon is checked for having
+	// exited, and its pid file for having been written.
+	daemonPollInterval = 100 * time.Millisecond
+
+	// daemonPIDFileTimeout is how long the pid file of a daemon may take
+	// to be written once its launcher exited.
+	daemonPIDFileTimeout = 5 * time.Second
+)
+
+// daemonCmd is a [Cmd] whose process launches a daemon that forks and exits.
+// Once the launcher exits successfully, it monitors the process whose ID is
+// in the pid file instead, and stops it when ctx is done.
+type daemonCmd struct {
+	Cmd
+
+	ctx      context.Context //nolint:containedctx // like exec.Cmd, bound to one execution
+	instance *Instance
+	pidFile  string
+}
+
+// daemon wraps cmd to monitor the daemon it launches if PIDFile is set.
+func (i *Instance) daemon(ctx context.Context, cmd Cmd) Cmd {
+	if i.PIDFile == "" {
+		return cmd
+	}
+
+	return daemonCmd{Cmd: cmd, ctx: ctx, instance: i, pidFile: i.PIDFile}
+}
+
+// Start implements [Cmd]. It removes a pid file left over from an earlier
+// run first, so a stale process ID is never monitored.
+func (c daemonCmd) Start() error {
+	if err := os.Remove(c.pidFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove stale pid file: %w", err)
+	}
+
+	return c.Cmd.Start() //nolint:wrapcheck // adapter
+}
+
+// Wait implements [Cmd]. It waits for the launcher, then for the daemon. A
+// daemon that exits on its own is a failure, since its exit status is not
+// known. A daemon stopped because ctx is done returns the context error.
+func (c daemonCmd) Wait() error {
+	if err := c.Cmd.Wait(); err != nil {
+		return err //nolint:wrapcheck // process exit error
+	}
+
+	logger := c.instance.logger().With("cmd", c.String())
+
+	pid, err := c.readPID()
+	if err != nil {
+		return err
+	}
+
+	c.instance.setPid(pid)
+	c.instance.recordReady()
+	logger.InfoContext(c.ctx, "monitoring daemon", "pid", pid, "pid_file", c.pidFile)
+
+	group := daemonDescendant(pid, c.Pid())
+	if !group {
+		logger.WarnContext(c.ctx, "daemon not known to descend from its launcher, stopping only its process",
+			"pid", pid)
+	}
+
+	ticker := c.instance.clock().NewTicker(daemonPollInterval)
+	defer ticker.Stop()
+
+	for processAlive(pid) {
+		select {
+		case <-c.ctx.Done():
+			return c.stop(pid, group)
+		case <-ticker.C():
+		}
+	}
+
+	return fmt.Errorf("daemon %d exited", pid)
+}
+
+// readPID waits for the pid file and returns the process ID it holds, once
+// that process is alive.
+func (c daemonCmd) readPID() (int, error) {
+	clock := c.instance.clock()
+	deadline := clock.Now().Add(daemonPIDFileTimeout)
+	for {
+		pid, err := readPIDFile(c.pidFile)
+		if err == nil && processAlive(pid) {
+			return pid, nil
+		}
+		if errors.Is(err, errUnsafePID) {
+			return 0, fmt.Errorf("daemon: %w", err)
+		}
+		if err == nil {
+			err = fmt.Errorf("process %d not running", pid)
+		}
+
+		if clock.Now().After(deadline) {
+			return 0, fmt.Errorf("daemon: %w", err)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return 0, c.ctx.Err() //nolint:wrapcheck // expected on shutdown
+		case <-clock.After(daemonPollInterval):
+		}
+	}
+}
+
+// stop stops the daemon like the process of an instance: with its stop
+// signal, then after its kill grace with its kill signal. Its process group is
+// signaled as well if group is set.
+func (c daemonCmd) stop(pid int, group bool) error {
+	i := c.instance
+	killGrace := cmp.Or(max(i.KillGrace, 0), cmdWaitDelay)
+	killSignal := cmp.Or(i.KillSignal, syscall.SIGKILL)
+
+	i.setStopSent(true)
+	for _, step := range []struct {
+		sig     syscall.Signal
+		timeout time.Duration
+	}{
+		{sig: i.stopSignal(), timeout: killGrace},
+		{sig: killSignal, timeout: cmdKillDelay},
+		{sig: syscall.SIGKILL, timeout: cmdKillDelay},
+	} {
+		// Failing to signal a daemon that exited meanwhile is fine.
+		if err := signalDaemon(pid, step.sig, group, i.logger()); err != nil && processAlive(pid) {
+			return fmt.Errorf("stop daemon: %w", err)
+		}
+
+		if waitExited(i.clock(), pid, step.timeout) {
+			return c.ctx.Err() //nolint:wrapcheck // expected on shutdown
+		}
+	}
+
+	return fmt.Errorf("stop daemon: process %d still running", pid)
+}
+
+// signalDaemon sends sig to the daemon pid, and to its process group if group
+// is set. The process group of init and that of this process are never
+// signaled, since they hold unrelated processes.
+func signalDaemon(pid int, sig syscall.Signal, group bool, logger *slog.Logger) error {
+	if group {
+		pgid, err := syscall.Getpgid(pid)
+		if err == nil && pgid > 1 && pgid != syscall.Getpgrp() {
+			getpgid := func(int) (int, error) { return pgid, nil }
+
+			return signalGroup(pid, sig, getpgid, syscall.Kill, logger)
+		}
+	}
+
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("signal process %d: %w", pid, err)
+	}
+
+	return nil
+}
+
+// daemonDescendant reports whether the daemon pid is known to descend from the
+// process launcher, which has exited: it was reparented to this process as a
+// subreaper, or it is still in the process group the launcher was started in.
+func daemonDescendant(pid, launcher int) bool {
+	if ppid, ok := parentPID(pid); ok && ppid == os.Getpid() {
+		return true
+	}
+
+	pgid, err := syscall.Getpgid(pid)
+
+	return err == nil && launcher > 1 && pgid == launcher
+}
+
+// readPIDFile returns the process ID in the pid file at path. The process IDs
+// of init and of this process are rejected with errUnsafePID.
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the pid file is configured by the caller
+	if err != nil {
+		return 0, fmt.Errorf("read pid file: %w", err)
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("read pid file: invalid process ID: %q", bytes.TrimSpace(data))
+	}
+	if pid == 1 || pid == os.Getpid() {
+		return 0, fmt.Errorf("read pid file: %w: %d", errUnsafePID, pid)
+	}
+
+	return pid, nil
+}
+
+// waitExited waits up to timeout, as told by clock, for the process pid to
+// exit and reports whether it did.
+func waitExited(clock clock, pid int, timeout time.Duration) bool {
+	deadline := clock.Now().Add(timeout)
+	for processAlive(pid) {
+		if clock.Now().After(deadline) {
+			return false
+		}
+
+		<-clock.After(daemonPollInterval)
+	}
+
+	return true
+}
+
+// processAlive reports whether the process pid exists and has not exited. A
+// zombie, which has exited but was not reaped yet, is not alive.
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+
+	return !processZombie(pid)
+}
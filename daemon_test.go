@@ -0,0 +1,137 @@
+package main_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cmdgroup "github.com/tho/gokrazy-cmdgroup"
+)
+
+// TestWithExpectDaemon tests monitoring the process a launcher that forks and
+// exits wrote to its pid file instead of the launcher.
+func TestWithExpectDaemon(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// stop stops the daemon, by killing it or canceling the run.
+		stop     func(t *testing.T, pid int, cancel context.CancelFunc)
+		wantExit bool
+	}{
+		"daemon exits": {
+			stop: func(t *testing.T, pid int, _ context.CancelFunc) {
+				t.Helper()
+
+				require.NoError(t, syscall.Kill(pid, syscall.SIGKILL))
+			},
+			wantExit: true,
+		},
+		"instance stopped": {
+			stop: func(_ *testing.T, _ int, cancel context.CancelFunc) {
+				cancel()
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// A stale pid file naming an unrelated process is
+			// removed before the launcher starts.
+			unrelated := exec.Command("sleep", "60")
+			require.NoError(t, unrelated.Start())
+			t.Cleanup(func() {
+				_ = unrelated.Process.Kill()
+				_ = unrelated.Wait()
+			})
+			pidFile := filepath.Join(t.TempDir(), "daemon.pid")
+			require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(unrelated.Process.Pid)+"\n"), 0o600))
+
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs([]string{"-c", `sleep 60 & echo $! >"$0"`, pidFile}),
+				cmdgroup.WithExpectDaemon(0, pidFile),
+			)
+			require.NoError(t, err)
+			instance := group.Instances[0]
+			assert.Equal(t, pidFile, instance.PIDFile)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			done := make(chan error, 1)
+			go func() { done <- group.Run(ctx) }()
+
+			var pid int
+			require.Eventually(t, func() bool {
+				data, err := os.ReadFile(pidFile)
+				if err != nil {
+					return false
+				}
+				pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+
+				return err == nil && pid != unrelated.Process.Pid && instance.PID() == pid
+			}, 5*time.Second, time.Millisecond)
+
+			// The launcher has exited, but the daemon keeps the
+			// instance running.
+			time.Sleep(300 * time.Millisecond)
+			assert.True(t, instance.Running())
+			assert.Equal(t, pid, instance.PID())
+
+			tt.stop(t, pid, cancel)
+			err = <-done
+			if tt.wantExit {
+				require.EqualError(t, err, fmt.Sprintf("daemon %d exited", pid))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.False(t, instance.Running())
+
+			// Exited, though possibly not reaped by init yet.
+			if stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+				assert.Contains(t, string(stat), ") Z ")
+			}
+			assert.NoError(t, unrelated.Process.Signal(syscall.Signal(0)))
+		})
+	}
+}
+
+// TestWithExpectDaemonUnsafePID tests refusing to monitor init or cmdgroup
+// itself as the daemon.
+func TestWithExpectDaemonUnsafePID(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pid int
+	}{
+		"init": {pid: 1},
+		"self": {pid: os.Getpid()},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pidFile := filepath.Join(t.TempDir(), "daemon.pid")
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs([]string{"-c", `echo "$1" >"$0"`, pidFile, strconv.Itoa(tt.pid)}),
+				cmdgroup.WithExpectDaemon(0, pidFile),
+			)
+			require.NoError(t, err)
+
+			start := time.Now()
+			require.ErrorContains(t, group.Run(t.Context()), fmt.Sprintf("daemon: read pid file: unsafe process ID: %d", tt.pid))
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
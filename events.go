@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+)
+
+type (
+	// Event is a lifecycle event of a group or one of its instances.
+	Event struct {
+		Type EventType
+		// Index is the index of the instance, or -1 for events of the
+		// group as a whole.
+		Index int
+		// Time is when the event occurred.
+		Time time.Time
+		// PID is the process ID of a started process, if it has one.
+		PID int
+		// Err is the exit error of an exited process, or the reason of a
+		// shutdown.
+		Err error
+	}
+
+	// EventType is the kind of an [Event].
+	EventType string
+)
+
+const (
+	// EventStarted is emitted when the process of an instance started.
+	EventStarted EventType = "started"
+	// EventExited is emitted when the process of an instance exited.
+	EventExited EventType = "exited"
+	// EventRestarting is emitted when an instance is about to be started
+	// again.
+	EventRestarting EventType = "restarting"
+	// EventReady is emitted once all instances have started.
+	EventReady EventType = "ready"
+	// EventShutdown is emitted when the group starts stopping its
+	// instances.
+	EventShutdown EventType = "shutdown"
+
+	// eventBufferLen is how many events are buffered for a consumer of
+	// [Group.Events] before further events are dropped.
+	eventBufferLen = 64
+)
+
+// Events returns a channel receiving the lifecycle events of the next or
+// current [Group.Run], which closes it when it returns. Events are not waited
+// for: if the consumer falls behind by more than 64 events, further events are
+// dropped and counted by [Group.DroppedEvents].
+func (g *Group) Events() <-chan Event {
+	g.eventsMu.Lock()
+	defer g.eventsMu.Unlock()
+
+	if g.events == nil {
+		g.events = make(chan Event, eventBufferLen)
+	}
+
+	return g.events
+}
+
+// DroppedEvents returns the number of events dropped because the consumer of
+// [Group.Events] fell behind.
+func (g *Group) DroppedEvents() int {
+	return int(g.droppedEvents.Load())
+}
+
+// emit sends the event to the consumer of [Group.Events], if any, without
+// waiting.
+func (g *Group) emit(event Event) {
+	g.eventsMu.Lock()
+	defer g.eventsMu.Unlock()
+
+	if g.events == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = g.clock().Now()
+	}
+
+	select {
+	case g.events <- event:
+	default:
+		g.droppedEvents.Add(1)
+	}
+}
+
+// closeEvents closes the channel returned by [Group.Events], if any, so the
+// next call returns a new one.
+func (g *Group) closeEvents() {
+	g.eventsMu.Lock()
+	defer g.eventsMu.Unlock()
+
+	if g.events != nil {
+		close(g.events)
+		g.events = nil
+	}
+}
@@ -21,6 +21,10 @@ echo "=== Multiple instances with global args ==="
 # Instance 1: ls -l /var
 "$CMDGROUP" ls -l -- /tmp -- /var
 
+echo "=== Instances from stdin ==="
+# One command line per instance; comments and blank lines are skipped.
+printf '# generated\necho one\n\necho "two words"\n' | "$CMDGROUP" -stdin
+
 echo "=== Watched instance (auto-restart) ==="
 # Watch instance 0: "echo" runs, exits, restarts, until we kill cmdgroup.
 # Instance 1 runs once (unwatched).
@@ -30,6 +34,26 @@ sleep 3
 kill "$PID"
 wait "$PID" 2>/dev/null || true
 
+echo "=== Subreaper reaps detached grandchildren ==="
+# The subshell exits immediately, orphaning "sleep 1", which is reparented to
+# cmdgroup and must be reaped once it exits, before instance 1 finishes.
+"$CMDGROUP" -subreaper sh -c -- '(sleep 1 &)' -- 'sleep 3' &
+PID=$!
+sleep 0.5
+ORPHANS=$(ps -o args= --ppid "$PID" | grep -c '^sleep 1$' || true)
+sleep 1.5
+ZOMBIES=$(ps -o stat= --ppid "$PID" | grep -c '^Z' || true)
+wait "$PID"
+if [ "$ORPHANS" -ne 1 ]; then
+    echo "FAIL: orphan not reparented to cmdgroup"
+    exit 1
+elif [ "$ZOMBIES" -ne 0 ]; then
+    echo "FAIL: $ZOMBIES zombie children"
+    exit 1
+else
+    echo "OK: orphan reparented and reaped"
+fi
+
 echo "=== Exit code propagation ==="
 # A failing instance causes a non-zero exit.
 if "$CMDGROUP" false 2>/dev/null; then
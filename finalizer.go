@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// finalizerExitCodesEnv is the environment variable passing the exit codes of
+// the instances to the finalizer.
+const finalizerExitCodesEnv = "CMDGROUP_EXIT_CODES"
+
+// runFinalizer runs the Finalizer command and waits for it to exit. It
+// inherits the standard output and error of this process, and its environment
+// with the exit codes of the instances added. If ctx is done, it is stopped
+// with SIGTERM, then SIGKILL. If r is set, the finalizer is registered with
+// it, so it is not reaped as an orphan before being waited for.
+func (g *Group) runFinalizer(ctx context.Context, r *reaper) error {
+	codes := make([]string, len(g.Instances))
+	for idx, instance := range g.Instances {
+		codes[idx] = strconv.Itoa(instance.Stats().ExitCode)
+	}
+
+	cmd := exec.CommandContext(ctx, g.Finalizer[0], g.Finalizer[1:]...) // #nosec G204 -- the finalizer is configured by the caller
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), finalizerExitCodesEnv+"="+strings.Join(codes, ","))
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = cmdWaitDelay
+
+	logger := g.logger().With("cmd", cmd.String())
+	logger.InfoContext(ctx, "running finalizer", "exit_codes", strings.Join(codes, ","))
+
+	if err := runReaped(r, cmd); err != nil {
+		logger.ErrorContext(ctx, "finalizer failed", "error", err)
+		return fmt.Errorf("finalizer: %w", err)
+	}
+
+	logger.InfoContext(ctx, "finalizer exited")
+
+	return nil
+}
+
+// runReaped runs cmd, registered with r while running, if r is set.
+func runReaped(r *reaper, cmd *exec.Cmd) error {
+	if r == nil {
+		return cmd.Run() //nolint:wrapcheck // wrapped by caller
+	}
+
+	if err := r.start(execCmd{cmd: cmd}); err != nil {
+		return err
+	}
+	defer r.done(cmd.Process.Pid)
+
+	return cmd.Wait() //nolint:wrapcheck // wrapped by caller
+}
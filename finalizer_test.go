@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cmdgroup "github.com/tho/gokrazy-cmdgroup"
+)
+
+// TestWithFinalizer tests running the finalizer with the exit codes of the
+// instances once all of them exited on their own, and not when the group is
+// stopped.
+func TestWithFinalizer(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		args      []string
+		exit      string
+		cancel    bool
+		wantCodes string
+		wantCode  int
+	}{
+		"completed": {
+			args:      []string{"--", "-c", "exit 0", "--", "-c", "exit 3"},
+			exit:      "0",
+			wantCodes: "0,3\n",
+		},
+		"finalizer fails": {
+			args:      []string{"--", "-c", "exit 0", "--", "-c", "exit 3"},
+			exit:      "5",
+			wantCodes: "0,3\n",
+			wantCode:  5,
+		},
+		"canceled": {
+			args:   []string{"--", "-c", "exit 0", "--", "-c", "sleep 60"},
+			exit:   "0",
+			cancel: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out := filepath.Join(t.TempDir(), "codes")
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs(tt.args),
+				cmdgroup.WithOneshot(1, false),
+				cmdgroup.WithFinalizer("sh", "-c", `echo "$CMDGROUP_EXIT_CODES" >"$0"; exit "$1"`, out, tt.exit),
+			)
+			require.NoError(t, err)
+			assert.True(t, filepath.IsAbs(group.Finalizer[0]))
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			if tt.cancel {
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+
+			err = group.Run(ctx)
+			switch {
+			case tt.wantCode != 0:
+				var exitErr *exec.ExitError
+				require.ErrorAs(t, err, &exitErr)
+				assert.Equal(t, tt.wantCode, exitErr.ExitCode())
+				assert.Equal(t, cmdgroup.TerminationFailed, group.TerminationReason())
+			default:
+				require.NoError(t, err)
+			}
+
+			data, err := os.ReadFile(out)
+			if tt.wantCodes == "" {
+				require.ErrorIs(t, err, os.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCodes, string(data))
+		})
+	}
+}
+
+// TestWithFinalizerNotFound tests that New fails if the finalizer cannot be
+// found.
+func TestWithFinalizerNotFound(t *testing.T) {
+	t.Parallel()
+
+	_, err := cmdgroup.New("true", cmdgroup.WithFinalizer("/nonexistent/binary"))
+	require.ErrorContains(t, err, "finalizer: look path")
+}
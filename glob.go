@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// expandGlobs expands arguments containing glob patterns according to mode.
+// In inline mode, each pattern is replaced by its matches. In per-instance
+// mode, an argument set with a pattern is replaced by one argument set per
+// match. Patterns without matches are an error if failOnNoMatch is set, and
+// are kept as is otherwise.
+func expandGlobs(argSets [][]string, mode string, failOnNoMatch bool) ([][]string, error) {
+	switch mode {
+	case "none":
+		return argSets, nil
+	case "inline", "per-instance":
+	default:
+		return nil, fmt.Errorf("expand globs: invalid mode: %q", mode)
+	}
+
+	var expanded [][]string
+	for _, args := range argSets {
+		var (
+			inline   []string
+			patterns int
+			perArg   [][]string // Argument sets produced in per-instance mode.
+		)
+		for idx, arg := range args {
+			matches, err := globArg(arg, failOnNoMatch)
+			if err != nil {
+				return nil, fmt.Errorf("expand globs: %w", err)
+			}
+
+			if mode == "inline" {
+				inline = append(inline, matches...)
+				continue
+			}
+
+			if !isGlobPattern(arg) || (len(matches) == 1 && matches[0] == arg) {
+				continue
+			}
+
+			patterns++
+			for _, match := range matches {
+				perArg = append(perArg, slices.Concat(args[:idx], []string{match}, args[idx+1:]))
+			}
+		}
+
+		switch {
+		case mode == "inline":
+			expanded = append(expanded, inline)
+		case patterns > 1:
+			return nil, errors.New("expand globs: more than one pattern in per-instance mode")
+		case patterns == 1:
+			expanded = append(expanded, perArg...)
+		default:
+			expanded = append(expanded, args)
+		}
+	}
+
+	return expanded, nil
+}
+
+// expandCommandGlobs expands the glob patterns of the arguments of commands
+// as [expandGlobs] does. Commands expanded from another keep its name and
+// template value set.
+func expandCommandGlobs(commands []command, mode string, failOnNoMatch bool) ([]command, error) {
+	expanded := make([]command, 0, len(commands))
+	for _, cmd := range commands {
+		argSets, err := expandGlobs([][]string{cmd.args}, mode, failOnNoMatch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, args := range argSets {
+			expanded = append(expanded, command{name: cmd.name, args: args, values: cmd.values})
+		}
+	}
+
+	return expanded, nil
+}
+
+// globArg returns the matches of arg if it is a pattern, and arg otherwise.
+// A pattern without matches is returned as is, unless failOnNoMatch is set.
+func globArg(arg string, failOnNoMatch bool) ([]string, error) {
+	if !isGlobPattern(arg) {
+		return []string{arg}, nil
+	}
+
+	matches, err := filepath.Glob(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", arg, err)
+	}
+
+	if len(matches) == 0 {
+		if failOnNoMatch {
+			return nil, fmt.Errorf("%q: no matches", arg)
+		}
+
+		return []string{arg}, nil
+	}
+
+	return matches, nil
+}
+
+// isGlobPattern reports whether arg contains glob metacharacters.
+func isGlobPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExpandGlobs tests expanding glob patterns in arguments.
+func TestExpandGlobs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.conf", "b.conf", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	confs := filepath.Join(dir, "*.conf")
+	a, b := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")
+	none := filepath.Join(dir, "*.none")
+
+	tests := map[string]struct {
+		argSets       [][]string
+		mode          string
+		failOnNoMatch bool
+		want          [][]string
+		wantErr       assert.ErrorAssertionFunc
+	}{
+		"none": {
+			argSets: [][]string{{"-c", confs}},
+			mode:    "none",
+			want:    [][]string{{"-c", confs}},
+			wantErr: assert.NoError,
+		},
+		"inline": {
+			argSets: [][]string{{"-c", confs, "-v"}, {"x"}},
+			mode:    "inline",
+			want:    [][]string{{"-c", a, b, "-v"}, {"x"}},
+			wantErr: assert.NoError,
+		},
+		"inline nil args": {
+			argSets: [][]string{nil},
+			mode:    "inline",
+			want:    [][]string{nil},
+			wantErr: assert.NoError,
+		},
+		"per instance": {
+			argSets: [][]string{{"-c", confs, "-v"}, {"x"}},
+			mode:    "per-instance",
+			want:    [][]string{{"-c", a, "-v"}, {"-c", b, "-v"}, {"x"}},
+			wantErr: assert.NoError,
+		},
+		"per instance multiple patterns": {
+			argSets: [][]string{{confs, confs}},
+			mode:    "per-instance",
+			want:    nil,
+			wantErr: assert.Error,
+		},
+		"no match kept": {
+			argSets: [][]string{{none}},
+			mode:    "per-instance",
+			want:    [][]string{{none}},
+			wantErr: assert.NoError,
+		},
+		"no match error": {
+			argSets:       [][]string{{none}},
+			mode:          "inline",
+			failOnNoMatch: true,
+			want:          nil,
+			wantErr:       assert.Error,
+		},
+		"bad pattern": {
+			argSets: [][]string{{"[a"}},
+			mode:    "inline",
+			want:    nil,
+			wantErr: assert.Error,
+		},
+		"invalid mode": {
+			argSets: [][]string{{"x"}},
+			mode:    "all",
+			want:    nil,
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := expandGlobs(tt.argSets, tt.mode, tt.failOnNoMatch)
+			assert.Equal(t, tt.want, got)
+			tt.wantErr(t, err)
+		})
+	}
+}
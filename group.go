@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -18,21 +25,400 @@ type (
 	// Group manages multiple command instances.
 	Group struct {
 		Instances []*Instance
+		// Logger is used for group-wide events. If nil, nothing is logged.
+		Logger *slog.Logger
+		// Subreaper makes this process a child subreaper while running,
+		// reaping orphaned descendants of the instances. Linux only.
+		Subreaper bool
+		// MaxRuntime, if positive, stops all instances once it has passed
+		// since the start of [Group.Run].
+		MaxRuntime time.Duration
+		// ReloadStrategy selects how [Group.Reload] restarts instances:
+		// "parallel" (the default if empty) or "rolling".
+		ReloadStrategy string
+		// OnReady, if set, is called once per [Group.Run] when every
+		// instance has started.
+		OnReady func()
+		// RestartLimit, if positive, is how many automatic restarts all
+		// instances together may make per RestartWindow. Further restarts
+		// are delayed until the window allows them.
+		RestartLimit  int
+		RestartWindow time.Duration
+		// Heartbeat, if positive, is the interval at which the status of
+		// the instances is logged while running.
+		Heartbeat time.Duration
+		// InitialDelay, if positive, is how long [Group.Run] waits before
+		// starting any instance.
+		InitialDelay time.Duration
+		// ShutdownOrder, if set, lists the indexes of instances in the
+		// order they are stopped when the group stops. Each is stopped and
+		// waited for, within its KillGrace, before the next one. Instances
+		// not listed are stopped together afterwards. If nil, all instances
+		// are stopped at once.
+		ShutdownOrder []int
+		// ShutdownTimeout, if positive, bounds an ordered shutdown.
+		// Instances still running once it has passed are killed with
+		// SIGKILL.
+		ShutdownTimeout time.Duration
+		// ControlSocket, if set, is the path of a Unix socket on which
+		// [Group.Run] accepts control commands. See [WithControlSocket].
+		ControlSocket string
+		// WatchConfigFile, if set, is the path of a file that is polled
+		// while running. Once it changes, the group is reloaded. See
+		// [WithWatchConfigFile].
+		WatchConfigFile string
+		// Finalizer, if set, is the command line of a command run once
+		// all instances exited on their own, with the resolved path of
+		// the command first. See [WithFinalizer].
+		Finalizer []string
+		// StopOnFirstSuccess stops the group once an instance exits
+		// successfully. See [WithStopOnFirstSuccess].
+		StopOnFirstSuccess bool
+
+		unresolved bool
+		// clk, if set, replaces the real clock of the group and of its
+		// instances without one, for tests.
+		clk                clock
+		terminationMu      sync.Mutex
+		termination        TerminationReason
+		configPollInterval time.Duration
+		configDebounce     time.Duration
+		stopsMu            sync.Mutex
+		stops              []context.CancelFunc
+		eventsMu           sync.Mutex
+		events             chan Event
+		droppedEvents      atomic.Int64
 	}
 
 	// Instance represents a single command execution with its configuration.
+	// If Builtin is set, it runs in-process in place of the command Name. If
+	// StopGroupOnExit is set, the group is stopped when the instance exits.
+	// PreStart, if set, runs before every start of the command, and PostStop
+	// after every exit.
 	Instance struct {
 		Name   string
 		Args   []string
 		Watch  bool
 		Logger *slog.Logger
+		// Label, if set, names the instance: its logs carry it as the
+		// instance attribute, watch specifications may select it by it
+		// in place of its index, and the summary reports it.
+		Label   string
+		Builtin BuiltinFunc
+		// CommandFactory, if set, creates the command for each start in
+		// place of [os/exec]. It takes precedence over Builtin.
+		CommandFactory  CommandFactory
+		StopGroupOnExit bool
+		// Oneshot runs the instance once and never restarts it, even if
+		// Watch is set. Its failure only fails the group if OneshotFatal is
+		// set.
+		Oneshot      bool
+		OneshotFatal bool
+		PreStart     func(ctx context.Context) error
+		PostStop     func(ctx context.Context) error
+		// LogOutput logs each line of the command's stdout and stderr
+		// through Logger instead of passing them through. Lines longer
+		// than MaxLineBytes (default 64 KiB) are logged truncated.
+		LogOutput    bool
+		MaxLineBytes int
+		// OutputFlush, if positive, collects the lines logged with
+		// LogOutput and logs them together as one record at this
+		// interval, or once they reach MaxLineBytes, and when the command
+		// exits.
+		OutputFlush time.Duration
+		// OutputOverflow is what happens to the output logged with
+		// LogOutput once 64 records wait for a slow log handler: with
+		// "block", the default if empty, the command waits for room; with
+		// "drop", further records are dropped and counted in
+		// [Stats.DroppedOutput].
+		OutputOverflow string
+		// MaxOutputBytes, if positive, is how many bytes of output each
+		// process of the command may emit on stdout and stderr together.
+		// Further output is dropped, which is logged once.
+		MaxOutputBytes int64
+		// LogRunTimes adds how long the process took to become ready
+		// and how long it ran to the record logged when it exits.
+		LogRunTimes bool
+		// DependsOn lists the indexes of instances in the group this
+		// instance depends on. It is restarted whenever one of them is.
+		DependsOn []int
+		// Argv0, if set, is passed to the command as argv[0] in place of
+		// Name. Name remains the path that is executed.
+		Argv0 string
+		// Env lists environment variables in the form "key=value" that are
+		// added to the environment inherited from this process.
+		Env []string
+		// CleanEnv starts the command with only the variables in Env
+		// instead of inheriting the environment of this process.
+		CleanEnv bool
+		// ArgsProvider, if set, is called before every start of the command
+		// with the number of previous starts. The arguments it returns
+		// replace Args for that start, unless they are nil. It does not
+		// apply to commands created by a CommandFactory.
+		ArgsProvider func(attempt int) []string
+		// ValidateArgs, if set, checks the arguments before every start of
+		// the command. If it fails, the command is not started and Run
+		// returns the error.
+		ValidateArgs func(args []string) error
+		// StopSignal is sent to the process group of the command to stop
+		// it, and a command terminated by it is not considered failed. If
+		// zero, SIGTERM is used.
+		StopSignal syscall.Signal
+		// ForceKill stops the command with SIGKILL right away, without a
+		// grace period, taking precedence over StopSignal. It is meant
+		// for commands known not to respond to other signals.
+		ForceKill bool
+		// KillGrace is how long the command may take to exit after the
+		// stop signal before KillSignal is sent. If zero, 10s is used.
+		KillGrace time.Duration
+		// KillSignal is sent to the process group of a command that did
+		// not exit within KillGrace. If zero, SIGKILL is used. Other
+		// signals can be caught or ignored, so the command is still killed
+		// with SIGKILL if it does not exit within 5s of KillSignal.
+		KillSignal syscall.Signal
+		// PIDFile, if set, marks the command as the launcher of a daemon
+		// that forks and exits. Once the command exits successfully, the
+		// process whose ID it wrote to this file is monitored in its place:
+		// the instance runs until that process exits, which is a failure,
+		// and stopping the instance signals it. See [WithExpectDaemon].
+		PIDFile string
+		// Setsid starts the command in a new session, detached from any
+		// controlling terminal, instead of only in a new process group.
+		Setsid bool
+		// SharedProcessGroup runs the command in the process group of this
+		// process instead of a new one, and only the command itself is
+		// signaled to stop. It has no effect with Setsid.
+		SharedProcessGroup bool
+		// ProcessGroupFallback retries a start that failed because
+		// creating a process group was not permitted once, as with
+		// SharedProcessGroup, and logs a warning. If that succeeds, later
+		// starts use the shared process group as well.
+		ProcessGroupFallback bool
+		// CaptureOutput keeps the stdout of the most recent process in
+		// memory, available from [Instance.Output], instead of passing it
+		// through. It is still logged with LogOutput. Builtins and commands
+		// created by a CommandFactory are not captured.
+		CaptureOutput bool
+		// DiscardOutput discards the stdout and stderr of the command
+		// instead of passing them through or logging them with
+		// LogOutput. Stdout is still captured with CaptureOutput.
+		// Builtins and commands created by a CommandFactory are not
+		// affected.
+		DiscardOutput bool
+		// StrictExit counts a command terminated by its stop signal as
+		// failed, unless the signal was sent to stop it, such as on
+		// shutdown or restart.
+		StrictExit bool
+		// RestartPolicy decides whether and when a watched instance is
+		// restarted after its command exited.
+		RestartPolicy RestartPolicy
+		// ShouldRestart, if set, decides in place of RestartPolicy
+		// whether and after which delay a watched instance is restarted
+		// after its command exited. See [WithShouldRestart].
+		ShouldRestart func(i *Instance, result InstanceResult) (bool, time.Duration)
+		// ExitCodeMap maps exit codes of the command to the codes they
+		// are interpreted as, such as 2 to 0 for a command exiting with 2
+		// on success. A code mapped to 0 is a clean exit.
+		ExitCodeMap map[int]int
+		// Cgroup, if set, is the path of a cgroup v2 directory the
+		// process is moved into after it starts. If that fails, the
+		// process is stopped and Run returns the error. Linux only.
+		Cgroup string
+		// CPUAffinity, if set, lists the CPUs the process is restricted
+		// to after it starts. If that fails, the process is stopped and
+		// Run returns the error. Linux only.
+		CPUAffinity []int
+		// RestartSchedule lists the daily times at which a watched
+		// instance may be restarted automatically. Outside of them, a
+		// restart is deferred until the schedule allows it.
+		RestartSchedule RestartSchedule
+
+		mu        sync.Mutex
+		lastUsage Usage
+		cancelRun context.CancelCauseFunc
+		// active is set while Run executes, and ran once it was called.
+		active bool
+		ran    bool
+		// wasReady is set once a process became ready.
+		wasReady bool
+		pid      int
+		stopSent bool
+		paused   chan struct{}
+		// templates, if set, are the templates the arguments and
+		// environment variables are rendered from at every start.
+		templates *startTemplates
+		// noProcessGroup is set once ProcessGroupFallback applied.
+		noProcessGroup bool
+		// startExec, if set, starts commands in place of
+		// [exec.Cmd.Start], for tests.
+		startExec func(*exec.Cmd) error
+		// clk, if set, replaces the real clock, for tests.
+		clk       clock
+		stats     Stats
+		onStart   func()
+		onRestart func()
+		onEvent   func(Event)
+		reaper    *reaper
+		limiter   *restartLimiter
+		output    *outputBuffer
+	}
+
+	// Stats holds runtime statistics of an instance.
+	Stats struct {
+		// Starts is the number of processes started.
+		Starts int
+		// FirstStart is when the first process started.
+		FirstStart time.Time
+		// StartedAt is when the most recent process started.
+		StartedAt time.Time
+		// ReadyAt is when the most recent process became ready: once it
+		// started, or with PIDFile, once the daemon it launched was found
+		// running. It is zero until then.
+		ReadyAt time.Time
+		// LastExit is when the most recent process exited.
+		LastExit time.Time
+		// LastErr is the exit error of the most recent process.
+		LastErr error
+		// ExitCode is the exit code of the most recent process, or -1 if
+		// it was terminated by a signal or did not report one.
+		ExitCode int
+		// Exits holds the exits of the most recent processes, oldest
+		// first, up to 10.
+		Exits []Exit
+		// Stdout and Stderr count the output of all processes on each
+		// stream. Only output copied by this process, as with LogOutput,
+		// CaptureOutput or MaxOutputBytes, is counted. Output passed
+		// through to the stdout and stderr of this process, the default,
+		// or discarded with DiscardOutput is written by the command
+		// directly, so its counter stays 0.
+		Stdout, Stderr OutputStats
+		// DroppedOutput is the number of records of output logged with
+		// LogOutput that were dropped by the "drop" OutputOverflow.
+		DroppedOutput int64
+	}
+
+	// OutputStats counts the output of a stream.
+	OutputStats struct {
+		// Bytes is the number of bytes written.
+		Bytes int64
+		// Lines is the number of newline characters written.
+		Lines int64
+	}
+
+	// Exit describes how a process exited.
+	Exit struct {
+		// StartedAt is when the process started.
+		StartedAt time.Time
+		// ReadyAt is when the process became ready, see
+		// [Stats.ReadyAt], or zero if it exited before.
+		ReadyAt time.Time
+		// Time is when the process exited.
+		Time time.Time
+		// ExitCode is the exit code of the process, or -1 if it was
+		// terminated by a signal or did not report one.
+		ExitCode int
+		// Signal is the name of the signal that terminated the process,
+		// or empty if there is none.
+		Signal string
 	}
 
 	// Options holds configuration for creating a new group.
 	Options struct {
+		args            []string
+		watch           string
+		watchFunc       func(i *Instance) bool
+		logger          *slog.Logger
+		builtinFallback bool
+		templateValues  []map[string]string
+		startTemplates  bool
+		strictWatch     bool
+		// globalArgs is the number of global arguments prepended to the
+		// arguments of every instance, or -1 if the instances are not
+		// split from "--" sections.
+		globalArgs      int
+		stopGroupOnExit string
+		instanceOptions []instanceOption
+		commandFactory  CommandFactory
+		logOutput       bool
+		logRunTimes     bool
+		maxLineBytes    int
+		outputFlush     time.Duration
+		outputOverflow  string
+		maxOutputBytes  int64
+		quiet           bool
+		subreaper       bool
+		maxRuntime      time.Duration
+		globMode        string
+		globNoMatchFail bool
+		skipLookup      bool
+		lazyLookup      bool
+		indexEnv        string
+		env             []string
+		labels          []string
+		stopSignal      syscall.Signal
+		killGrace       time.Duration
+		killSignal      syscall.Signal
+		setsid          bool
+		reloadStrategy  string
+		cleanEnv        bool
+		onReady         func()
+		argValidator    func(index int, args []string) error
+		restartLimit    int
+		restartWindow   time.Duration
+		heartbeat       time.Duration
+		initialDelay    time.Duration
+		processGroup    bool
+		pgFallback      bool
+		shutdownOrder   []int
+		shutdownTimeout time.Duration
+		controlSocket   string
+		watchConfigFile string
+		finalizer       []string
+		firstSuccess    bool
+		strictExit      bool
+		restartPolicy   RestartPolicy
+		warmup          time.Duration
+		shouldRestart   func(i *Instance, result InstanceResult) (bool, time.Duration)
+		instanceSource  string
+		cgroup          string
+		restartSchedule RestartSchedule
+		argMax          int
+	}
+
+	// indexRangeError reports an instance index beyond the instances of a
+	// group, with a description of how they were generated, if known.
+	indexRangeError struct {
+		index  int
+		count  int
+		source string
+	}
+
+	// mappedExitError is the exit error of a process whose exit code is
+	// mapped to another nonzero code by ExitCodeMap.
+	mappedExitError struct {
+		code int
+		err  error
+	}
+
+	// command is the name and arguments of an instance before resolution,
+	// and the template value set it was expanded with, if any.
+	command struct {
+		name   string
 		args   []string
-		watch  string
-		logger *slog.Logger
+		values map[string]string
+	}
+
+	// lookup is the result of resolving a command name.
+	lookup struct {
+		path    string
+		builtin BuiltinFunc
+		err     error
+	}
+
+	// instanceOption configures the instance at an index after creation.
+	instanceOption struct {
+		index     int
+		configure func(*Instance)
 	}
 
 	// Option is a functional option for configuring a group.
@@ -47,221 +433,3016 @@ const (
 	// cmdWaitDelay is how long to wait for an instance to exit after
 	// SIGTERM.  After this time, the process is killed.
 	cmdWaitDelay = 10 * time.Second
+
+	// cmdKillDelay is how long to wait for an instance to exit after a
+	// kill signal other than SIGKILL. After this time, the process is
+	// killed with SIGKILL.
+	cmdKillDelay = 5 * time.Second
+
+	// reloadPollInterval is how often a rolling reload checks whether a
+	// restarted instance has started again.
+	reloadPollInterval = 10 * time.Millisecond
+
+	// reloadStartTimeout is how long a rolling reload waits for a restarted
+	// instance to start again before moving on to the next one.
+	reloadStartTimeout = time.Minute
+
+	// defaultArgMax is the default limit on the size of the arguments and
+	// environment of a command, the ARG_MAX of Linux with the default
+	// stack size limit of 8 MiB.
+	defaultArgMax = 2 * 1024 * 1024
+
+	// exitHistoryLen is how many exits [Stats] holds per instance.
+	exitHistoryLen = 10
+
+	// quickExitThreshold is how long a process must run for its clean exit
+	// not to count as immediate.
+	quickExitThreshold = time.Second
+
+	// quickExitWarnCount is how many consecutive clean and immediate exits
+	// of a watched instance trigger a warning that it may not need to be
+	// watched.
+	quickExitWarnCount = 3
+)
+
+var (
+	// errRestartRequested is the cancellation cause of a process stopped by
+	// [Instance.Restart].
+	errRestartRequested = errors.New("restart requested")
+
+	// errPauseRequested is the cancellation cause of a process stopped by
+	// [Instance.Pause].
+	errPauseRequested = errors.New("pause requested")
+
+	// errRestartAttempts is the reason a watched instance is not restarted
+	// once its restart policy allows no more attempts.
+	errRestartAttempts = errors.New("max restart attempts reached")
+
+	// errRestartDeclined is the reason a watched instance is not restarted
+	// once its ShouldRestart function declined.
+	errRestartDeclined = errors.New("restart declined")
+
+	// errMaxRuntime is the cancellation cause of a group stopped by its
+	// maximum runtime.
+	errMaxRuntime = errors.New("max runtime reached")
+
+	// errUnsafePID is the error of a pid file naming init or this process,
+	// which are never monitored or signaled as a daemon.
+	errUnsafePID = errors.New("unsafe process ID")
+
+	// errReloadTimeout is the error of a rolling reload waiting too long
+	// for an instance to start again.
+	errReloadTimeout = errors.New("instance did not start again in time")
+
+	// errNotReady is the error of waiting for an instance to be ready that
+	// stopped being run without becoming ready.
+	errNotReady = errors.New("instance stopped before becoming ready")
+
+	// errStopGroupOnExit is the cancellation cause of a group stopped by
+	// the exit of an instance with StopGroupOnExit.
+	errStopGroupOnExit = errors.New("instance with stop group on exit exited")
+
+	// errFirstSuccess is the cancellation cause of a group stopped by the
+	// successful exit of an instance with StopOnFirstSuccess.
+	errFirstSuccess = errors.New("instance exited successfully")
+
+	// errControlBusy is the error of a control command received while
+	// another one changing the instances is in progress.
+	errControlBusy = errors.New("another operation is in progress")
 )
 
-// WithArgs sets the command arguments for the group.
+// WithArgs sets the command arguments for the group, replacing any set by
+// earlier options.
 func WithArgs(args []string) Option {
 	return func(o *Options) {
 		o.args = args
 	}
 }
 
+// WithAppendArgs appends to the command arguments set by earlier options, such
+// as to build them from multiple sources. Arguments may include "--"
+// separators.
+func WithAppendArgs(args []string) Option {
+	return func(o *Options) {
+		o.args = slices.Concat(o.args, args)
+	}
+}
+
 // WithWatch sets which command instances should be monitored and restarted.
+// Since one-shot instances are never restarted, [New] fails if one of them is
+// selected by index or label; "all" selects only the others. It replaces a
+// predicate set by an earlier [WithWatchFunc].
 func WithWatch(watch string) Option {
 	return func(o *Options) {
 		o.watch = cmp.Or(watch, "none")
+		o.watchFunc = nil
+	}
+}
+
+// WithWatchFunc sets which command instances should be monitored and restarted
+// by a predicate, such as for generated instances, in place of the indexes and
+// labels of [WithWatch]: whichever of the two options comes last applies. [New]
+// calls watch once for each instance after applying all other options, so it
+// can inspect their arguments and labels, and fails if it selects a one-shot
+// instance.
+func WithWatchFunc(watch func(i *Instance) bool) Option {
+	return func(o *Options) {
+		o.watch = "none"
+		o.watchFunc = watch
 	}
 }
 
-// WithLogger sets the logger for all instances in the group.
+// WithLogger sets the logger for all instances in the group. To change the
+// level at runtime, create its handler with a [slog.LevelVar] as level: the
+// loggers derived from it for each instance follow the change.
 func WithLogger(logger *slog.Logger) Option {
 	return func(o *Options) {
 		o.logger = logger
 	}
 }
 
-// New creates a command group for the specified command name and options.
-// Arguments before the first "--" separator are global args prepended to every
-// instance. Each "--"-delimited section after that defines a separate instance.
-// If no "--" separators are present, a single instance receives all arguments.
-// By default, no instances are watched and no logging is performed.
-func New(name string, options ...Option) (*Group, error) {
-	opts := &Options{
-		args:   nil,
-		watch:  "none",
-		logger: slog.New(slog.DiscardHandler),
+// WithBuiltinFallback sets whether a minimal in-process implementation is used
+// when the command cannot be found. Only "echo" and "true" are available. This
+// is intended for testing and is disabled by default.
+func WithBuiltinFallback(enabled bool) Option {
+	return func(o *Options) {
+		o.builtinFallback = enabled
 	}
-	for _, option := range options {
-		option(opts)
+}
+
+// WithTemplateValues expands every instance once per value set, rendering
+// each argument as a [text/template] with the value set as data. For example,
+// the argument "--port={{.port}}" with the value sets {"port": "80"} and
+// {"port": "443"} yields two instances. Referencing a key missing from a value
+// set is an error.
+func WithTemplateValues(values []map[string]string) Option {
+	return func(o *Options) {
+		o.templateValues = values
 	}
-	if opts.logger == nil {
-		return nil, errors.New("nil logger")
+}
+
+// WithGlobExpand sets how arguments containing glob patterns (see
+// [filepath.Match]) are expanded: "none" (default) passes them through,
+// "inline" replaces each pattern by its matches, and "per-instance" creates one
+// instance per match, which allows a single pattern per instance. Patterns
+// without matches are an error if failOnNoMatch is set, and are passed
+// through otherwise. Expansion happens after template expansion.
+func WithGlobExpand(mode string, failOnNoMatch bool) Option {
+	return func(o *Options) {
+		o.globMode = cmp.Or(mode, "none")
+		o.globNoMatchFail = failOnNoMatch
 	}
+}
 
-	path, err := exec.LookPath(name)
-	if err != nil {
-		return nil, fmt.Errorf("look path: %w", err)
+// WithStrictWatch sets whether a numeric watch specification is validated
+// strictly. In strict mode, instances without arguments of their own (as
+// created by a trailing or repeated "--"), instances identical to another one,
+// and duplicate watch indexes are rejected, since they usually mean the watch
+// indexes are off by one. Instances are checked as generated by template and
+// glob expansion.
+func WithStrictWatch(strict bool) Option {
+	return func(o *Options) {
+		o.strictWatch = strict
 	}
+}
 
-	var (
-		instances  []*Instance
-		args       = parseArgs(opts.args)
-		globalArgs = args[0] // parseArgs always returns at least one element
-	)
-	for _, args := range args[1:] {
-		instances = append(instances, &Instance{
-			Name:   path,
-			Args:   slices.Concat(globalArgs, args),
-			Watch:  false,
-			Logger: opts.logger,
-		})
+// WithStopGroupOnExit sets which instances stop the whole group when they exit,
+// regardless of exit status: "none" (default), "all", or a comma-separated
+// list of indexes. This suits tightly coupled instances such as sidecars. Note
+// that watched instances are restarted rather than exiting, unless they fail
+// to start.
+func WithStopGroupOnExit(stopGroupOnExit string) Option {
+	return func(o *Options) {
+		o.stopGroupOnExit = cmp.Or(stopGroupOnExit, "none")
 	}
-	if len(instances) == 0 {
-		instances = append(instances, &Instance{
-			Name:   path,
-			Args:   globalArgs,
-			Watch:  false,
-			Logger: opts.logger,
-		})
+}
+
+// WithStopOnFirstSuccess sets whether the group stops once any instance exits
+// successfully, with exit code 0 or one mapped to it, stopping the others. This
+// suits redundant attempts racing each other, such as fetching a file from
+// several mirrors, where whichever finishes first wins. [Group.Run] then
+// returns nil, since the failures of the other instances are expected. An
+// instance failing does not stop the others, so the group only fails once all
+// of them have failed. Note that watched instances are restarted rather than
+// exiting, so only unwatched instances win, and one-shot instances, such as
+// initialization steps, never do.
+func WithStopOnFirstSuccess(stop bool) Option {
+	return func(o *Options) {
+		o.firstSuccess = stop
 	}
+}
 
-	if err := applyWatch(instances, opts.watch); err != nil {
-		return nil, err
+// WithPreStart sets a setup function for the instance at index. It runs before
+// every start of the command, including restarts of a watched instance. If it
+// fails, the command is not started and the failure is handled like a failed
+// exit: a watched instance is retried after the restart delay, an unwatched
+// instance returns the error.
+func WithPreStart(index int, setup func(ctx context.Context) error) Option {
+	return withInstance(index, func(i *Instance) {
+		i.PreStart = setup
+	})
+}
+
+// WithOneshot marks the instance at index as a one-shot task, such as an
+// initialization step. It runs once and is never restarted, so it must not be
+// selected by [WithWatch] by index or label. If fatal is set, a failed exit
+// fails the group and stops the remaining instances, like an unwatched
+// instance. Otherwise, the failure is logged and ignored.
+func WithOneshot(index int, fatal bool) Option {
+	return withInstance(index, func(i *Instance) {
+		i.Oneshot = true
+		i.OneshotFatal = fatal
+	})
+}
+
+// WithExpectDaemon marks the command of the instance at index as the launcher
+// of a legacy daemon that forks and exits, which would otherwise defeat
+// supervision. Once the launcher exits successfully, the process whose ID it
+// wrote to pidFile is monitored instead, polling its liveness: while it runs,
+// so does the instance, and [Instance.PID] is its process ID. Since the exit
+// status of the daemon is unknown, its exit is a failure, restarting a watched
+// instance. Stopping the instance sends the stop signal to the daemon, and the
+// kill signal after the kill grace, as for other commands. Its process group is
+// signaled too if the daemon is known to descend from the launcher, by being in
+// the launcher's process group or reparented to cmdgroup as a subreaper, but
+// never the group of init or cmdgroup. A pid file naming init or cmdgroup
+// itself fails the instance. The launcher must write pidFile within 5s of
+// exiting. A pid file left over from an earlier run is removed before every
+// start, so a stale process ID is never monitored. It has no effect on builtins
+// and commands created by a [CommandFactory].
+func WithExpectDaemon(index int, pidFile string) Option {
+	return withInstance(index, func(i *Instance) {
+		i.PIDFile = pidFile
+	})
+}
+
+// WithCPUAffinity restricts the process of the instance at index to the given
+// CPUs, numbered from 0, such as for cache locality or to keep it off CPUs
+// reserved for other work. It is applied with sched_setaffinity right after
+// every start, to the process of the command only: with a process group,
+// descendants it creates afterwards inherit it, but those of a wrapper such as
+// a shell started before are not restricted. CPUs must be less than
+// [runtime.NumCPU]. Linux only.
+func WithCPUAffinity(index int, cpus []int) Option {
+	return withInstance(index, func(i *Instance) {
+		i.CPUAffinity = slices.Clone(cpus)
+	})
+}
+
+// WithPostStop sets a cleanup function for the instance at index. It runs after
+// every exit of the command, regardless of exit status, including the final
+// exit during shutdown. Its context is not cancelled by shutdown. Cleanup
+// errors are logged and do not replace the exit error of the instance.
+func WithPostStop(index int, cleanup func(ctx context.Context) error) Option {
+	return withInstance(index, func(i *Instance) {
+		i.PostStop = cleanup
+	})
+}
+
+// WithCommandFactory sets the factory creating the command of every instance
+// in place of [os/exec]. Command names are not resolved on the PATH. This is
+// mainly useful to inject fakes in tests.
+func WithCommandFactory(factory CommandFactory) Option {
+	return func(o *Options) {
+		o.commandFactory = factory
 	}
+}
 
-	return &Group{Instances: instances}, nil
+// WithDependsOn declares that the instance at index depends on the instance at
+// dependsOnIndex. Whenever the dependency is restarted, the dependent is
+// restarted once the dependency has started again, so it does not keep using
+// a stale dependency. Dependents of dependents are restarted in turn. New
+// fails if the dependencies form a cycle.
+func WithDependsOn(index, dependsOnIndex int) Option {
+	return withInstance(index, func(i *Instance) {
+		i.DependsOn = append(i.DependsOn, dependsOnIndex)
+	})
 }
 
-// applyWatch configures which instances should be monitored and restarted.
-func applyWatch(instances []*Instance, watch string) error {
-	switch watch {
-	case "none":
-		return nil
-	case "all":
-		for i := range instances {
-			instances[i].Watch = true
-		}
+// WithLogOutput sets whether each line of the commands' stdout and stderr is
+// logged as a record through the group logger instead of being passed through.
+func WithLogOutput(enabled bool) Option {
+	return func(o *Options) {
+		o.logOutput = enabled
+	}
+}
 
-		return nil
-	default:
-		indexes, err := parseInts(watch)
-		if err != nil {
-			return fmt.Errorf("parse watch: %w", err)
-		}
+// WithLogRunTimes sets whether the record logged when a process exits includes
+// how long it took to become ready, as startup, and how long it ran since it
+// started, as run_time, such as to find services that are slow to start. The
+// times are recorded in [Stats] and [Exit] regardless.
+func WithLogRunTimes(enabled bool) Option {
+	return func(o *Options) {
+		o.logRunTimes = enabled
+	}
+}
 
-		for _, index := range indexes {
-			if index < 0 || index >= len(instances) {
-				return fmt.Errorf("parse watch: index out of range: %d", index)
-			}
+// WithMaxLineBytes sets the maximum length of an output line logged with
+// [WithLogOutput]. Longer lines are logged truncated, with a truncated
+// attribute, and the rest of the line is discarded. A non-positive value
+// selects the default of 64 KiB.
+func WithMaxLineBytes(n int) Option {
+	return func(o *Options) {
+		o.maxLineBytes = n
+	}
+}
 
-			instances[index].Watch = true
-		}
+// WithOutputFlush sets the interval at which lines logged with [WithLogOutput]
+// are logged together as one record, instead of each as soon as it is
+// complete, to reduce writes to slow storage such as SD cards. Collected lines
+// are also logged once they reach the maximum line length and when the command
+// exits, including when it is killed on shutdown, so they are all logged before
+// [Group.Run] returns. A non-positive interval, the default, disables batching.
+func WithOutputFlush(interval time.Duration) Option {
+	return func(o *Options) {
+		o.outputFlush = interval
+	}
+}
 
-		return nil
+// WithOutputOverflow sets what happens to output logged with [WithLogOutput]
+// when the log handler falls behind a burst. Up to 64 records are queued, so
+// memory use stays bounded. Once the queue is full, with "block", the default,
+// the command waits for room as its pipe fills up, so no output is lost; with
+// "drop", further records are dropped until there is room again, so the
+// command is never slowed down. An empty policy selects the default. Dropped
+// records are counted in [Stats.DroppedOutput] and logged as a warning when
+// the command exits.
+func WithOutputOverflow(policy string) Option {
+	return func(o *Options) {
+		o.outputOverflow = policy
 	}
 }
 
-// Run executes all command instances in parallel and waits for them to complete.
-// If an unwatched instance exits with an error, the group context is cancelled
-// and all remaining instances are terminated. Watched instances that exit with
-// an error (including a start failure) do not cancel the group.
-func (g *Group) Run(ctx context.Context) error {
-	ctx, cancel := context.WithCancelCause(ctx)
-	defer cancel(nil)
+// WithCgroup moves the process of every instance into the cgroup v2 directory
+// at path, such as /sys/fs/cgroup/services, right after it starts, for
+// resource accounting and limits. Descendants it creates afterwards inherit
+// the cgroup. The cgroup must exist, and cmdgroup needs write access to its
+// cgroup.procs file and to that of the closest common ancestor of its own
+// cgroup and path, as with cgroup delegation. Linux only.
+func WithCgroup(path string) Option {
+	return func(o *Options) {
+		o.cgroup = path
+	}
+}
 
-	var wg sync.WaitGroup
+// WithMaxOutputBytes sets how many bytes of output each process may emit on
+// stdout and stderr together, to protect storage from runaway output. Output
+// beyond that is dropped, and a warning is logged once. The count starts over
+// when the command is restarted. A non-positive n, the default, means no
+// limit.
+func WithMaxOutputBytes(n int64) Option {
+	return func(o *Options) {
+		o.maxOutputBytes = n
+	}
+}
 
-	errs := make([]error, len(g.Instances))
-	for idx, instance := range g.Instances {
-		wg.Go(func() {
-			errs[idx] = checkErr(instance.Run(ctx))
-			if errs[idx] != nil && !instance.Watch {
-				cancel(errs[idx])
-			}
-		})
+// WithRestartWindow sets the daily times at which watched instances may be
+// restarted automatically, such as to keep a flapping service from restarting
+// during peak hours. Outside of them, an exited instance stays down until the
+// schedule allows a restart. Explicit restarts are not deferred. An empty
+// schedule, the default, allows restarts at any time.
+func WithRestartWindow(schedule RestartSchedule) Option {
+	return func(o *Options) {
+		o.restartSchedule = schedule
 	}
+}
 
-	wg.Wait()
+// WithArgMax sets the limit on the size of the arguments and environment of
+// each command, so New reports instances exceeding it, such as after glob or
+// template expansion, instead of their start failing with E2BIG. The size is
+// estimated like the kernel accounts for it: each string with its terminating
+// NUL byte and a pointer to it. Zero selects the default of 2 MiB, ARG_MAX on
+// Linux with the default stack size limit, and a negative n disables the
+// check. Builtins and commands created by a CommandFactory are not checked.
+func WithArgMax(n int) Option {
+	return func(o *Options) {
+		o.argMax = n
+	}
+}
 
-	return errors.Join(errs...)
+// WithSubreaper sets whether the group process becomes a child subreaper while
+// running, so orphaned descendants of the instances (such as daemonized
+// grandchildren) are reparented to it and reaped instead of lingering as
+// zombies. Once [Group.Run] returns, the process stops being a subreaper,
+// unless it was one before. This is mainly useful when running as PID 1 of a
+// container. It is Linux only, and PreStart and PostStop hooks must not start
+// processes of their own while it is enabled.
+func WithSubreaper(enabled bool) Option {
+	return func(o *Options) {
+		o.subreaper = enabled
+	}
 }
 
-// checkErr filters out expected termination errors (context cancel, SIGTERM).
-func checkErr(err error) error {
-	if err == nil {
-		return nil
+// WithMaxRuntime sets a group-wide wall-clock limit. Once d has passed since
+// the group started running, all instances are stopped as on shutdown, and the
+// termination is not reported as an error. A non-positive d means no limit.
+func WithMaxRuntime(d time.Duration) Option {
+	return func(o *Options) {
+		o.maxRuntime = d
 	}
+}
 
-	if errors.Is(err, context.Canceled) {
-		return nil
+// WithSkipLookup sets whether New skips resolving command names with
+// [exec.LookPath] and uses them as given. This allows validating a
+// configuration on a machine where the commands do not exist. A group created
+// this way can only be inspected: [Group.Run] fails.
+func WithSkipLookup(enabled bool) Option {
+	return func(o *Options) {
+		o.skipLookup = enabled
 	}
+}
 
-	exitErr, ok := errors.AsType[*exec.ExitError](err)
-	if !ok {
-		return err
+// WithLazyLookup sets whether command names are resolved against PATH at every
+// start instead of once by New, so a restart picks up a binary that was moved
+// or a changed PATH. New still checks that each name resolves. The trade-off is
+// consistency and trust: restarts of an instance may run different binaries,
+// and anyone able to write to a directory in PATH or change PATH can substitute
+// the command. Names containing a slash are never looked up in PATH.
+func WithLazyLookup(enabled bool) Option {
+	return func(o *Options) {
+		o.lazyLookup = enabled
 	}
+}
 
-	waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
-	if !ok {
-		return err
+// WithEnv adds environment variables in the form "key=value" to the
+// environment of every instance. Each entry is rendered as a [text/template]
+// with the fields Index, the index of the instance, and Name, its command name
+// as given, so "DATA_DIR=/data/{{.Index}}" gives each instance a directory of
+// its own. The keys of the value set an instance was expanded with by
+// [WithTemplateValues] are fields as well, unless named Index or Name. Invalid
+// templates are an error in New.
+func WithEnv(env []string) Option {
+	return func(o *Options) {
+		o.env = slices.Concat(o.env, env)
 	}
+}
 
-	if !waitStatus.Signaled() || waitStatus.Signal() != syscall.SIGTERM {
-		return err
+// WithStartTemplates renders the arguments and environment variables of every
+// instance as a [text/template] at every start instead of once in New, with
+// the fields Index, the index of the instance, Name, its command name as
+// given, Attempt, the number of previous starts, which grows with every
+// restart, and the keys of the value set of [WithTemplateValues], if any. For
+// example, "--log=/var/log/run-{{.Attempt}}.log" writes each run to a file of
+// its own. The templates are parsed once by New, and the Args and Env of an
+// instance hold them rendered for its first start. Glob patterns are expanded
+// by New before rendering, so they must not depend on template fields.
+// Arguments from an ArgsProvider are rendered too, but those of commands
+// created by a [CommandFactory] are not. Invalid templates are an error in
+// New.
+func WithStartTemplates(enabled bool) Option {
+	return func(o *Options) {
+		o.startTemplates = enabled
 	}
+}
 
-	return nil
+// WithLabels assigns labels to the instances in order, so the instance at
+// index 0 gets the first label and so on. An empty label leaves its instance
+// unlabeled. Labels must be unique, and must not be integers, "all" or "none",
+// or contain commas or whitespace, so they can be used in watch
+// specifications. See [Instance.Label].
+func WithLabels(labels []string) Option {
+	return func(o *Options) {
+		o.labels = labels
+	}
 }
 
-// Run executes this command instance, potentially restarting it if configured
-// to watch.
-func (i *Instance) Run(ctx context.Context) error {
-	logger := i.Logger
-	if logger == nil {
-		logger = slog.New(slog.DiscardHandler)
+// WithIndexEnv sets the name of an environment variable that is set to the
+// index of each instance in its command's environment, so instance 0 gets
+// name=0 and so on. An empty name, the default, sets no variable.
+func WithIndexEnv(name string) Option {
+	return func(o *Options) {
+		o.indexEnv = name
 	}
+}
 
-	for {
-		cmd := i.newCmd(ctx)
-		cmdLogger := logger.With("cmd", cmd.String())
+// WithArgsProvider sets a function computing the arguments of the instance at
+// index before each start, such as to move to a different shard on restart.
+// It is called with the number of previous starts, and returning nil selects
+// the static arguments.
+func WithArgsProvider(index int, provider func(attempt int) []string) Option {
+	return withInstance(index, func(i *Instance) {
+		i.ArgsProvider = provider
+	})
+}
 
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("start command: %w", err)
-		}
+// WithStopSignal sets the signal sent to stop the commands of all instances,
+// instead of SIGTERM. It can be overridden per instance with
+// [WithInstanceStopSignal].
+func WithStopSignal(sig syscall.Signal) Option {
+	return func(o *Options) {
+		o.stopSignal = sig
+	}
+}
 
-		cmdLogger = cmdLogger.With("pid", cmd.Process.Pid)
-		cmdLogger.InfoContext(ctx, "started")
+// WithInstanceStopSignal sets the signal sent to stop the command of the
+// instance at index. It takes precedence over [WithStopSignal].
+func WithInstanceStopSignal(index int, sig syscall.Signal) Option {
+	return withInstance(index, func(i *Instance) {
+		i.StopSignal = sig
+	})
+}
 
-		err := cmd.Wait()
-		if err != nil {
-			cmdLogger.ErrorContext(ctx, "exited", "reason", err)
-		} else {
-			cmdLogger.InfoContext(ctx, "exited")
-		}
+// WithForceKill sets that the command of the instance at index is stopped
+// with SIGKILL right away instead of the stop signal and a grace period, so a
+// known unresponsive command does not hold up shutdown.
+func WithForceKill(index int) Option {
+	return withInstance(index, func(i *Instance) {
+		i.ForceKill = true
+	})
+}
 
-		if !i.Watch {
-			return err
-		}
+// WithKillGrace sets how long commands may take to exit after their stop
+// signal before they are killed. A non-positive d selects the default of 10s.
+func WithKillGrace(d time.Duration) Option {
+	return func(o *Options) {
+		o.killGrace = d
+	}
+}
 
-		select {
-		case <-ctx.Done():
-			cmdLogger.InfoContext(ctx, "not restarting", "reason", ctx.Err())
-			return ctx.Err()
-		case <-time.After(cmdRestartDelay):
-			cmdLogger.InfoContext(ctx, "restarting")
-		}
+// WithKillSignal sets the signal sent to commands that did not exit within
+// the kill grace period, instead of SIGKILL. For example, SIGABRT makes many
+// programs dump core, which helps debugging a hang. Unlike SIGKILL, such a
+// signal can be caught or ignored, so the command is still killed with SIGKILL
+// if it does not exit within 5s of the kill signal.
+func WithKillSignal(sig syscall.Signal) Option {
+	return func(o *Options) {
+		o.killSignal = sig
 	}
 }
 
-// newCmd creates a new [exec.Cmd] with process group handling for clean termination.
-func (i *Instance) newCmd(ctx context.Context) *exec.Cmd {
-	// #nosec G204 -- user/caller is responsible for name and args
-	cmd := exec.CommandContext(ctx, i.Name, i.Args...)
-	cmd.Env = os.Environ()
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Cancel = func() error {
-		// Signal entire process group on termination.
-		if pgid, err := syscall.Getpgid(cmd.Process.Pid); err == nil {
-			return syscall.Kill(-pgid, syscall.SIGTERM)
-		}
-		// Fallback to single process termination.
-		return cmd.Process.Signal(syscall.SIGTERM)
+// WithSetsid sets whether commands are started in a new session, so they are
+// detached from the controlling terminal of cmdgroup, if any, and do not
+// receive signals generated by it. Commands always lead a new process group;
+// with a new session, they also lead the session.
+func WithSetsid(enabled bool) Option {
+	return func(o *Options) {
+		o.setsid = enabled
 	}
-	cmd.WaitDelay = cmdWaitDelay
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true, // Create new process group.
+}
+
+// WithReloadStrategy sets how [Group.Reload] restarts the instances:
+// "parallel" restarts all of them at once, and "rolling" restarts them one at
+// a time, each once the previous one has started again or after at most a
+// minute. The default is "parallel".
+func WithReloadStrategy(strategy string) Option {
+	return func(o *Options) {
+		o.reloadStrategy = strategy
+	}
+}
+
+// WithCleanEnv sets whether commands start with an empty environment instead
+// of inheriting the environment of this process. Only variables set for the
+// instances, such as by [WithEnv] and [WithIndexEnv], are passed.
+func WithCleanEnv(enabled bool) Option {
+	return func(o *Options) {
+		o.cleanEnv = enabled
+	}
+}
+
+// WithOnReady sets a function that is called once while the group runs, as
+// soon as every instance has started its command. It is called from the
+// goroutine of the instance that started last, and must not block.
+func WithOnReady(onReady func()) Option {
+	return func(o *Options) {
+		o.onReady = onReady
+	}
+}
+
+// WithArgValidator sets a function that checks the arguments of each
+// instance, such as to restrict which files a command may be told to read. New
+// fails if it rejects the static arguments of an instance, and arguments
+// computed by [WithArgsProvider] are checked before every start.
+func WithArgValidator(validator func(index int, args []string) error) Option {
+	return func(o *Options) {
+		o.argValidator = validator
+	}
+}
+
+// WithGroupRestartLimit limits the automatic restarts of all watched
+// instances together to n per window, such as to back off when a shared
+// dependency is down and all instances crash. Once the limit is reached, a
+// warning is logged and restarts are delayed until the window allows them
+// again. A non-positive n means no limit.
+func WithGroupRestartLimit(n int, window time.Duration) Option {
+	return func(o *Options) {
+		o.restartLimit = n
+		o.restartWindow = window
+	}
+}
+
+// WithHeartbeat sets the interval at which a summary of the status of the
+// instances is logged while the group runs, confirming that the supervisor is
+// alive. A non-positive d, the default, disables heartbeats.
+func WithHeartbeat(d time.Duration) Option {
+	return func(o *Options) {
+		o.heartbeat = d
+	}
+}
+
+// WithControlSocket sets the path of a Unix socket on which the group accepts
+// control commands while it runs, one per line: "restart N", "stop N",
+// "pause N", and "resume N" call the [Group] method of the same name with the
+// instance of index or label N, "reload" calls [Group.Reload], and "status"
+// reports the [Group.Summary]. Each command is answered with a line: "ok",
+// the summary as JSON, or "error: " followed by the reason. Commands other
+// than status are rejected while another one is in progress. A socket left
+// over at path is replaced, and the socket is removed once the group stops.
+func WithControlSocket(path string) Option {
+	return func(o *Options) {
+		o.controlSocket = path
+	}
+}
+
+// WithWatchConfigFile sets the path of a file, such as the configuration the
+// commands read, whose changes reload the group while it runs, as with
+// [Group.Reload] and the strategy set by [WithReloadStrategy]. The file is
+// polled every second instead of being watched with inotify, which needs no
+// dependency and works on any file system. Since editors may write a file
+// several times when saving it, the group is reloaded once the file has stayed
+// unchanged for two seconds after a change. Removing the file counts as a
+// change, as does creating it.
+func WithWatchConfigFile(path string) Option {
+	return func(o *Options) {
+		o.watchConfigFile = path
+	}
+}
+
+// WithFinalizer sets a command run once by [Group.Run] after all instances
+// exited on their own, such as to clean up or report, but not when the group
+// is stopped, by the context passed to Run, the failure of an instance, an
+// instance with StopGroupOnExit, or MaxRuntime. The exit codes of the
+// instances are passed to it in the CMDGROUP_EXIT_CODES environment variable,
+// separated by commas in instance order. The finalizer inherits the standard
+// output and error of this process. If it fails, Run returns its error.
+func WithFinalizer(name string, args ...string) Option {
+	return func(o *Options) {
+		o.finalizer = append([]string{name}, args...)
+	}
+}
+
+// WithInitialDelay sets how long the group waits before starting any
+// instance, such as to let a device settle after boot. If the group is stopped
+// during the delay, nothing is started.
+func WithInitialDelay(d time.Duration) Option {
+	return func(o *Options) {
+		o.initialDelay = d
+	}
+}
+
+// WithCaptureOutput captures the stdout of the instance at index in memory
+// instead of passing it through, like [exec.Cmd.Output]. It is available from
+// [Group.Output] once the command exited. For a watched instance, only the
+// output of the most recent process is kept.
+func WithCaptureOutput(index int) Option {
+	return withInstance(index, func(i *Instance) {
+		i.CaptureOutput = true
+	})
+}
+
+// WithDiscardOutput discards the stdout and stderr of the instance at index,
+// such as for chatty commands whose output is of no interest. The output is
+// not logged either, even with [WithLogOutput], but is still captured with
+// [WithCaptureOutput].
+func WithDiscardOutput(index int) Option {
+	return withInstance(index, func(i *Instance) {
+		i.DiscardOutput = true
+	})
+}
+
+// WithQuiet sets whether the output of all instances is discarded, as with
+// [WithDiscardOutput] for each. Only the lifecycle of the instances is logged.
+// Since their output is connected to the null device, none of it is copied
+// through this process.
+func WithQuiet(enabled bool) Option {
+	return func(o *Options) {
+		o.quiet = enabled
+	}
+}
+
+// WithProcessGroup sets whether commands run in a process group of their own,
+// which is the default. Their whole group is signaled to stop them, so their
+// descendants are stopped too. Without it, commands stay in the process group
+// of cmdgroup, so signals sent to that group by an outer supervisor or a
+// terminal reach them, but only the commands themselves are signaled to stop
+// and their descendants may be left running.
+func WithProcessGroup(enabled bool) Option {
+	return func(o *Options) {
+		o.processGroup = enabled
+	}
+}
+
+// WithProcessGroupFallback sets whether a command that cannot be started
+// because creating its process group is not permitted, as in some constrained
+// namespaces, is retried once in the process group of cmdgroup, logging a
+// warning. See [WithProcessGroup] for the consequences. Other start failures
+// are not retried.
+func WithProcessGroupFallback(enabled bool) Option {
+	return func(o *Options) {
+		o.pgFallback = enabled
+	}
+}
+
+// WithArgv0 sets argv[0] of the instance at index to name, such as for
+// busybox-style multi-call binaries that select their behavior by the name
+// they are invoked as. The resolved path of the command is still what gets
+// executed; only the name the command sees changes.
+func WithArgv0(index int, name string) Option {
+	return withInstance(index, func(i *Instance) {
+		i.Argv0 = name
+	})
+}
+
+// WithShutdownOrder stops instances one at a time in the given order of
+// indexes when the group stops, waiting for each to exit within its kill grace
+// period before stopping the next, such as to stop a consumer before the
+// producer it reads from. Instances not in order are stopped together
+// afterwards. A positive timeout bounds the whole shutdown: instances still
+// running once it has passed are killed with SIGKILL, and fail the group.
+func WithShutdownOrder(order []int, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.shutdownOrder = order
+		o.shutdownTimeout = timeout
+	}
+}
+
+// WithStrictExit sets whether a command terminated by its stop signal, SIGTERM
+// by default, counts as failed, for deployments in which nothing but cmdgroup
+// is expected to stop the commands. The signal sent by cmdgroup itself to stop
+// a command, such as on shutdown, is still not a failure. By default, any
+// termination by the stop signal is considered clean.
+func WithStrictExit(enabled bool) Option {
+	return func(o *Options) {
+		o.strictExit = enabled
+	}
+}
+
+// WithRestartPolicyObject sets the policy deciding whether and when watched
+// instances are restarted, combining a maximum number of attempts with a
+// backoff of the delay between them. The zero value restarts indefinitely
+// after 1s, which is the default.
+func WithRestartPolicyObject(policy RestartPolicy) Option {
+	return func(o *Options) {
+		o.restartPolicy = policy
+	}
+}
+
+// WithWarmup sets how long a process must run to count as stably running, so
+// that the next restart counts as the first again: its delay starts over from
+// the initial one and it does not count toward the maximum attempts. A process
+// exiting within d keeps the backoff growing. It sets the ResetAfter of the
+// restart policy, regardless of whether it comes before or after
+// [WithRestartPolicyObject]. A non-positive d keeps the policy's ResetAfter.
+func WithWarmup(d time.Duration) Option {
+	return func(o *Options) {
+		o.warmup = d
+	}
+}
+
+// WithShouldRestart sets a function deciding whether and when watched instances
+// are restarted, for rules the restart policy cannot express, such as
+// restarting only on a particular exit code. It is called after every exit of
+// a watched instance that is not stopped, with how the process exited, and
+// returns whether to restart it and the delay before. It replaces the restart
+// policy set by [WithRestartPolicyObject], whose backoff and attempts it does
+// not apply, while restart windows and the restart limit of the group still
+// do. A failure of PreStart is passed as an exit with code -1. The function is
+// called from the goroutine running the instance, so it must be safe for
+// concurrent use if shared by several instances.
+func WithShouldRestart(shouldRestart func(i *Instance, result InstanceResult) (bool, time.Duration)) Option {
+	return func(o *Options) {
+		o.shouldRestart = shouldRestart
+	}
+}
+
+// WithExitCodeMap maps the exit codes of the instance at index to the codes
+// they are interpreted as, for commands with nonstandard exit codes, such as
+// {2: 0} for a linter exiting with 2 when it succeeds. A code mapped to 0 is a
+// clean exit, which does not fail the group, and a code mapped to another
+// nonzero code is reported as that code, such as by -exit-mode=child. Watched
+// instances are restarted regardless of their exit code.
+func WithExitCodeMap(index int, codes map[int]int) Option {
+	codes = maps.Clone(codes)
+
+	return withInstance(index, func(i *Instance) {
+		i.ExitCodeMap = codes
+	})
+}
+
+// withInstance returns an option that configures the instance at index once
+// all instances are created. New fails if the index is out of range.
+func withInstance(index int, configure func(*Instance)) Option {
+	return func(o *Options) {
+		o.instanceOptions = append(o.instanceOptions, instanceOption{index: index, configure: configure})
+	}
+}
+
+// New creates a command group for the specified command name and options.
+// Arguments before the first "--" separator are global args prepended to every
+// instance. Each "--"-delimited section after that defines a separate instance.
+// If no "--" separators are present, a single instance receives all arguments.
+// Template and glob expansion, if configured, happen after splitting, and
+// watch indexes refer to the expanded instances.
+// By default, no instances are watched and no logging is performed.
+func New(name string, options ...Option) (*Group, error) {
+	opts, err := newOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep checking the configuration after an expansion error, so all
+	// problems are reported at once.
+	var errs []error
+
+	argSets := instanceArgs(opts.args)
+	if sections := parseArgs(opts.args); len(sections) > 1 {
+		opts.globalArgs = len(sections[0])
+	}
+	opts.instanceSource = describeInstanceSource(len(argSets), opts)
+	commands := make([]command, 0, len(argSets))
+	for _, args := range argSets {
+		commands = append(commands, command{name: name, args: args})
+	}
+
+	if values := opts.templateValues; len(values) > 0 {
+		// Start templates are rendered at every start instead.
+		expanded := argSets
+		if !opts.startTemplates {
+			if expanded, err = expandTemplates(argSets, values); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		commands = commands[:0]
+		for argsIdx, args := range argSets {
+			for valuesIdx, data := range values {
+				cmdArgs := args
+				if !opts.startTemplates {
+					// Expanded by argument set, then by value set.
+					cmdArgs = expanded[argsIdx*len(values)+valuesIdx]
+				}
+				commands = append(commands, command{name: name, args: cmdArgs, values: data})
+			}
+		}
+	}
+
+	if expanded, err := expandCommandGlobs(commands, opts.globMode, opts.globNoMatchFail); err != nil {
+		errs = append(errs, err)
+	} else {
+		commands = expanded
+	}
+
+	group, err := newGroup(commands, opts)
+	if err := errors.Join(append(errs, err)...); err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// NewMulti creates a command group with one instance per command, where each
+// command is a full argument vector: the first element is the command name,
+// resolved as by [New], and the rest are its arguments. This allows instances
+// running different commands. Options apply as for [New], except for
+// [WithArgs], [WithAppendArgs], [WithTemplateValues] and [WithGlobExpand].
+func NewMulti(commands [][]string, options ...Option) (*Group, error) {
+	opts, err := newOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]command, 0, len(commands))
+	for idx, argv := range commands {
+		if len(argv) == 0 {
+			return nil, fmt.Errorf("command %d: no command specified", idx)
+		}
+
+		cmds = append(cmds, command{name: argv[0], args: argv[1:]})
+	}
+	opts.instanceSource = plural(len(commands), "command")
+
+	return newGroup(cmds, opts)
+}
+
+// newOptions applies options over the defaults.
+func newOptions(options []Option) (*Options, error) {
+	opts := &Options{
+		args:            nil,
+		watch:           "none",
+		logger:          slog.New(slog.DiscardHandler),
+		stopGroupOnExit: "none",
+		globMode:        "none",
+		reloadStrategy:  "parallel",
+		processGroup:    true,
+		globalArgs:      -1,
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.logger == nil {
+		return nil, errors.New("nil logger")
+	}
+	if opts.reloadStrategy != "parallel" && opts.reloadStrategy != "rolling" {
+		return nil, fmt.Errorf("invalid reload strategy: %q", opts.reloadStrategy)
+	}
+	if opts.outputOverflow != "" && opts.outputOverflow != "block" && opts.outputOverflow != "drop" {
+		return nil, fmt.Errorf("invalid output overflow: %q", opts.outputOverflow)
+	}
+
+	return opts, nil
+}
+
+// newGroup creates a command group with one instance per command. It checks
+// the whole configuration and returns all problems found, joined.
+func newGroup(commands []command, opts *Options) (*Group, error) {
+	var errs []error
+
+	envTmpls, err := parseEnvTemplates(opts.env)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := checkLabels(opts.labels, len(commands)); err != nil {
+		errs = append(errs, err)
+	}
+
+	restartPolicy := opts.restartPolicy
+	if opts.warmup > 0 {
+		restartPolicy.ResetAfter = opts.warmup
+	}
+
+	lookups := make(map[string]lookup)
+	instances := make([]*Instance, 0, len(commands))
+	for idx, cmd := range commands {
+		found, ok := lookups[cmd.name]
+		if !ok {
+			found.path, found.builtin, found.err = lookCommand(cmd.name, opts)
+			if found.err != nil {
+				errs = append(errs, found.err)
+			}
+			lookups[cmd.name] = found
+		}
+		path, builtin := found.path, found.builtin
+
+		var env []string
+		if opts.indexEnv != "" {
+			env = []string{opts.indexEnv + "=" + strconv.Itoa(idx)}
+		}
+		args, data := cmd.args, instanceData(idx, cmd.name, cmd.values)
+		var templates *startTemplates
+		if opts.startTemplates {
+			// Rendered for the first start, to be checked like others.
+			var err error
+			templates, err = newStartTemplates(cmd.args, envTmpls, env, data)
+			if err == nil {
+				args, env, err = templates.render(nil, 0)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("instance %d: %w", idx, err))
+			}
+		} else if rendered, err := renderEnv(envTmpls, data); err != nil {
+			errs = append(errs, fmt.Errorf("instance %d: %w", idx, err))
+		} else {
+			env = append(env, rendered...)
+		}
+
+		var label string
+		if idx < len(opts.labels) {
+			label = opts.labels[idx]
+		}
+
+		instances = append(instances, &Instance{
+			Name:                 path,
+			Args:                 args,
+			Watch:                false,
+			Logger:               opts.logger,
+			Label:                label,
+			Builtin:              builtin,
+			CommandFactory:       opts.commandFactory,
+			LogOutput:            opts.logOutput,
+			LogRunTimes:          opts.logRunTimes,
+			MaxLineBytes:         opts.maxLineBytes,
+			OutputFlush:          opts.outputFlush,
+			OutputOverflow:       opts.outputOverflow,
+			MaxOutputBytes:       opts.maxOutputBytes,
+			DiscardOutput:        opts.quiet,
+			Env:                  env,
+			StopSignal:           opts.stopSignal,
+			KillGrace:            opts.killGrace,
+			KillSignal:           opts.killSignal,
+			Setsid:               opts.setsid,
+			CleanEnv:             opts.cleanEnv,
+			SharedProcessGroup:   !opts.processGroup,
+			ProcessGroupFallback: opts.pgFallback,
+			StrictExit:           opts.strictExit,
+			RestartPolicy:        restartPolicy,
+			ShouldRestart:        opts.shouldRestart,
+			Cgroup:               opts.cgroup,
+			RestartSchedule:      opts.restartSchedule,
+			templates:            templates,
+		})
+	}
+
+	if opts.watch != "none" && opts.watch != "all" {
+		opts.logger.Info("parsed instances", "count", len(instances), "watch", opts.watch)
+
+		if opts.strictWatch {
+			if err := checkStrictWatch(instances, opts.globalArgs, opts.watch, labelIndexes(instances)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	watchErr := applyWatch(instances, opts.watch, opts.instanceSource)
+	if watchErr != nil {
+		errs = append(errs, watchErr)
+	}
+
+	if err := applyStopGroupOnExit(instances, opts.stopGroupOnExit, opts.instanceSource); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, option := range opts.instanceOptions {
+		if option.index < 0 || option.index >= len(instances) {
+			errs = append(errs, fmt.Errorf("instance option: index out of range: %d", option.index))
+			continue
+		}
+
+		option.configure(instances[option.index])
+	}
+
+	if err := checkDependencies(instances); err != nil {
+		errs = append(errs, err)
+	}
+
+	for idx, instance := range instances {
+		for _, cpu := range instance.CPUAffinity {
+			if cpu < 0 || cpu >= runtime.NumCPU() {
+				errs = append(errs, fmt.Errorf("instance %d: invalid CPU: %d, must be between 0 and %d", idx, cpu, runtime.NumCPU()-1))
+			}
+		}
+	}
+
+	if watchErr == nil {
+		if err := checkOneshotWatch(instances, opts.watch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if opts.watchFunc != nil {
+		for idx, instance := range instances {
+			instance.Watch = opts.watchFunc(instance)
+			if instance.Watch && instance.Oneshot {
+				errs = append(errs, fmt.Errorf("instance %d: watched and oneshot, but oneshot instances are never restarted", idx))
+			}
+		}
+	}
+
+	if opts.argMax >= 0 {
+		argMax := cmp.Or(opts.argMax, defaultArgMax)
+		for idx, instance := range instances {
+			if instance.Builtin != nil || instance.CommandFactory != nil {
+				continue
+			}
+
+			if size := instance.argSize(); size > argMax {
+				errs = append(errs, fmt.Errorf("instance %d: arguments and environment take %d bytes, exceeding the limit of %d bytes",
+					idx, size, argMax))
+			}
+		}
+	}
+
+	if err := checkShutdownOrder(instances, opts.shutdownOrder); err != nil {
+		errs = append(errs, err)
+	}
+
+	if opts.argValidator != nil {
+		for idx, instance := range instances {
+			if err := opts.argValidator(idx, instance.Args); err != nil {
+				errs = append(errs, fmt.Errorf("instance %d: invalid args: %w", idx, err))
+			}
+
+			instance.ValidateArgs = func(args []string) error {
+				return opts.argValidator(idx, args)
+			}
+		}
+	}
+
+	var finalizer []string
+	if opts.finalizer != nil {
+		finalizer = slices.Clone(opts.finalizer)
+		if !opts.skipLookup {
+			path, err := exec.LookPath(finalizer[0])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("finalizer: look path: %w", err))
+			}
+			finalizer[0] = path
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &Group{
+		Instances:          instances,
+		Logger:             opts.logger,
+		Subreaper:          opts.subreaper,
+		MaxRuntime:         opts.maxRuntime,
+		ReloadStrategy:     opts.reloadStrategy,
+		OnReady:            opts.onReady,
+		RestartLimit:       opts.restartLimit,
+		RestartWindow:      opts.restartWindow,
+		Heartbeat:          opts.heartbeat,
+		InitialDelay:       opts.initialDelay,
+		ShutdownOrder:      opts.shutdownOrder,
+		ShutdownTimeout:    opts.shutdownTimeout,
+		ControlSocket:      opts.controlSocket,
+		WatchConfigFile:    opts.watchConfigFile,
+		Finalizer:          finalizer,
+		StopOnFirstSuccess: opts.firstSuccess,
+		unresolved:         opts.skipLookup,
+	}, nil
+}
+
+// lookCommand resolves the path of the named command, falling back to a
+// builtin if enabled. With a command factory or if lookup is skipped, the name
+// is used as is. With lazy lookup, the name is kept once it resolves, so
+// [exec.CommandContext] resolves it again at every start.
+func lookCommand(name string, opts *Options) (string, BuiltinFunc, error) {
+	if opts.commandFactory != nil || opts.skipLookup {
+		return name, nil, nil
+	}
+
+	path, err := exec.LookPath(name)
+	if err == nil {
+		if opts.lazyLookup {
+			return name, nil, nil
+		}
+
+		return path, nil, nil
+	}
+
+	builtin, ok := lookBuiltin(name)
+	if !ok || !opts.builtinFallback {
+		return "", nil, fmt.Errorf("look path: %w", err)
+	}
+
+	opts.logger.Warn("using builtin fallback", "name", name, "reason", err)
+
+	return name, builtin, nil
+}
+
+// checkOneshotWatch rejects one-shot instances selected by index or label in
+// the watch specification, since they are never restarted. Selecting all
+// instances is accepted and watches only those that are not one-shot.
+func checkOneshotWatch(instances []*Instance, watch string) error {
+	if watch == "all" {
+		return nil
+	}
+
+	selected, err := selectInstances(instances, watch, "")
+	if err != nil {
+		return err
+	}
+
+	for idx, instance := range instances {
+		if instance.Oneshot && slices.Contains(selected, instance) {
+			return fmt.Errorf("instance %d: watched and oneshot, but oneshot instances are never restarted", idx)
+		}
+	}
+
+	return nil
+}
+
+// checkDependencies rejects dependency indexes out of range and cycles.
+func checkDependencies(instances []*Instance) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(instances))
+
+	var visit func(idx int) error
+	visit = func(idx int) error {
+		switch state[idx] {
+		case visiting:
+			return fmt.Errorf("dependency cycle at instance %d", idx)
+		case visited:
+			return nil
+		}
+
+		state[idx] = visiting
+		for _, dep := range instances[idx].DependsOn {
+			if dep < 0 || dep >= len(instances) {
+				return fmt.Errorf("instance %d: dependency index out of range: %d", idx, dep)
+			}
+
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[idx] = visited
+
+		return nil
+	}
+
+	for idx := range instances {
+		if err := visit(idx); err != nil {
+			return fmt.Errorf("check dependencies: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkShutdownOrder checks that the shutdown order refers to existing
+// instances, each at most once.
+func checkShutdownOrder(instances []*Instance, order []int) error {
+	seen := make(map[int]bool, len(order))
+	for _, idx := range order {
+		if idx < 0 || idx >= len(instances) {
+			return fmt.Errorf("shutdown order: index out of range: %d", idx)
+		}
+		if seen[idx] {
+			return fmt.Errorf("shutdown order: duplicate index: %d", idx)
+		}
+		seen[idx] = true
+	}
+
+	return nil
+}
+
+// applyWatch configures which instances should be monitored and restarted.
+func applyWatch(instances []*Instance, watch, source string) error {
+	selected, err := selectInstances(instances, watch, source)
+	if err != nil {
+		return fmt.Errorf("parse watch: %w", err)
+	}
+
+	for _, instance := range selected {
+		instance.Watch = true
+	}
+
+	return nil
+}
+
+// applyStopGroupOnExit configures which instances stop the group on exit.
+func applyStopGroupOnExit(instances []*Instance, stopGroupOnExit, source string) error {
+	selected, err := selectInstances(instances, stopGroupOnExit, source)
+	if err != nil {
+		return fmt.Errorf("parse stop group on exit: %w", err)
+	}
+
+	for _, instance := range selected {
+		instance.StopGroupOnExit = true
+	}
+
+	return nil
+}
+
+// selectInstances returns the instances selected by spec, which is "none",
+// "all", or a comma-separated list of indexes and labels. Errors for indexes
+// out of range explain that the instances were generated from source, unless
+// it is empty.
+func selectInstances(instances []*Instance, spec, source string) ([]*Instance, error) {
+	switch spec {
+	case "none":
+		return nil, nil
+	case "all":
+		return instances, nil
+	default:
+		indexes, err := parseIndexes(spec, labelIndexes(instances))
+		if err != nil {
+			return nil, err
+		}
+
+		selected := make([]*Instance, 0, len(indexes))
+		for _, index := range indexes {
+			if index < 0 || index >= len(instances) {
+				return nil, &indexRangeError{index: index, count: len(instances), source: source}
+			}
+
+			selected = append(selected, instances[index])
+		}
+
+		return selected, nil
+	}
+}
+
+// labelIndexes maps the labels of the labeled instances to their indexes.
+func labelIndexes(instances []*Instance) map[string]int {
+	labels := make(map[string]int)
+	for idx, instance := range instances {
+		if instance.Label != "" {
+			labels[instance.Label] = idx
+		}
+	}
+
+	return labels
+}
+
+// checkLabels rejects more labels than instances, duplicate labels, and
+// labels that could be mistaken for an index or selection keyword, or that
+// cannot be listed in a specification.
+func checkLabels(labels []string, count int) error {
+	if len(labels) > count {
+		return fmt.Errorf("labels: %s for %s", plural(len(labels), "label"), plural(count, "instance"))
+	}
+
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		if label == "" {
+			continue
+		}
+
+		if _, err := strconv.Atoi(label); err == nil || label == "all" || label == "none" ||
+			strings.ContainsAny(label, ", \t") {
+			return fmt.Errorf("labels: invalid label: %q", label)
+		}
+
+		if seen[label] {
+			return fmt.Errorf("labels: duplicate label: %q", label)
+		}
+
+		seen[label] = true
+	}
+
+	return nil
+}
+
+// describeInstanceSource describes how New generates instances from the given
+// number of argument sections, for error messages.
+func describeInstanceSource(sections int, opts *Options) string {
+	source := plural(sections, "argument section")
+	if len(opts.templateValues) > 0 {
+		source += " and " + plural(len(opts.templateValues), "template value set")
+	}
+	if opts.globMode != "none" {
+		source += fmt.Sprintf(" with %s glob expansion", opts.globMode)
+	}
+
+	return source
+}
+
+// plural returns n followed by noun, with an "s" appended unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// checkStrictWatch rejects instances without arguments besides the globalArgs
+// global ones, unless globalArgs is negative, instances identical to an
+// earlier one, and duplicate watch indexes.
+func checkStrictWatch(instances []*Instance, globalArgs int, watch string, labels map[string]int) error {
+	seenInstances := make(map[string]int, len(instances))
+	for idx, instance := range instances {
+		if globalArgs >= 0 && len(instance.Args) <= globalArgs {
+			return fmt.Errorf("strict watch: instance %d has no arguments", idx)
+		}
+
+		key := strings.Join(slices.Concat([]string{instance.Name}, instance.Args, []string{"--"}, instance.Env), "\x00")
+		if other, ok := seenInstances[key]; ok {
+			return fmt.Errorf("strict watch: instance %d is identical to instance %d", idx, other)
+		}
+		seenInstances[key] = idx
+	}
+
+	indexes, err := parseIndexes(watch, labels)
+	if err != nil {
+		return fmt.Errorf("parse watch: %w", err)
+	}
+
+	seen := make(map[int]bool, len(indexes))
+	for _, index := range indexes {
+		if seen[index] {
+			return fmt.Errorf("strict watch: duplicate index: %d", index)
+		}
+
+		seen[index] = true
+	}
+
+	return nil
+}
+
+// Run executes all command instances in parallel and waits for them to complete.
+// If an unwatched instance exits with an error, the group context is cancelled
+// and all remaining instances are terminated. Watched instances that exit with
+// an error (including a start failure) do not cancel the group. Instances with
+// StopGroupOnExit cancel the group whenever they exit. One-shot instances
+// only cancel the group on failure if they are fatal. Run fails without
+// starting any instance if the dependencies between instances are invalid. If
+// MaxRuntime is set, all instances are stopped once it has passed, which is not
+// an error. Output of the instances that is logged but still buffered, such as
+// with OutputFlush, is logged before Run returns. With a ControlSocket, Run
+// fails without starting any instance if it cannot listen on it. Once Run
+// returns, [Group.TerminationReason] tells which of these cases applied.
+func (g *Group) Run(ctx context.Context) error {
+	defer g.closeEvents()
+
+	g.setTerminationReason("")
+
+	if g.unresolved {
+		g.setTerminationReason(TerminationSetupFailed)
+		return errors.New("commands not resolved: group created with skip lookup")
+	}
+
+	if err := checkDependencies(g.Instances); err != nil {
+		g.setTerminationReason(TerminationSetupFailed)
+		return err
+	}
+
+	if err := checkShutdownOrder(g.Instances, g.ShutdownOrder); err != nil {
+		g.setTerminationReason(TerminationSetupFailed)
+		return err
+	}
+
+	for idx, instance := range g.Instances {
+		var dependents []*Instance
+		for _, other := range g.Instances {
+			if slices.Contains(other.DependsOn, idx) {
+				dependents = append(dependents, other)
+			}
+		}
+
+		instance.setOnRestart(func() {
+			for _, dependent := range dependents {
+				dependent.Restart()
+			}
+		})
+		instance.setOnEvent(func(event Event) {
+			event.Index = idx
+			g.emit(event)
+		})
+		instance.inheritClock(g.clk)
+	}
+
+	g.notifyReady()
+
+	parent := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if g.MaxRuntime > 0 {
+		timer := g.clock().AfterFunc(g.MaxRuntime, func() {
+			g.logger().InfoContext(ctx, "stopping", "reason", errMaxRuntime)
+			cancel(errMaxRuntime)
+		})
+		defer timer.Stop()
+	}
+
+	if g.InitialDelay > 0 {
+		g.logger().InfoContext(ctx, "delaying start", "delay", g.InitialDelay)
+
+		select {
+		case <-ctx.Done():
+			g.setTerminationReason(terminationReason(context.Cause(ctx), parent, nil))
+			return nil
+		case <-g.clock().After(g.InitialDelay):
+		}
+	}
+
+	if g.RestartLimit > 0 {
+		limiter := newRestartLimiter(g.logger(), g.clock(), g.RestartLimit, g.RestartWindow)
+		for _, instance := range g.Instances {
+			instance.setLimiter(limiter)
+		}
+	}
+
+	if g.Heartbeat > 0 {
+		heartbeatDone := make(chan struct{})
+		go func() {
+			defer close(heartbeatDone)
+			g.heartbeat(ctx)
+		}()
+		defer func() {
+			cancel(nil)
+			<-heartbeatDone
+		}()
+	}
+
+	var r *reaper
+	if g.Subreaper {
+		var err error
+		r, err = newReaper(g.logger())
+		if err != nil {
+			g.setTerminationReason(TerminationSetupFailed)
+			return err
+		}
+
+		for _, instance := range g.Instances {
+			instance.setReaper(r)
+		}
+
+		reaperCtx, stopReaper := context.WithCancel(context.WithoutCancel(ctx))
+		reaperDone := make(chan struct{})
+		go func() {
+			defer close(reaperDone)
+			r.run(reaperCtx)
+		}()
+		defer func() {
+			stopReaper()
+			<-reaperDone
+			r.close(ctx)
+		}()
+	}
+
+	if g.ControlSocket != "" {
+		server, err := listenControl(ctx, g, g.ControlSocket)
+		if err != nil {
+			g.setTerminationReason(TerminationSetupFailed)
+			return err
+		}
+
+		controlDone := make(chan struct{})
+		go func() {
+			defer close(controlDone)
+			server.serve(ctx)
+		}()
+		defer func() {
+			cancel(nil)
+			<-controlDone
+		}()
+	}
+
+	if g.WatchConfigFile != "" {
+		watchDone := make(chan struct{})
+		go func() {
+			defer close(watchDone)
+			g.watchConfigFile(ctx)
+		}()
+		defer func() {
+			cancel(nil)
+			<-watchDone
+		}()
+	}
+
+	// Each instance runs with a context of its own, so it can be stopped
+	// alone. With a shutdown order, instances run until stopped one by one
+	// by shutdown instead of all at once by the group context.
+	runCtxs := make([]context.Context, len(g.Instances))
+	stops := make([]context.CancelFunc, len(g.Instances))
+	dones := make([]chan struct{}, len(g.Instances))
+	for idx := range g.Instances {
+		instanceParent := ctx
+		if g.ShutdownOrder != nil {
+			instanceParent = context.WithoutCancel(ctx)
+		}
+		runCtxs[idx], stops[idx] = context.WithCancel(withIndex(instanceParent, idx))
+		defer stops[idx]()
+		dones[idx] = make(chan struct{})
+	}
+	g.setStops(stops)
+	defer g.setStops(nil)
+
+	var wg sync.WaitGroup
+
+	// With StopOnFirstSuccess, instances other than one-shot ones race each
+	// other, and the group only fails once all of them have failed.
+	var racing atomic.Int64
+	for _, instance := range g.Instances {
+		if g.StopOnFirstSuccess && !instance.Oneshot {
+			racing.Add(1)
+		}
+	}
+
+	errs := make([]error, len(g.Instances))
+	for idx, instance := range g.Instances {
+		wg.Go(func() {
+			defer close(dones[idx])
+
+			racer := g.StopOnFirstSuccess && !instance.Oneshot
+			errs[idx] = instance.checkErr(instance.Run(runCtxs[idx]))
+			if racer && errs[idx] == nil && runCtxs[idx].Err() == nil {
+				cancel(errFirstSuccess)
+			}
+			if errs[idx] != nil && instance.Oneshot && !instance.OneshotFatal {
+				instance.logger().WarnContext(ctx, "ignoring oneshot failure", "error", errs[idx])
+				errs[idx] = nil
+			}
+			if errs[idx] != nil && (!instance.watched() || instance.Oneshot) && (!racer || racing.Add(-1) == 0) {
+				cancel(errs[idx])
+			}
+			if instance.StopGroupOnExit && runCtxs[idx].Err() == nil {
+				cancel(errStopGroupOnExit)
+			}
+		})
+	}
+
+	allDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allDone)
+	}()
+
+	select {
+	case <-allDone:
+	case <-ctx.Done():
+		g.emit(Event{Type: EventShutdown, Index: -1, Err: context.Cause(ctx)})
+		if g.ShutdownOrder != nil {
+			g.shutdown(ctx, stops, dones)
+		}
+	}
+
+	wg.Wait()
+
+	if errors.Is(context.Cause(ctx), errFirstSuccess) {
+		// The other racers failing is expected once one has won.
+		for idx, instance := range g.Instances {
+			if !instance.Oneshot {
+				errs[idx] = nil
+			}
+		}
+	}
+
+	err := errors.Join(errs...)
+	if g.Finalizer != nil && context.Cause(ctx) == nil {
+		err = errors.Join(err, g.runFinalizer(parent, r))
+	}
+	g.setTerminationReason(terminationReason(context.Cause(ctx), parent, err))
+
+	return err
+}
+
+// shutdown stops the instances in ShutdownOrder one at a time, waiting for
+// each to exit, then the remaining ones together. Once ShutdownTimeout has
+// passed, all instances are stopped and those still running are killed.
+func (g *Group) shutdown(ctx context.Context, stops []context.CancelFunc, dones []chan struct{}) {
+	var deadline <-chan time.Time
+	if g.ShutdownTimeout > 0 {
+		timer := g.clock().NewTimer(g.ShutdownTimeout)
+		defer timer.Stop()
+		deadline = timer.C()
+	}
+
+	batches := make([][]int, 0, len(g.ShutdownOrder)+1)
+	for _, idx := range g.ShutdownOrder {
+		batches = append(batches, []int{idx})
+	}
+	var rest []int
+	for idx := range g.Instances {
+		if !slices.Contains(g.ShutdownOrder, idx) {
+			rest = append(rest, idx)
+		}
+	}
+	batches = append(batches, rest)
+
+	for _, batch := range batches {
+		for _, idx := range batch {
+			stops[idx]()
+		}
+
+		for _, idx := range batch {
+			select {
+			case <-dones[idx]:
+			case <-deadline:
+				g.logger().WarnContext(ctx, "shutdown timeout reached, killing remaining instances",
+					"timeout", g.ShutdownTimeout)
+				for other, instance := range g.Instances {
+					stops[other]()
+					if err := instance.kill(syscall.SIGKILL); err != nil {
+						instance.logger().ErrorContext(ctx, "killing failed", "error", err)
+					}
+				}
+
+				return
+			}
+		}
+	}
+}
+
+// logger returns the group logger, or a discarding logger if unset.
+func (g *Group) logger() *slog.Logger {
+	if g.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+
+	return g.Logger
+}
+
+// Pause pauses the instance at the given index. See [Instance.Pause].
+func (g *Group) Pause(index int) error {
+	if index < 0 || index >= len(g.Instances) {
+		return fmt.Errorf("pause: index out of range: %d", index)
+	}
+
+	g.Instances[index].Pause()
+
+	return nil
+}
+
+// SetWatch changes which instances are watched while the group runs. The spec
+// is as for [WithWatch] and is validated against the instances of the group
+// before any of them is changed. A newly watched instance is restarted on its
+// next exit, while one that already exited unwatched stays stopped; a newly
+// unwatched instance is not restarted anymore. Selecting a one-shot instance by
+// index or label is an error, as in [New]. It is safe to call concurrently
+// with [Group.Run].
+func (g *Group) SetWatch(spec string) error {
+	selected, err := selectInstances(g.Instances, cmp.Or(spec, "none"), "")
+	if err != nil {
+		return fmt.Errorf("set watch: %w", err)
+	}
+
+	if err := checkOneshotWatch(g.Instances, cmp.Or(spec, "none")); err != nil {
+		return fmt.Errorf("set watch: %w", err)
+	}
+
+	for _, instance := range g.Instances {
+		instance.setWatch(slices.Contains(selected, instance))
+	}
+
+	return nil
+}
+
+// Resume resumes the instance at the given index. See [Instance.Resume].
+func (g *Group) Resume(index int) error {
+	if index < 0 || index >= len(g.Instances) {
+		return fmt.Errorf("resume: index out of range: %d", index)
+	}
+
+	g.Instances[index].Resume()
+
+	return nil
+}
+
+// Stop stops the instance at the given index for the rest of the current
+// [Group.Run] without affecting the others: its process is stopped as on
+// shutdown and it is not restarted, even if watched, nor does it stop the
+// group with StopGroupOnExit. It fails if the group is not running.
+func (g *Group) Stop(index int) error {
+	if index < 0 || index >= len(g.Instances) {
+		return fmt.Errorf("stop: index out of range: %d", index)
+	}
+
+	g.stopsMu.Lock()
+	defer g.stopsMu.Unlock()
+
+	if g.stops == nil {
+		return errors.New("stop: group not running")
+	}
+
+	g.stops[index]()
+
+	return nil
+}
+
+// setStops records the functions stopping each instance while the group
+// runs, or nil once it returns.
+func (g *Group) setStops(stops []context.CancelFunc) {
+	g.stopsMu.Lock()
+	defer g.stopsMu.Unlock()
+
+	g.stops = stops
+}
+
+// Restart restarts the instance at the given index. See [Instance.Restart].
+func (g *Group) Restart(index int) error {
+	if index < 0 || index >= len(g.Instances) {
+		return fmt.Errorf("restart: index out of range: %d", index)
+	}
+
+	g.Instances[index].Restart()
+
+	return nil
+}
+
+// heartbeat logs the status of the instances every heartbeat interval until
+// ctx is done.
+func (g *Group) heartbeat(ctx context.Context) {
+	ticker := g.clock().NewTicker(g.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			statuses := make([]string, 0, len(g.Instances))
+			for _, instance := range g.Summary().Instances {
+				statuses = append(statuses, instance.Status)
+			}
+
+			g.logger().InfoContext(ctx, "heartbeat",
+				"running", g.Running(), "instances", len(g.Instances), "statuses", statuses)
+		}
+	}
+}
+
+// Output returns the captured stdout of the most recent process of the
+// instance at index, or nil if there is none or index is out of range. It is
+// safe to call concurrently with [Group.Run].
+func (g *Group) Output(index int) []byte {
+	if index < 0 || index >= len(g.Instances) {
+		return nil
+	}
+
+	return g.Instances[index].Output()
+}
+
+// WatchedIndices returns the indexes of the instances that are restarted on
+// exit, in ascending order: those with Watch set that are not Oneshot.
+func (g *Group) WatchedIndices() []int {
+	var indexes []int
+	for idx, instance := range g.Instances {
+		if instance.watched() && !instance.Oneshot {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	return indexes
+}
+
+// notifyReady arranges for OnReady to be called and the ready event to be
+// emitted once all instances have started.
+func (g *Group) notifyReady() {
+	ready := func() {
+		g.emit(Event{Type: EventReady, Index: -1})
+		if g.OnReady != nil {
+			g.OnReady()
+		}
+	}
+
+	if len(g.Instances) == 0 {
+		ready()
+		return
+	}
+
+	var pending atomic.Int64
+	pending.Store(int64(len(g.Instances)))
+	for _, instance := range g.Instances {
+		var once sync.Once
+		instance.setOnStart(func() {
+			once.Do(func() {
+				if pending.Add(-1) == 0 {
+					ready()
+				}
+			})
+		})
+	}
+}
+
+// Reload restarts all running instances according to the reload strategy of
+// the group. A rolling reload restarts the next instance once the previous
+// one has started again, has stopped being run or paused, or has not started
+// within a minute, and returns once the last instance has. It returns the
+// context error if ctx is done first, and the instances that did not start in
+// time. It is safe to call concurrently with [Group.Run].
+func (g *Group) Reload(ctx context.Context) error {
+	switch g.ReloadStrategy {
+	case "", "parallel":
+		for _, instance := range g.Instances {
+			instance.Restart()
+		}
+	case "rolling":
+		var errs []error
+		for idx, instance := range g.Instances {
+			if !instance.Running() {
+				continue
+			}
+
+			starts := instance.Stats().Starts
+			instance.Restart()
+			if err := waitRestarted(ctx, g.clock(), instance, starts); err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("reload instance %d: %w", idx, err)
+				}
+				errs = append(errs, fmt.Errorf("reload instance %d: %w", idx, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	default:
+		return fmt.Errorf("invalid reload strategy: %q", g.ReloadStrategy)
+	}
+
+	return nil
+}
+
+// WaitReady waits until the instance at the given index has been ready, which
+// is once a process of it started, or with PIDFile, once the daemon it
+// launched was found running, as for [Group.OnReady]. It returns at once if
+// the instance was ready before, even if its process has exited since, and
+// fails if the instance stopped being run without becoming ready. It returns
+// the context error if ctx is done first. It is safe to call concurrently with
+// [Group.Run].
+func (g *Group) WaitReady(ctx context.Context, index int) error {
+	if index < 0 || index >= len(g.Instances) {
+		return fmt.Errorf("wait ready: index out of range: %d", index)
+	}
+
+	instance := g.Instances[index]
+	ticker := g.clock().NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ready, ended := instance.readiness()
+		switch {
+		case ready:
+			return nil
+		case ended:
+			return fmt.Errorf("wait ready: instance %d: %w", index, errNotReady)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
+
+// waitRestarted waits until instance has started another process after the
+// given number of starts, for at most reloadStartTimeout on clk. It returns
+// early if the instance is no longer run or is paused, as it does not start
+// again then.
+func waitRestarted(ctx context.Context, clk clock, instance *Instance, starts int) error {
+	timeout := clk.NewTimer(reloadStartTimeout)
+	defer timeout.Stop()
+
+	ticker := clk.NewTicker(reloadPollInterval)
+	defer ticker.Stop()
+
+	for instance.Stats().Starts <= starts || !instance.Running() {
+		if !instance.isActive() || instance.Paused() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C():
+			return errReloadTimeout
+		case <-ticker.C():
+		}
+	}
+
+	return nil
+}
+
+// Running returns the number of instances with a running process. It is safe
+// to call concurrently with [Group.Run].
+func (g *Group) Running() int {
+	var n int
+	for _, instance := range g.Instances {
+		if instance.Running() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// PIDs returns the process ID of the running process of each instance by
+// index, or 0 for instances without one, such as to inspect them in /proc. It
+// is safe to call concurrently with [Group.Run].
+func (g *Group) PIDs() map[int]int {
+	pids := make(map[int]int, len(g.Instances))
+	for idx, instance := range g.Instances {
+		pids[idx] = instance.PID()
+	}
+
+	return pids
+}
+
+// checkErr filters out expected termination errors (context cancel, the stop
+// signal of the instance). With StrictExit, the stop signal is only expected
+// if it was sent to stop the most recent process.
+func (i *Instance) checkErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	if sig, ok := exitSignal(err); !ok || sig != i.stopSignal() {
+		return err
+	}
+
+	if i.StrictExit && !i.wasStopped() {
+		return err
+	}
+
+	return nil
+}
+
+// stopSignal returns the signal that stops the command of this instance.
+func (i *Instance) stopSignal() syscall.Signal {
+	if i.ForceKill {
+		return syscall.SIGKILL
+	}
+
+	if i.StopSignal == 0 {
+		return syscall.SIGTERM
+	}
+
+	return i.StopSignal
+}
+
+// exitSignal returns the signal that terminated a process, if its exit error
+// reports one.
+func exitSignal(err error) (syscall.Signal, bool) {
+	exitErr, ok := errors.AsType[*exec.ExitError](err)
+	if !ok {
+		return 0, false
+	}
+
+	waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return 0, false
+	}
+
+	return waitStatus.Signal(), true
+}
+
+// exitCode returns the exit code for a process exit error: 0 for nil, the
+// mapped exit code for a [mappedExitError], the process exit code for an
+// [exec.ExitError], and -1 otherwise.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if mapped, ok := errors.AsType[*mappedExitError](err); ok {
+		return mapped.code
+	}
+
+	if exitErr, ok := errors.AsType[*exec.ExitError](err); ok {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+// Error implements [error].
+func (e *indexRangeError) Error() string {
+	if e.source == "" {
+		return fmt.Sprintf("index out of range: %d (%s)", e.index, plural(e.count, "instance"))
+	}
+
+	return fmt.Sprintf("index out of range: %d (%s generated from %s)",
+		e.index, plural(e.count, "instance"), e.source)
+}
+
+// Error implements [error].
+func (e *mappedExitError) Error() string {
+	return fmt.Sprintf("%v (mapped to exit status %d)", e.err, e.code)
+}
+
+// Unwrap returns the exit error of the process.
+func (e *mappedExitError) Unwrap() error {
+	return e.err
+}
+
+// Run executes this command instance, potentially restarting it if configured
+// to watch.
+func (i *Instance) Run(ctx context.Context) error {
+	logger := i.logger()
+	i.setActive(true)
+	defer i.setActive(false)
+
+	var quickExits, restarts int
+	for {
+		if err := i.waitResumed(ctx, logger); err != nil {
+			return err
+		}
+
+		if i.PreStart != nil {
+			if err := i.PreStart(ctx); err != nil {
+				err = fmt.Errorf("pre-start: %w", err)
+				logger.ErrorContext(ctx, "not started", "reason", err)
+				if !i.watched() || i.Oneshot {
+					return err
+				}
+
+				restarts++
+				delay, reason := i.nextRestart(ctx, err, 0, restarts)
+				if reason != nil {
+					logger.ErrorContext(ctx, "not restarting", "reason", reason, "attempts", restarts-1)
+					return err
+				}
+
+				if err := i.waitSchedule(ctx, logger); err != nil {
+					return err
+				}
+
+				if err := i.waitRestart(ctx, logger, delay); err != nil {
+					return err
+				}
+				i.emit(Event{Type: EventRestarting})
+
+				if err := i.waitLimit(ctx); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		args, env := i.Args, i.Env
+		if i.templates != nil {
+			var err error
+			if args, env, err = i.templates.render(i.providedArgs(), i.Stats().Starts); err != nil {
+				logger.ErrorContext(ctx, "not started", "reason", err)
+				return err
+			}
+		} else {
+			args = i.args()
+		}
+		if i.ValidateArgs != nil {
+			if err := i.ValidateArgs(args); err != nil {
+				err = fmt.Errorf("invalid args: %w", err)
+				logger.ErrorContext(ctx, "not started", "reason", err)
+
+				return err
+			}
+		}
+
+		runCtx, cancelRun := context.WithCancelCause(ctx)
+		cmd := i.command(runCtx, args, env)
+		cmdLogger := logger.With("cmd", cmd.String())
+
+		i.setStopSent(false)
+		startErr := i.start(cmd)
+		if startErr != nil && i.ProcessGroupFallback && processGroupDenied(cmd, startErr) {
+			cancelRun(nil)
+			logger.WarnContext(ctx, "starting without process group", "reason", startErr)
+
+			i.setNoProcessGroup(true)
+			runCtx, cancelRun = context.WithCancelCause(ctx)
+			cmd = i.command(runCtx, args, env)
+			cmdLogger = logger.With("cmd", cmd.String())
+			if retryErr := i.start(cmd); retryErr != nil {
+				// Not the process group after all: report the
+				// original failure.
+				i.setNoProcessGroup(false)
+			} else {
+				startErr = nil
+			}
+		}
+		if startErr != nil {
+			cancelRun(nil)
+			return fmt.Errorf("start command: %w", startErr)
+		}
+
+		if i.Cgroup != "" && cmd.Pid() > 0 {
+			if err := joinCgroup(i.Cgroup, cmd.Pid()); err != nil {
+				cancelRun(nil)
+				_ = i.wait(cmd) // Stopped for not joining the cgroup.
+
+				return fmt.Errorf("join cgroup: %w", err)
+			}
+		}
+
+		if len(i.CPUAffinity) > 0 && cmd.Pid() > 0 {
+			if err := setCPUAffinity(cmd.Pid(), i.CPUAffinity); err != nil {
+				cancelRun(nil)
+				_ = i.wait(cmd) // Stopped for not being restricted.
+
+				return fmt.Errorf("set cpu affinity: %w", err)
+			}
+		}
+
+		if pid := cmd.Pid(); pid > 0 {
+			cmdLogger = cmdLogger.With("pid", pid)
+		}
+		cmdLogger.InfoContext(ctx, "started")
+		started := i.clock().Now()
+
+		onStart, onRestart := i.recordStart()
+		if _, ok := cmd.(daemonCmd); !ok {
+			i.recordReady()
+		}
+		i.setPid(cmd.Pid())
+		i.setCancelRun(cancelRun)
+		if i.Paused() {
+			// Paused while starting, before the process could be stopped.
+			cancelRun(errPauseRequested)
+		}
+		i.emit(Event{Type: EventStarted, PID: cmd.Pid()})
+		if onStart != nil {
+			onStart()
+		}
+		if onRestart != nil {
+			onRestart()
+		}
+		err := i.mapExit(i.wait(cmd))
+
+		exitLogger := cmdLogger
+		if usage, ok := processUsage(cmd.ProcessState()); ok {
+			i.setLastUsage(usage)
+			exitLogger = exitLogger.With("usage", usage)
+		}
+		if sig, ok := exitSignal(err); ok {
+			exitLogger = exitLogger.With("signal", signalName(sig))
+		}
+
+		i.setPid(0)
+		i.setCancelRun(nil)
+		exit := i.recordExit(err)
+		if i.LogRunTimes {
+			if !exit.ReadyAt.IsZero() {
+				exitLogger = exitLogger.With("startup", exit.ReadyAt.Sub(exit.StartedAt))
+			}
+			exitLogger = exitLogger.With("run_time", exit.Time.Sub(exit.StartedAt))
+		}
+		i.emit(Event{Type: EventExited, Err: err})
+		restart := errors.Is(context.Cause(runCtx), errRestartRequested)
+		pause := errors.Is(context.Cause(runCtx), errPauseRequested)
+		cancelRun(nil)
+
+		if i.PostStop != nil {
+			if err := i.PostStop(context.WithoutCancel(ctx)); err != nil {
+				cmdLogger.ErrorContext(ctx, "post-stop failed", "error", err)
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			exitLogger.InfoContext(ctx, "exited", "cause", "shutdown", "reason", err)
+		case restart:
+			exitLogger.InfoContext(ctx, "exited", "cause", "restart", "reason", err)
+			cmdLogger.InfoContext(ctx, "restarting", "reason", errRestartRequested)
+			i.emit(Event{Type: EventRestarting})
+
+			continue
+		case pause:
+			exitLogger.InfoContext(ctx, "exited", "cause", "pause", "reason", err)
+
+			continue
+		case err != nil:
+			exitLogger.ErrorContext(ctx, "exited", "cause", "crash", "reason", err)
+		default:
+			exitLogger.InfoContext(ctx, "exited", "cause", "exit")
+		}
+
+		if err == nil && ctx.Err() == nil && i.clock().Now().Sub(started) < quickExitThreshold {
+			quickExits++
+		} else {
+			quickExits = 0
+		}
+		if quickExits == quickExitWarnCount && i.watched() && !i.Oneshot {
+			cmdLogger.WarnContext(ctx, "watched instance keeps exiting cleanly; it may not need to be watched",
+				"consecutive_clean_exits", quickExits)
+		}
+
+		if !i.watched() || i.Oneshot {
+			return err
+		}
+
+		uptime := i.clock().Now().Sub(started)
+		restarts++
+		if i.RestartPolicy.resets(uptime) {
+			restarts = 1
+		}
+		delay, reason := i.nextRestart(ctx, err, uptime, restarts)
+		if reason != nil {
+			cmdLogger.ErrorContext(ctx, "not restarting", "reason", reason, "attempts", restarts-1)
+			return err
+		}
+
+		if err := i.waitSchedule(ctx, cmdLogger); err != nil {
+			return err
+		}
+
+		if err := i.waitRestart(ctx, cmdLogger, delay); err != nil {
+			return err
+		}
+		i.emit(Event{Type: EventRestarting})
+
+		if err := i.waitLimit(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// argSize estimates the space the arguments and environment of the command
+// take up when it is executed: each string with its terminating NUL byte and a
+// pointer to it.
+func (i *Instance) argSize() int {
+	env := i.Env
+	if !i.CleanEnv {
+		env = slices.Concat(os.Environ(), i.Env)
+	}
+
+	var size int
+	for _, s := range slices.Concat([]string{cmp.Or(i.Argv0, i.Name)}, i.Args, env) {
+		size += len(s) + 1 + strconv.IntSize/8
+	}
+
+	return size
+}
+
+// String returns the command line of this instance as logged when it starts:
+// the resolved name followed by the arguments, separated by spaces, like
+// [exec.Cmd.String]. Builtins are prefixed with "builtin". Arguments computed
+// by ArgsProvider and commands created by a CommandFactory are not reflected.
+func (i *Instance) String() string {
+	words := append([]string{i.Name}, i.Args...)
+	if i.Builtin != nil && i.CommandFactory == nil {
+		words = append([]string{"builtin"}, words...)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// logger returns the instance logger, or a discarding logger if unset. The
+// logs of a labeled instance carry its label as the instance attribute.
+func (i *Instance) logger() *slog.Logger {
+	if i.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+
+	if i.Label != "" {
+		return i.Logger.With("instance", i.Label)
+	}
+
+	return i.Logger
+}
+
+// waitRestart waits for the restart delay of a watched instance. It returns
+// the context error if ctx is done first.
+func (i *Instance) waitRestart(ctx context.Context, logger *slog.Logger, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		logger.InfoContext(ctx, "not restarting", "reason", ctx.Err())
+		return ctx.Err()
+	case <-i.clock().After(delay):
+		logger.InfoContext(ctx, "restarting")
+		return nil
+	}
+}
+
+// waitSchedule waits until RestartSchedule allows a restart. It returns the
+// context error if ctx is done first.
+func (i *Instance) waitSchedule(ctx context.Context, logger *slog.Logger) error {
+	now := i.clock().Now()
+	next := i.RestartSchedule.Next(now)
+	if !next.After(now) {
+		return nil
+	}
+
+	logger.InfoContext(ctx, "deferring restart", "until", next)
+
+	timer := i.clock().NewTimer(next.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Restart stops the running process of this instance and starts it again,
+// regardless of whether the instance is watched. It has no effect if no process
+// is running. It is safe to call concurrently with [Instance.Run].
+func (i *Instance) Restart() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cancelRun != nil {
+		i.cancelRun(errRestartRequested)
+	}
+}
+
+// Pause stops the running process of this instance, if any, and keeps it from
+// being started again until [Instance.Resume] is called, such as for
+// maintenance. Stopping a process to pause it is not a failure. It is safe to
+// call concurrently with [Instance.Run].
+func (i *Instance) Pause() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.paused == nil {
+		i.paused = make(chan struct{})
+	}
+
+	if i.cancelRun != nil {
+		i.cancelRun(errPauseRequested)
+	}
+}
+
+// Resume starts a paused instance again. It has no effect if the instance is
+// not paused. It is safe to call concurrently with [Instance.Run].
+func (i *Instance) Resume() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.paused != nil {
+		close(i.paused)
+		i.paused = nil
+	}
+}
+
+// Paused reports whether the instance is paused. It is safe to call
+// concurrently with [Instance.Run].
+func (i *Instance) Paused() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.paused != nil
+}
+
+// waitResumed waits until the instance is not paused. It returns the context
+// error if ctx is done first.
+func (i *Instance) waitResumed(ctx context.Context, logger *slog.Logger) error {
+	i.mu.Lock()
+	paused := i.paused
+	i.mu.Unlock()
+
+	if paused == nil {
+		return nil
+	}
+
+	logger.InfoContext(ctx, "paused")
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-paused:
+		logger.InfoContext(ctx, "resumed")
+		return nil
+	}
+}
+
+// Running reports whether this instance has a running process. It is safe to
+// call concurrently with [Instance.Run].
+func (i *Instance) Running() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.cancelRun != nil
+}
+
+// watched reports whether this instance is restarted on exit.
+func (i *Instance) watched() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.Watch
+}
+
+// setWatch sets whether this instance is restarted on exit.
+func (i *Instance) setWatch(watch bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.Watch = watch
+}
+
+// isActive reports whether Run is executing.
+func (i *Instance) isActive() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.active
+}
+
+// setActive records whether Run is executing.
+func (i *Instance) setActive(active bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.active = active
+	i.ran = i.ran || active
+}
+
+// readiness reports whether a process of this instance has been ready, and
+// whether Run has returned after being called.
+func (i *Instance) readiness() (ready, ended bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.wasReady, i.ran && !i.active
+}
+
+// setCancelRun records the function that cancels the running process, or nil
+// if no process is running.
+func (i *Instance) setCancelRun(cancelRun context.CancelCauseFunc) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.cancelRun = cancelRun
+}
+
+// PID returns the process ID of the running process, or 0 if none is running
+// or it has no process of its own, like a builtin. It is safe to call
+// concurrently with [Instance.Run].
+func (i *Instance) PID() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.pid
+}
+
+// setPid records the process ID of the running process, or 0 if no process is
+// running.
+func (i *Instance) setPid(pid int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.pid = pid
+}
+
+// kill sends sig to the running process of this instance, or its process group
+// unless it shares the process group of this process. It does nothing if no
+// process with a known ID is running.
+func (i *Instance) kill(sig syscall.Signal) error {
+	i.mu.Lock()
+	pid := i.pid
+	i.mu.Unlock()
+
+	if pid <= 0 {
+		return nil
+	}
+
+	if i.sharedProcessGroup() && !i.Setsid {
+		return syscall.Kill(pid, sig) //nolint:wrapcheck // wrapped by caller
+	}
+
+	return signalGroup(pid, sig, syscall.Getpgid, syscall.Kill, i.logger())
+}
+
+// setStopSent records whether the stop signal was sent to the most recent
+// process.
+func (i *Instance) setStopSent(sent bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stopSent = sent
+}
+
+// wasStopped reports whether the stop signal was sent to the most recent
+// process.
+func (i *Instance) wasStopped() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.stopSent
+}
+
+// Stats returns the runtime statistics of this instance. It is safe to call
+// concurrently with [Instance.Run].
+func (i *Instance) Stats() Stats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	stats := i.stats
+	stats.Exits = slices.Clone(i.stats.Exits)
+
+	return stats
+}
+
+// Uptime returns how long the running process of this instance has been
+// running, or 0 if no process is running. It is safe to call concurrently with
+// [Instance.Run].
+func (i *Instance) Uptime() time.Duration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cancelRun == nil {
+		return 0
+	}
+
+	return i.clock().Now().Sub(i.stats.StartedAt)
+}
+
+// recordStart records the start of a process. It returns the function to
+// notify the group of the start and, if the process is a restart, the function
+// to notify dependents, if any.
+func (i *Instance) recordStart() (onStart, onRestart func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stats.Starts++
+	i.stats.StartedAt = i.clock().Now()
+	i.stats.ReadyAt = time.Time{}
+	if i.stats.FirstStart.IsZero() {
+		i.stats.FirstStart = i.stats.StartedAt
+	}
+
+	if i.stats.Starts == 1 {
+		return i.onStart, nil
+	}
+
+	return i.onStart, i.onRestart
+}
+
+// setReaper sets the reaper managing the processes of this instance.
+func (i *Instance) setReaper(r *reaper) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.reaper = r
+}
+
+// setLimiter sets the limiter of the restarts of this instance.
+func (i *Instance) setLimiter(limiter *restartLimiter) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.limiter = limiter
+}
+
+// waitLimit waits until a restart is allowed by the limiter, if any.
+func (i *Instance) waitLimit(ctx context.Context) error {
+	i.mu.Lock()
+	limiter := i.limiter
+	i.mu.Unlock()
+
+	if limiter == nil {
+		return nil
+	}
+
+	return limiter.wait(ctx)
+}
+
+// start starts cmd, registering it with the reaper, if any.
+func (i *Instance) start(cmd Cmd) error {
+	i.mu.Lock()
+	r := i.reaper
+	i.mu.Unlock()
+
+	if r == nil {
+		return cmd.Start() //nolint:wrapcheck // wrapped by caller
+	}
+
+	return r.start(cmd)
+}
+
+// processGroupDenied reports whether err from starting cmd may be caused by
+// not being permitted to create a process group for it.
+func processGroupDenied(cmd Cmd, err error) bool {
+	if daemon, ok := cmd.(daemonCmd); ok {
+		cmd = daemon.Cmd
+	}
+	c, ok := cmd.(execCmd)
+
+	return ok && c.cmd.SysProcAttr != nil && c.cmd.SysProcAttr.Setpgid && errors.Is(err, syscall.EPERM)
+}
+
+// sharedProcessGroup reports whether commands run in the process group of
+// this process, because of SharedProcessGroup or ProcessGroupFallback.
+func (i *Instance) sharedProcessGroup() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.SharedProcessGroup || i.noProcessGroup
+}
+
+// setNoProcessGroup records whether ProcessGroupFallback applies.
+func (i *Instance) setNoProcessGroup(enabled bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.noProcessGroup = enabled
+}
+
+// wait waits for cmd, unregistering it from the reaper, if any.
+func (i *Instance) wait(cmd Cmd) error {
+	err := cmd.Wait()
+
+	i.mu.Lock()
+	r := i.reaper
+	i.mu.Unlock()
+
+	if r != nil {
+		r.done(cmd.Pid())
+	}
+
+	return err //nolint:wrapcheck // process exit error
+}
+
+// setOnStart sets the function notifying the group of a start.
+func (i *Instance) setOnStart(onStart func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.onStart = onStart
+}
+
+// setOnRestart sets the function notifying dependents of a restart.
+func (i *Instance) setOnRestart(onRestart func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.onRestart = onRestart
+}
+
+// setOnEvent sets the function notifying the group of an event.
+func (i *Instance) setOnEvent(onEvent func(Event)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.onEvent = onEvent
+}
+
+// emit notifies the group of an event, if the instance is run by one.
+func (i *Instance) emit(event Event) {
+	i.mu.Lock()
+	onEvent := i.onEvent
+	i.mu.Unlock()
+
+	if onEvent != nil {
+		onEvent(event)
+	}
+}
+
+// mapExit applies ExitCodeMap to the exit error of a process. It returns nil
+// if the exit code is mapped to 0.
+func (i *Instance) mapExit(err error) error {
+	exitErr, ok := errors.AsType[*exec.ExitError](err)
+	if !ok || exitErr.ExitCode() < 0 {
+		return err
+	}
+
+	code, ok := i.ExitCodeMap[exitErr.ExitCode()]
+	switch {
+	case !ok:
+		return err
+	case code == 0:
+		return nil
+	default:
+		return &mappedExitError{code: code, err: err}
+	}
+}
+
+// recordReady records that the most recently started process became ready.
+func (i *Instance) recordReady() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stats.ReadyAt = i.clock().Now()
+	i.wasReady = true
+}
+
+// recordExit records the exit of a process and returns it.
+func (i *Instance) recordExit(err error) Exit {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stats.LastExit = i.clock().Now()
+	i.stats.LastErr = err
+	i.stats.ExitCode = exitCode(err)
+
+	exit := Exit{
+		StartedAt: i.stats.StartedAt,
+		ReadyAt:   i.stats.ReadyAt,
+		Time:      i.stats.LastExit,
+		ExitCode:  i.stats.ExitCode,
+	}
+	if sig, ok := exitSignal(err); ok {
+		exit.Signal = signalName(sig)
+	}
+	if len(i.stats.Exits) == exitHistoryLen {
+		i.stats.Exits = slices.Delete(i.stats.Exits, 0, 1)
+	}
+	i.stats.Exits = append(i.stats.Exits, exit)
+
+	return exit
+}
+
+// LastUsage returns the resource usage of the most recently exited process of
+// this instance. It is safe to call concurrently with [Instance.Run].
+func (i *Instance) LastUsage() Usage {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.lastUsage
+}
+
+// setLastUsage records the resource usage of an exited process.
+func (i *Instance) setLastUsage(usage Usage) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.lastUsage = usage
+}
+
+// args returns the arguments for the next start of this instance.
+func (i *Instance) args() []string {
+	if args := i.providedArgs(); args != nil {
+		return args
+	}
+
+	return i.Args
+}
+
+// providedArgs returns the arguments the ArgsProvider provides for the next
+// start, or nil if there is none or it provides none.
+func (i *Instance) providedArgs() []string {
+	if i.ArgsProvider == nil {
+		return nil
+	}
+
+	return i.ArgsProvider(i.Stats().Starts)
+}
+
+// command creates the [Cmd] for the next start of this instance with args
+// and the environment variables env added.
+func (i *Instance) command(ctx context.Context, args, env []string) Cmd {
+	switch {
+	case i.CommandFactory != nil:
+		return i.CommandFactory(ctx, i)
+	case i.Builtin != nil:
+		return newBuiltinCmd(ctx, i.Builtin, i.Name, args)
+	default:
+		cmd, stopKill := i.newCmd(ctx, args, env)
+
+		var (
+			outputs []*lineLogger
+			queue   *outputQueue
+			dropped func(n int64)
+		)
+		logOutput := i.LogOutput && !i.DiscardOutput
+		if logOutput {
+			logger := i.logger().With("cmd", cmd.String())
+			stdout := newLineLogger(logger, "stdout", i.MaxLineBytes)
+			stderr := newLineLogger(logger, "stderr", i.MaxLineBytes)
+			stdout.flushInterval, stderr.flushInterval = i.OutputFlush, i.OutputFlush
+			stdout.clk, stderr.clk = i.clock(), i.clock()
+			queue = newOutputQueue(i.OutputOverflow == "drop")
+			stdout.queue, stderr.queue = queue, queue
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+			outputs = []*lineLogger{stdout, stderr}
+			dropped = func(n int64) {
+				i.recordDroppedOutput(n)
+				logger.WarnContext(ctx, "output dropped", "records", n, "reason", "log handler too slow")
+			}
+		}
+
+		if i.CaptureOutput {
+			output := &outputBuffer{}
+			i.setOutput(output)
+			if logOutput {
+				cmd.Stdout = io.MultiWriter(cmd.Stdout, output)
+			} else {
+				cmd.Stdout = output
+			}
+		}
+
+		if i.MaxOutputBytes > 0 {
+			limit := newOutputLimit(i.logger().With("cmd", cmd.String()), i.MaxOutputBytes)
+			if cmd.Stdout != nil {
+				cmd.Stdout = limit.writer(cmd.Stdout)
+			}
+			if cmd.Stderr != nil {
+				cmd.Stderr = limit.writer(cmd.Stderr)
+			}
+		}
+
+		cmd.Stdout = i.countOutput(cmd.Stdout, func(stats *Stats) *OutputStats { return &stats.Stdout })
+		cmd.Stderr = i.countOutput(cmd.Stderr, func(stats *Stats) *OutputStats { return &stats.Stderr })
+
+		return i.daemon(ctx, execCmd{
+			cmd: cmd, outputs: outputs, queue: queue, dropped: dropped, start: i.startExec, stopKill: stopKill,
+		})
+	}
+}
+
+// countOutput wraps w to count the output written to it in the statistics of
+// the stream selected by stream. Output that is not copied, because w is nil
+// or a file the command writes to directly, is not counted.
+func (i *Instance) countOutput(w io.Writer, stream func(*Stats) *OutputStats) io.Writer {
+	if _, ok := w.(*os.File); ok || w == nil {
+		return w
+	}
+
+	return &countingWriter{w: w, count: func(p []byte) {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		stats := stream(&i.stats)
+		stats.Bytes += int64(len(p))
+		stats.Lines += int64(bytes.Count(p, []byte{'\n'}))
+	}}
+}
+
+// recordDroppedOutput counts n records of logged output that were dropped.
+func (i *Instance) recordDroppedOutput(n int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.stats.DroppedOutput += n
+}
+
+// Output returns the captured stdout of the most recent process of this
+// instance, or nil if there is none. It is safe to call concurrently with
+// [Instance.Run].
+func (i *Instance) Output() []byte {
+	i.mu.Lock()
+	output := i.output
+	i.mu.Unlock()
+
+	if output == nil {
+		return nil
+	}
+
+	return output.Bytes()
+}
+
+// setOutput sets the buffer capturing the output of the next process.
+func (i *Instance) setOutput(output *outputBuffer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.output = output
+}
+
+// newCmd creates a new [exec.Cmd] with process group handling for clean
+// termination. The returned function stops escalating to the kill signal and
+// must be called once the command has been waited for.
+func (i *Instance) newCmd(ctx context.Context, args, env []string) (*exec.Cmd, func()) {
+	// #nosec G204 -- user/caller is responsible for name and args
+	cmd := exec.CommandContext(ctx, i.Name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	if i.CleanEnv {
+		// A nil Env would inherit the environment.
+		cmd.Env = append([]string{}, env...)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if i.DiscardOutput {
+		// Unlike io.Discard, nil connects the command to the null
+		// device, so its output is not copied at all.
+		cmd.Stdout, cmd.Stderr = nil, nil
+	}
+	killGrace := cmp.Or(max(i.KillGrace, 0), cmdWaitDelay)
+	killSignal := cmp.Or(i.KillSignal, syscall.SIGKILL)
+	sharedProcessGroup := i.sharedProcessGroup()
+	signal := func(sig syscall.Signal) error {
+		if sharedProcessGroup && !i.Setsid {
+			if err := cmd.Process.Signal(sig); err != nil {
+				return fmt.Errorf("signal process: %w", err)
+			}
+
+			return nil
+		}
+
+		return signalGroup(cmd.Process.Pid, sig, syscall.Getpgid, syscall.Kill, i.logger())
+	}
+	var (
+		killMu    sync.Mutex
+		killTimer timer
+		waited    bool
+	)
+	stopKill := func() {
+		killMu.Lock()
+		defer killMu.Unlock()
+
+		waited = true
+		if killTimer != nil {
+			killTimer.Stop()
+		}
+	}
+	cmd.Cancel = func() error {
+		pid := cmd.Process.Pid
+		if killSignal != syscall.SIGKILL && !i.ForceKill {
+			// os/exec only kills with SIGKILL after WaitDelay, so
+			// escalate to the kill signal first.
+			killMu.Lock()
+			killTimer = i.clock().AfterFunc(killGrace, func() {
+				killMu.Lock()
+				defer killMu.Unlock()
+
+				if waited {
+					return // The process group may be gone.
+				}
+				i.logger().WarnContext(ctx, "killing", "pid", pid, "signal", signalName(killSignal))
+				if err := signal(killSignal); err != nil {
+					i.logger().ErrorContext(ctx, "killing failed", "pid", pid, "error", err)
+				}
+			})
+			killMu.Unlock()
+		}
+
+		i.setStopSent(true)
+
+		return signal(i.stopSignal())
+	}
+	if i.Argv0 != "" {
+		// Path is executed; Args[0] is only what the command sees.
+		cmd.Args[0] = i.Argv0
+	}
+	cmd.WaitDelay = killGrace
+	if killSignal != syscall.SIGKILL {
+		cmd.WaitDelay += cmdKillDelay
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: !sharedProcessGroup, // Create new process group.
+	}
+	if i.Setsid {
+		// A new session also creates a new process group, and a session
+		// leader cannot change its process group, so Setpgid would fail.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 	}
 
-	return cmd
+	return cmd, stopKill
 }
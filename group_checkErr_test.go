@@ -31,8 +31,11 @@ func TestCheckErr(t *testing.T) {
 	}
 
 	tests := map[string]struct {
-		err     func(*testing.T) error
-		wantErr assert.ErrorAssertionFunc
+		err        func(*testing.T) error
+		stopSignal syscall.Signal
+		strict     bool
+		stopSent   bool
+		wantErr    assert.ErrorAssertionFunc
 	}{
 		"nil": {
 			err:     func(*testing.T) error { return nil },
@@ -61,12 +64,39 @@ func TestCheckErr(t *testing.T) {
 			err:     signalErr(syscall.SIGKILL),
 			wantErr: assert.Error,
 		},
+		"stop signal": {
+			err:        signalErr(syscall.SIGINT),
+			stopSignal: syscall.SIGINT,
+			wantErr:    assert.NoError,
+		},
+		"sigterm with other stop signal": {
+			err:        signalErr(syscall.SIGTERM),
+			stopSignal: syscall.SIGINT,
+			wantErr:    assert.Error,
+		},
+		"strict context canceled": {
+			err:     func(*testing.T) error { return context.Canceled },
+			strict:  true,
+			wantErr: assert.NoError,
+		},
+		"strict sigterm": {
+			err:     signalErr(syscall.SIGTERM),
+			strict:  true,
+			wantErr: assert.Error,
+		},
+		"strict sigterm sent to stop": {
+			err:      signalErr(syscall.SIGTERM),
+			strict:   true,
+			stopSent: true,
+			wantErr:  assert.NoError,
+		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			tt.wantErr(t, checkErr(tt.err(t)))
+			instance := &Instance{StopSignal: tt.stopSignal, StrictExit: tt.strict, stopSent: tt.stopSent}
+			tt.wantErr(t, instance.checkErr(tt.err(t)))
 		})
 	}
 }
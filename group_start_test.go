@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProcessGroupFallback tests retrying a start once without a process
+// group of its own when creating one is not permitted.
+func TestProcessGroupFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		fallback     bool
+		err          error
+		alwaysFail   bool
+		wantErr      error
+		wantAttempts []bool
+		wantShared   bool
+		wantWarning  bool
+	}{
+		"fallback": {
+			fallback:     true,
+			err:          syscall.EPERM,
+			wantAttempts: []bool{true, false},
+			wantShared:   true,
+			wantWarning:  true,
+		},
+		"disabled": {
+			fallback:     false,
+			err:          syscall.EPERM,
+			wantErr:      syscall.EPERM,
+			wantAttempts: []bool{true},
+		},
+		"other error": {
+			fallback:     true,
+			err:          syscall.ENOENT,
+			wantErr:      syscall.ENOENT,
+			wantAttempts: []bool{true},
+		},
+		"retry fails": {
+			fallback:     true,
+			err:          syscall.EPERM,
+			alwaysFail:   true,
+			wantErr:      syscall.EPERM,
+			wantAttempts: []bool{true, false},
+			wantWarning:  true,
+		},
+	}
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var attempts []bool
+			handler := &recordHandler{}
+			instance := &Instance{
+				Name:                 truePath,
+				Logger:               slog.New(handler),
+				ProcessGroupFallback: tt.fallback,
+				startExec: func(cmd *exec.Cmd) error {
+					setpgid := cmd.SysProcAttr.Setpgid
+					attempts = append(attempts, setpgid)
+					if setpgid || tt.alwaysFail {
+						return &os.PathError{Op: "fork/exec", Path: cmd.Path, Err: tt.err}
+					}
+
+					return cmd.Start() //nolint:wrapcheck // test
+				},
+			}
+
+			err := instance.Run(t.Context())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAttempts, attempts)
+			assert.Equal(t, tt.wantShared, instance.sharedProcessGroup())
+
+			var warned bool
+			for _, r := range handler.records {
+				warned = warned || r.Message == "starting without process group"
+			}
+			assert.Equal(t, tt.wantWarning, warned)
+		})
+	}
+}
@@ -3,8 +3,21 @@ package main_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -103,6 +116,217 @@ func TestNew(t *testing.T) {
 			},
 			wantErr: assert.Error,
 		},
+		"template values": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--port={{.port}}"}),
+				cmdgroup.WithTemplateValues([]map[string]string{{"port": "80"}, {"port": "443"}}),
+				cmdgroup.WithWatch("1"),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"--port=80"}, Watch: false, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"--port=443"}, Watch: true, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"template missing key": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--port={{.port}}"}),
+				cmdgroup.WithTemplateValues([]map[string]string{{"name": "http"}}),
+			},
+			wantErr: assert.Error,
+		},
+		"watch trailing separator": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2", "--"}),
+				cmdgroup.WithWatch("2"),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"arg1"}, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"arg2"}, Logger: discardLogger},
+				{Name: cmdPath, Args: nil, Watch: true, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"strict watch trailing separator": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2", "--"}),
+				cmdgroup.WithWatch("2"),
+				cmdgroup.WithStrictWatch(true),
+			},
+			wantErr: assert.Error,
+		},
+		"strict watch duplicate index": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2"}),
+				cmdgroup.WithWatch("1,1"),
+				cmdgroup.WithStrictWatch(true),
+			},
+			wantErr: assert.Error,
+		},
+		"strict watch duplicate template instance": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "{{.shard}}"}),
+				cmdgroup.WithTemplateValues([]map[string]string{{"shard": "a"}, {"shard": "a"}}),
+				cmdgroup.WithWatch("1"),
+				cmdgroup.WithStrictWatch(true),
+			},
+			wantErr: assert.Error,
+		},
+		"strict watch single instance": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"arg1"}),
+				cmdgroup.WithWatch("0"),
+				cmdgroup.WithStrictWatch(true),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"arg1"}, Watch: true, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"strict watch valid": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2"}),
+				cmdgroup.WithWatch("1"),
+				cmdgroup.WithStrictWatch(true),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"arg1"}, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"arg2"}, Watch: true, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"stop group on exit": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2"}),
+				cmdgroup.WithStopGroupOnExit("0"),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"arg1"}, StopGroupOnExit: true, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"arg2"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"oneshot": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2"}),
+				cmdgroup.WithOneshot(0, true),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"arg1"}, Oneshot: true, OneshotFatal: true, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"arg2"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"stop group on exit index out of range": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithStopGroupOnExit("1")},
+			wantErr: assert.Error,
+		},
+		"args last wins": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"a"}),
+				cmdgroup.WithArgs([]string{"b"}),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"b"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"append args": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithAppendArgs([]string{"-n"}),
+				cmdgroup.WithAppendArgs([]string{"--", "a"}),
+				cmdgroup.WithAppendArgs([]string{"--", "b"}),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"-n", "a"}, Logger: discardLogger},
+				{Name: cmdPath, Args: []string{"-n", "b"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"args then append args": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"a"}),
+				cmdgroup.WithAppendArgs([]string{"b"}),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"a", "b"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"append args then args": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{
+				cmdgroup.WithAppendArgs([]string{"a"}),
+				cmdgroup.WithArgs([]string{"b"}),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, Args: []string{"b"}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"skip lookup": {
+			cmdName: "/nonexistent/binary",
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "arg1", "--", "arg2"}),
+				cmdgroup.WithWatch("1"),
+				cmdgroup.WithSkipLookup(true),
+			},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: "/nonexistent/binary", Args: []string{"arg1"}, Logger: discardLogger},
+				{Name: "/nonexistent/binary", Args: []string{"arg2"}, Watch: true, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"invalid reload strategy": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithReloadStrategy("random")},
+			wantErr: assert.Error,
+		},
+		"output overflow": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithOutputOverflow("drop")},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, OutputOverflow: "drop", Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"invalid output overflow": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithOutputOverflow("spill")},
+			wantErr: assert.Error,
+		},
+		"cpu affinity": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithCPUAffinity(0, []int{0})},
+			wantInstances: []*cmdgroup.Instance{
+				{Name: cmdPath, CPUAffinity: []int{0}, Logger: discardLogger},
+			},
+			wantErr: assert.NoError,
+		},
+		"cpu affinity out of range": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithCPUAffinity(0, []int{runtime.NumCPU()})},
+			wantErr: assert.Error,
+		},
+		"cpu affinity negative": {
+			cmdName: cmdName,
+			options: []cmdgroup.Option{cmdgroup.WithCPUAffinity(0, []int{-1})},
+			wantErr: assert.Error,
+		},
 		"watch negative index": {
 			cmdName: cmdName,
 			options: []cmdgroup.Option{
@@ -125,6 +349,56 @@ func TestNew(t *testing.T) {
 	}
 }
 
+// TestGroupRunSkipLookup tests that a group with unresolved commands does not
+// run.
+func TestGroupRunSkipLookup(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("true", cmdgroup.WithSkipLookup(true))
+	require.NoError(t, err)
+	require.Error(t, group.Run(t.Context()))
+	assert.Equal(t, 0, group.Instances[0].Stats().Starts)
+}
+
+// TestNewBuiltinFallback tests falling back to builtin commands.
+func TestNewBuiltinFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		cmdName string
+		options []cmdgroup.Option
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"fallback disabled": {
+			cmdName: "/nonexistent/true",
+			wantErr: assert.Error,
+		},
+		"fallback enabled": {
+			cmdName: "/nonexistent/true",
+			options: []cmdgroup.Option{cmdgroup.WithBuiltinFallback(true)},
+			wantErr: assert.NoError,
+		},
+		"fallback enabled unknown builtin": {
+			cmdName: "/nonexistent/binary",
+			options: []cmdgroup.Option{cmdgroup.WithBuiltinFallback(true)},
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			group, err := cmdgroup.New(tt.cmdName, tt.options...)
+			tt.wantErr(t, err)
+			if group != nil {
+				require.Len(t, group.Instances, 1)
+				assert.NotNil(t, group.Instances[0].Builtin)
+				assert.NoError(t, group.Run(t.Context()))
+			}
+		})
+	}
+}
+
 // TestGroupRun tests running a Group of instances.
 func TestGroupRun(t *testing.T) {
 	t.Parallel()
@@ -139,8 +413,9 @@ func TestGroupRun(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
 
 	tests := map[string]struct {
-		instances []*cmdgroup.Instance
-		wantErr   assert.ErrorAssertionFunc
+		instances  []*cmdgroup.Instance
+		maxRuntime time.Duration
+		wantErr    assert.ErrorAssertionFunc
 	}{
 		"all succeed": {
 			instances: []*cmdgroup.Instance{
@@ -163,12 +438,67 @@ func TestGroupRun(t *testing.T) {
 			},
 			wantErr: assert.Error,
 		},
+		"max runtime stops instances": {
+			instances: []*cmdgroup.Instance{
+				{Name: sleepPath, Args: []string{"60"}, Logger: logger},
+				{Name: sleepPath, Args: []string{"60"}, Watch: true, Logger: logger},
+			},
+			maxRuntime: 100 * time.Millisecond,
+			wantErr:    assert.NoError,
+		},
+		"max runtime not reached": {
+			instances: []*cmdgroup.Instance{
+				{Name: falsePath, Logger: logger},
+			},
+			maxRuntime: time.Minute,
+			wantErr:    assert.Error,
+		},
+		"oneshot failure ignored": {
+			instances: []*cmdgroup.Instance{
+				{Name: falsePath, Oneshot: true, Logger: logger},
+				{Name: truePath, Logger: logger},
+			},
+			wantErr: assert.NoError,
+		},
+		"fatal oneshot failure cancels watched instance": {
+			instances: []*cmdgroup.Instance{
+				{Name: falsePath, Oneshot: true, OneshotFatal: true, Logger: logger},
+				{Name: sleepPath, Args: []string{"60"}, Watch: true, Logger: logger},
+			},
+			wantErr: assert.Error,
+		},
+		"oneshot is not restarted": {
+			instances: []*cmdgroup.Instance{
+				{Name: truePath, Watch: true, Oneshot: true, Logger: logger},
+			},
+			wantErr: assert.NoError,
+		},
+		"watched oneshot failure ignored": {
+			instances: []*cmdgroup.Instance{
+				{Name: falsePath, Watch: true, Oneshot: true, Logger: logger},
+			},
+			wantErr: assert.NoError,
+		},
+		"stop group on exit": {
+			instances: []*cmdgroup.Instance{
+				{Name: truePath, StopGroupOnExit: true, Logger: logger},
+				{Name: sleepPath, Args: []string{"60"}, Watch: true, Logger: logger},
+			},
+			wantErr: assert.NoError,
+		},
+		"stop group on failed exit": {
+			instances: []*cmdgroup.Instance{
+				{Name: falsePath, StopGroupOnExit: true, Logger: logger},
+				{Name: sleepPath, Args: []string{"60"}, Watch: true, Logger: logger},
+			},
+			wantErr: assert.Error,
+		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			group := &cmdgroup.Group{Instances: tt.instances}
+			group := &cmdgroup.Group{Instances: tt.instances, MaxRuntime: tt.maxRuntime}
 			err := group.Run(t.Context())
 			tt.wantErr(t, err)
 		})
@@ -185,6 +515,8 @@ func TestInstanceRun(t *testing.T) {
 	require.NoError(t, err)
 	sleepPath, err := exec.LookPath("sleep")
 	require.NoError(t, err)
+	shPath, err := exec.LookPath("sh")
+	require.NoError(t, err)
 
 	tests := map[string]struct {
 		cmdPath         string
@@ -199,10 +531,24 @@ func TestInstanceRun(t *testing.T) {
 		"unwatched success": {
 			cmdPath: truePath,
 			wantErr: require.NoError,
+			wantLog: "cause=exit",
 		},
 		"unwatched failure": {
 			cmdPath: falsePath,
 			wantErr: require.Error,
+			wantLog: "cause=crash",
+		},
+		"killed by SIGSEGV": {
+			cmdPath: shPath,
+			args:    []string{"-c", "kill -SEGV $$"},
+			wantErr: require.Error,
+			wantLog: "signal=SIGSEGV",
+		},
+		"killed by SIGKILL": {
+			cmdPath: shPath,
+			args:    []string{"-c", "kill -KILL $$"},
+			wantErr: require.Error,
+			wantLog: "signal=SIGKILL",
 		},
 		"start error": {
 			cmdPath:         "/nonexistent/binary",
@@ -223,6 +569,20 @@ func TestInstanceRun(t *testing.T) {
 			wantErrIs: context.DeadlineExceeded,
 			wantLog:   "msg=restarting",
 		},
+		"watched clean exits warn": {
+			cmdPath: truePath,
+			watch:   true,
+			ctx: func(t *testing.T) context.Context {
+				t.Helper()
+				ctx, cancel := context.WithTimeout(t.Context(), 2500*time.Millisecond)
+				t.Cleanup(cancel)
+
+				return ctx
+			},
+			wantErr:   require.Error,
+			wantErrIs: context.DeadlineExceeded,
+			wantLog:   "consecutive_clean_exits=3",
+		},
 		"watched context cancel stops restart": {
 			cmdPath: sleepPath,
 			args:    []string{"60"},
@@ -240,6 +600,7 @@ func TestInstanceRun(t *testing.T) {
 			},
 			wantErr:   require.Error,
 			wantErrIs: context.Canceled,
+			wantLog:   "cause=shutdown",
 		},
 	}
 
@@ -280,3 +641,2603 @@ func TestInstanceRun(t *testing.T) {
 		})
 	}
 }
+
+// TestInstanceLastUsage tests recording resource usage on exit.
+func TestInstanceLastUsage(t *testing.T) {
+	t.Parallel()
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	instance := &cmdgroup.Instance{
+		Name:   truePath,
+		Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+
+	assert.Zero(t, instance.LastUsage())
+	require.NoError(t, instance.Run(t.Context()))
+	assert.Positive(t, instance.LastUsage().MaxRSS)
+	assert.Contains(t, buf.String(), "usage.max_rss=")
+}
+
+// TestGroupRunning tests counting running instances.
+func TestGroupRunning(t *testing.T) {
+	t.Parallel()
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+	sleepPath, err := exec.LookPath("sleep")
+	require.NoError(t, err)
+
+	group := &cmdgroup.Group{Instances: []*cmdgroup.Instance{
+		{Name: truePath},
+		{Name: sleepPath, Args: []string{"60"}},
+	}}
+	assert.Zero(t, group.Running())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == 1 && group.Instances[1].Running()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Zero(t, group.Running())
+}
+
+// TestInstancePreStart tests running a setup function before each start.
+func TestInstancePreStart(t *testing.T) {
+	t.Parallel()
+
+	catPath, err := exec.LookPath("cat")
+	require.NoError(t, err)
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+
+	t.Run("creates file read by command", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "setup")
+		group, err := cmdgroup.New(catPath,
+			cmdgroup.WithArgs([]string{path}),
+			cmdgroup.WithPreStart(0, func(context.Context) error {
+				return os.WriteFile(path, nil, 0o600)
+			}),
+		)
+		require.NoError(t, err)
+		require.NoError(t, group.Run(t.Context()))
+	})
+
+	t.Run("failure prevents start", func(t *testing.T) {
+		t.Parallel()
+		instance := &cmdgroup.Instance{
+			Name:     truePath,
+			PreStart: func(context.Context) error { return errors.New("setup failed") },
+		}
+		require.ErrorContains(t, instance.Run(t.Context()), "pre-start")
+		assert.Zero(t, instance.LastUsage())
+	})
+
+	t.Run("runs on every restart", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		instance := &cmdgroup.Instance{
+			Name:  truePath,
+			Watch: true,
+			PreStart: func(context.Context) error {
+				calls.Add(1)
+				return nil
+			},
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), 1500*time.Millisecond)
+		t.Cleanup(cancel)
+		require.ErrorIs(t, instance.Run(ctx), context.DeadlineExceeded)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("index out of range", func(t *testing.T) {
+		t.Parallel()
+		_, err := cmdgroup.New(truePath, cmdgroup.WithPreStart(1, func(context.Context) error { return nil }))
+		require.Error(t, err)
+	})
+}
+
+// TestInstancePostStop tests running a cleanup function after each exit.
+func TestInstancePostStop(t *testing.T) {
+	t.Parallel()
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+	sleepPath, err := exec.LookPath("sleep")
+	require.NoError(t, err)
+
+	t.Run("runs on every restart", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		instance := &cmdgroup.Instance{
+			Name:  truePath,
+			Watch: true,
+			PostStop: func(context.Context) error {
+				calls.Add(1)
+				return nil
+			},
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), 1500*time.Millisecond)
+		t.Cleanup(cancel)
+		require.ErrorIs(t, instance.Run(ctx), context.DeadlineExceeded)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("runs on shutdown", func(t *testing.T) {
+		t.Parallel()
+		ctxErr := errors.New("not called")
+		instance := &cmdgroup.Instance{
+			Name:  sleepPath,
+			Args:  []string{"60"},
+			Watch: true,
+			PostStop: func(ctx context.Context) error {
+				ctxErr = ctx.Err()
+				return nil
+			},
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		t.Cleanup(cancel)
+		require.ErrorIs(t, instance.Run(ctx), context.DeadlineExceeded)
+		assert.NoError(t, ctxErr)
+	})
+
+	t.Run("error does not mask exit", func(t *testing.T) {
+		t.Parallel()
+		instance := &cmdgroup.Instance{
+			Name:     truePath,
+			PostStop: func(context.Context) error { return errors.New("cleanup failed") },
+		}
+		require.NoError(t, instance.Run(t.Context()))
+	})
+}
+
+// TestIndexFromContext tests telling instances apart in hooks they share.
+func TestIndexFromContext(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu        sync.Mutex
+		preStart  = make(map[int]int)
+		postStop  = make(map[int]int)
+		hookError error
+	)
+	record := func(calls map[int]int) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			index, ok := cmdgroup.IndexFromContext(ctx)
+			if !ok {
+				hookError = errors.New("no index in context")
+			}
+			calls[index]++
+
+			return nil
+		}
+	}
+
+	options := []cmdgroup.Option{cmdgroup.WithArgs([]string{"--", "--", "--"})}
+	for idx := range 3 {
+		options = append(options,
+			cmdgroup.WithPreStart(idx, record(preStart)),
+			cmdgroup.WithPostStop(idx, record(postStop)),
+		)
+	}
+	group, err := cmdgroup.New("true", options...)
+	require.NoError(t, err)
+	require.NoError(t, group.Run(t.Context()))
+
+	require.NoError(t, hookError)
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1}, preStart)
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1}, postStop)
+
+	_, ok := cmdgroup.IndexFromContext(t.Context())
+	assert.False(t, ok)
+}
+
+// fakeCmd is a [cmdgroup.Cmd] that exits with exitErr after delay, or with the
+// context error when its context is done first.
+type fakeCmd struct {
+	ctx      context.Context //nolint:containedctx // mirrors exec.CommandContext
+	startErr error
+	exitErr  error
+	delay    time.Duration
+}
+
+func (c *fakeCmd) Start() error                 { return c.startErr }
+func (*fakeCmd) String() string                 { return "fake" }
+func (*fakeCmd) Pid() int                       { return 0 }
+func (*fakeCmd) ProcessState() *os.ProcessState { return nil }
+
+func (c *fakeCmd) Wait() error {
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-time.After(c.delay):
+		return c.exitErr
+	}
+}
+
+// fakeFactory returns a [cmdgroup.CommandFactory] creating copies of cmd.
+func fakeFactory(cmd fakeCmd) cmdgroup.CommandFactory {
+	return func(ctx context.Context, _ *cmdgroup.Instance) cmdgroup.Cmd {
+		c := cmd
+		c.ctx = ctx
+
+		return &c
+	}
+}
+
+// TestGroupRunCommandFactory tests running instances with fake commands.
+func TestGroupRunCommandFactory(t *testing.T) {
+	t.Parallel()
+
+	errExit := errors.New("exit status 3")
+
+	tests := map[string]struct {
+		factories    []cmdgroup.CommandFactory
+		wantErr      assert.ErrorAssertionFunc
+		wantErrIs    error
+		wantExitCode int
+	}{
+		"success": {
+			factories: []cmdgroup.CommandFactory{fakeFactory(fakeCmd{})},
+			wantErr:   assert.NoError,
+		},
+		"start failure": {
+			factories: []cmdgroup.CommandFactory{fakeFactory(fakeCmd{startErr: errExit})},
+			wantErr:   assert.Error,
+			wantErrIs: errExit,
+		},
+		"exit failure": {
+			factories:    []cmdgroup.CommandFactory{fakeFactory(fakeCmd{exitErr: errExit})},
+			wantErr:      assert.Error,
+			wantErrIs:    errExit,
+			wantExitCode: -1,
+		},
+		"failure stops slow instance": {
+			factories: []cmdgroup.CommandFactory{
+				fakeFactory(fakeCmd{exitErr: errExit}),
+				fakeFactory(fakeCmd{delay: time.Hour}),
+			},
+			wantErr:      assert.Error,
+			wantErrIs:    errExit,
+			wantExitCode: -1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var instances []*cmdgroup.Instance
+			for _, factory := range tt.factories {
+				instances = append(instances, &cmdgroup.Instance{Name: "fake", CommandFactory: factory})
+			}
+
+			group := &cmdgroup.Group{Instances: instances}
+			err := group.Run(t.Context())
+			tt.wantErr(t, err)
+			if tt.wantErrIs != nil {
+				require.ErrorIs(t, err, tt.wantErrIs)
+			}
+			assert.Equal(t, tt.wantExitCode, group.Instances[0].Stats().ExitCode)
+		})
+	}
+}
+
+// TestNewCommandFactory tests that command names are not resolved with a
+// command factory.
+func TestNewCommandFactory(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("/nonexistent/binary",
+		cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+		cmdgroup.WithCommandFactory(fakeFactory(fakeCmd{})),
+	)
+	require.NoError(t, err)
+	require.Len(t, group.Instances, 2)
+	assert.Equal(t, "/nonexistent/binary", group.Instances[0].Name)
+	require.NoError(t, group.Run(t.Context()))
+	assert.Equal(t, 1, group.Instances[1].Stats().Starts)
+}
+
+// TestDependsOn tests restarting dependents when a dependency restarts.
+func TestDependsOn(t *testing.T) {
+	t.Parallel()
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+
+	t.Run("invalid dependencies", func(t *testing.T) {
+		t.Parallel()
+		args := cmdgroup.WithArgs([]string{"--", "a", "--", "b", "--", "c"})
+		for _, options := range [][]cmdgroup.Option{
+			{args, cmdgroup.WithDependsOn(0, 0)},
+			{args, cmdgroup.WithDependsOn(0, 1), cmdgroup.WithDependsOn(1, 0)},
+			{args, cmdgroup.WithDependsOn(0, 1), cmdgroup.WithDependsOn(1, 2), cmdgroup.WithDependsOn(2, 0)},
+			{args, cmdgroup.WithDependsOn(0, 3)},
+		} {
+			_, err := cmdgroup.New(truePath, options...)
+			require.Error(t, err)
+		}
+
+		group := &cmdgroup.Group{Instances: []*cmdgroup.Instance{{Name: truePath, DependsOn: []int{0}}}}
+		require.Error(t, group.Run(t.Context()))
+	})
+
+	t.Run("valid dependencies", func(t *testing.T) {
+		t.Parallel()
+		group, err := cmdgroup.New(truePath,
+			cmdgroup.WithArgs([]string{"--", "a", "--", "b", "--", "c"}),
+			cmdgroup.WithDependsOn(2, 1),
+			cmdgroup.WithDependsOn(2, 0),
+			cmdgroup.WithDependsOn(1, 0),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 0}, group.Instances[2].DependsOn)
+	})
+
+	t.Run("dependency restart restarts dependent", func(t *testing.T) {
+		t.Parallel()
+		group := &cmdgroup.Group{Instances: []*cmdgroup.Instance{
+			{Name: "dependency", Watch: true, CommandFactory: fakeFactory(fakeCmd{delay: 10 * time.Millisecond})},
+			{Name: "dependent", Watch: true, DependsOn: []int{0}, CommandFactory: fakeFactory(fakeCmd{delay: time.Hour})},
+		}}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		t.Cleanup(cancel)
+		time.AfterFunc(1500*time.Millisecond, cancel)
+		require.NoError(t, group.Run(ctx))
+		assert.Equal(t, 2, group.Instances[0].Stats().Starts)
+		assert.Equal(t, 2, group.Instances[1].Stats().Starts)
+	})
+}
+
+// TestInstanceLogOutput tests logging command output as records.
+func TestInstanceLogOutput(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "echo out; echo err >&2; printf 0123456789"}),
+		cmdgroup.WithLogOutput(true),
+		cmdgroup.WithMaxLineBytes(4),
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Contains(t, buf.String(), "stream=stdout line=out\n")
+	assert.Contains(t, buf.String(), "stream=stderr line=err\n")
+	assert.Contains(t, buf.String(), "stream=stdout line=0123 truncated=true\n")
+}
+
+// TestWithOutputFlush tests logging output lines together once the command
+// exits.
+func TestWithOutputFlush(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "echo a; echo b"}),
+		cmdgroup.WithLogOutput(true),
+		cmdgroup.WithOutputFlush(time.Hour),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, group.Instances[0].OutputFlush)
+
+	var buf bytes.Buffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Contains(t, buf.String(), `stream=stdout line="a\nb" lines=2`+"\n")
+}
+
+// TestWithOutputFlushShutdown tests logging collected output lines and a
+// partial line of a command killed on shutdown before Run returns.
+func TestWithOutputFlushShutdown(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", `trap "" TERM; echo a; printf partial; while :; do sleep 1; done`}),
+		cmdgroup.WithLogOutput(true),
+		cmdgroup.WithOutputFlush(time.Hour),
+		cmdgroup.WithKillGrace(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	var buf syncBuffer
+	instance := group.Instances[0]
+	instance.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return instance.Stats().Stdout.Bytes == int64(len("a\npartial"))
+	}, 5*time.Second, time.Millisecond)
+	assert.NotContains(t, buf.String(), "stream=stdout")
+
+	cancel()
+	require.ErrorContains(t, <-done, "signal: killed")
+	exits := instance.Stats().Exits
+	require.Len(t, exits, 1)
+	assert.Equal(t, "SIGKILL", exits[0].Signal)
+	assert.Contains(t, buf.String(), `stream=stdout line="a\npartial" lines=2`+"\n")
+}
+
+// TestWithArgv0 tests overriding argv[0] independently of the executed path.
+func TestWithArgv0(t *testing.T) {
+	t.Parallel()
+
+	if _, err := os.Stat("/proc/self/cmdline"); err != nil {
+		t.Skip("no /proc/self/cmdline")
+	}
+
+	// Like a multi-call binary, print the name the shell was invoked as.
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", `tr '\0' '\n' </proc/$$/cmdline | head -n 1`}),
+		cmdgroup.WithArgv0(0, "multicall"),
+		cmdgroup.WithLogOutput(true),
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Contains(t, buf.String(), "stream=stdout line=multicall\n")
+	assert.NotContains(t, buf.String(), "cmd=multicall")
+}
+
+// TestWithIndexEnv tests passing the instance index in the environment.
+func TestWithIndexEnv(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "echo replica:$REPLICA", "--", "--"}),
+		cmdgroup.WithIndexEnv("REPLICA"),
+		cmdgroup.WithLogOutput(true),
+	)
+	require.NoError(t, err)
+	require.Len(t, group.Instances, 2)
+
+	var bufs [2]bytes.Buffer
+	for idx, instance := range group.Instances {
+		instance.Logger = slog.New(slog.NewTextHandler(&bufs[idx], nil))
+	}
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Contains(t, bufs[0].String(), "line=replica:0\n")
+	assert.Contains(t, bufs[1].String(), "line=replica:1\n")
+}
+
+// TestWithEnv tests rendering environment variables for each instance.
+func TestWithEnv(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		env     []string
+		values  []map[string]string
+		want    []string
+		wantErr string
+	}{
+		"static": {
+			env:  []string{"MODE=replica"},
+			want: []string{"REPLICA=2", "MODE=replica"},
+		},
+		"templated": {
+			env:  []string{"DATA_DIR=/data/{{.Index}}", "CMD={{.Name}}"},
+			want: []string{"REPLICA=2", "DATA_DIR=/data/2", "CMD=sh"},
+		},
+		"template values": {
+			env:    []string{"PORT={{.port}}", "DATA_DIR=/data/{{.Index}}"},
+			values: []map[string]string{{"port": "80"}, {"port": "443"}, {"port": "8080", "Index": "ignored"}},
+			want:   []string{"REPLICA=2", "PORT=8080", "DATA_DIR=/data/2"},
+		},
+		"missing equals": {
+			env:     []string{"DATA_DIR"},
+			wantErr: `parse env "DATA_DIR": missing '='`,
+		},
+		"invalid template": {
+			env:     []string{"DATA_DIR=/data/{{.Index"},
+			wantErr: `parse env "DATA_DIR=/data/{{.Index"`,
+		},
+		"unknown field": {
+			env:     []string{"PORT={{.Port}}"},
+			wantErr: "instance 0: render env",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			options := []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"-c", "echo $DATA_DIR", "--", "--", "--"}),
+				cmdgroup.WithIndexEnv("REPLICA"),
+				cmdgroup.WithEnv(tt.env),
+			}
+			if tt.values != nil {
+				// One instance per value set instead.
+				options = append(options,
+					cmdgroup.WithArgs([]string{"-c", "echo $DATA_DIR"}),
+					cmdgroup.WithTemplateValues(tt.values),
+				)
+			}
+			group, err := cmdgroup.New("sh", options...)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, group.Instances, 3)
+			assert.Equal(t, tt.want, group.Instances[2].Env)
+		})
+	}
+}
+
+// TestWithLabels tests naming instances for logs, watch specifications and
+// the summary.
+func TestWithLabels(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("echo",
+		cmdgroup.WithArgs([]string{"--", "a", "--", "b", "--", "c"}),
+		cmdgroup.WithLabels([]string{"web", "", "worker"}),
+		cmdgroup.WithWatch("worker,1"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "web", group.Instances[0].Label)
+	assert.Empty(t, group.Instances[1].Label)
+	assert.Equal(t, []int{1, 2}, group.WatchedIndices())
+
+	require.NoError(t, group.SetWatch("web"))
+	assert.Equal(t, []int{0}, group.WatchedIndices())
+	require.ErrorContains(t, group.SetWatch("db"), `not an index or instance label: "db"`)
+	require.NoError(t, group.SetWatch("none"))
+
+	var bufs [3]bytes.Buffer
+	for idx, instance := range group.Instances {
+		instance.Logger = slog.New(slog.NewTextHandler(&bufs[idx], nil))
+	}
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Contains(t, bufs[0].String(), "msg=started instance=web ")
+	assert.NotContains(t, bufs[1].String(), "instance=")
+	assert.Contains(t, bufs[2].String(), "msg=started instance=worker ")
+
+	summary := group.Summary()
+	assert.Equal(t, "web", summary.Instances[0].Label)
+	assert.Empty(t, summary.Instances[1].Label)
+	assert.Equal(t, "worker", summary.Instances[2].Label)
+}
+
+// TestWithLabelsInvalid tests rejecting labels that do not identify instances
+// unambiguously.
+func TestWithLabelsInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		labels  []string
+		wantErr string
+	}{
+		"duplicate": {
+			labels:  []string{"web", "web"},
+			wantErr: `labels: duplicate label: "web"`,
+		},
+		"integer": {
+			labels:  []string{"web", "1"},
+			wantErr: `labels: invalid label: "1"`,
+		},
+		"keyword": {
+			labels:  []string{"all"},
+			wantErr: `labels: invalid label: "all"`,
+		},
+		"comma": {
+			labels:  []string{"a,b"},
+			wantErr: `labels: invalid label: "a,b"`,
+		},
+		"too many": {
+			labels:  []string{"a", "b", "c"},
+			wantErr: "labels: 3 labels for 2 instances",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cmdgroup.New("echo",
+				cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+				cmdgroup.WithLabels(tt.labels),
+			)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestWithStartTemplates tests rendering the arguments and environment
+// variables at every start with the attempt number.
+func TestWithStartTemplates(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", `echo "$1 $RUN"`, "sh", `{{.n}}-{{"{{"}}{{.Attempt}}`}),
+		cmdgroup.WithTemplateValues([]map[string]string{{"n": "{{x}}"}}),
+		cmdgroup.WithEnv([]string{"RUN={{.Index}}-{{.Attempt}}"}),
+		cmdgroup.WithStartTemplates(true),
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithRestartPolicyObject(cmdgroup.RestartPolicy{InitialDelay: 10 * time.Millisecond}),
+		cmdgroup.WithCaptureOutput(0),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	events := group.Events()
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	var outputs []string
+	for e := range events {
+		if e.Type != cmdgroup.EventExited {
+			continue
+		}
+		outputs = append(outputs, string(group.Output(0)))
+		if len(outputs) == 3 {
+			cancel()
+		}
+	}
+	require.NoError(t, <-done)
+
+	// Templates are rendered once per start, so rendered text is kept as is.
+	assert.Equal(t, []string{"{{x}}-{{0 0-0\n", "{{x}}-{{1 0-1\n", "{{x}}-{{2 0-2\n"}, outputs[:3])
+	assert.Equal(t, []string{"-c", `echo "$1 $RUN"`, "sh", "{{x}}-{{0"}, group.Instances[0].Args)
+
+	_, err = cmdgroup.New("echo",
+		cmdgroup.WithArgs([]string{"{{.Attempt}}", "{{.Port}}"}),
+		cmdgroup.WithStartTemplates(true),
+	)
+	require.ErrorContains(t, err, `instance 0: render start template: execute arg "{{.Port}}"`)
+}
+
+// TestWithArgsProvider tests computing arguments before each start.
+func TestWithArgsProvider(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("echo",
+		cmdgroup.WithArgs([]string{"static"}),
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithArgsProvider(0, func(attempt int) []string {
+			if attempt == 1 {
+				return nil
+			}
+
+			return []string{"shard-" + strconv.Itoa(attempt)}
+		}),
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	time.AfterFunc(2500*time.Millisecond, cancel)
+	require.NoError(t, group.Run(ctx))
+
+	echoPath := group.Instances[0].Name
+	assert.Contains(t, buf.String(), "msg=started cmd=\""+echoPath+" shard-0\"")
+	assert.Contains(t, buf.String(), "msg=started cmd=\""+echoPath+" static\"")
+	assert.Contains(t, buf.String(), "msg=started cmd=\""+echoPath+" shard-2\"")
+}
+
+// TestStopSignal tests stopping instances with different signals.
+func TestStopSignal(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"--", "60", "--", "60", "--", "60"}),
+		cmdgroup.WithStopSignal(syscall.SIGINT),
+		cmdgroup.WithInstanceStopSignal(1, syscall.SIGHUP),
+		cmdgroup.WithInstanceStopSignal(2, syscall.SIGTERM),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, syscall.SIGINT, group.Instances[0].StopSignal)
+	assert.Equal(t, syscall.SIGHUP, group.Instances[1].StopSignal)
+	assert.Equal(t, syscall.SIGTERM, group.Instances[2].StopSignal)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == len(group.Instances)
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	for _, instance := range group.Summary().Instances {
+		assert.Equal(t, "stopped", instance.Status, instance.Index)
+	}
+}
+
+// syncBuffer is a [bytes.Buffer] safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// TestKillSignal tests escalating to a custom kill signal.
+func TestKillSignal(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "trap '' TERM; while :; do sleep 0.1; done"}),
+		cmdgroup.WithKillGrace(200*time.Millisecond),
+		cmdgroup.WithKillSignal(syscall.SIGUSR1),
+	)
+	require.NoError(t, err)
+
+	var buf syncBuffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	start := time.Now()
+	require.Error(t, group.Run(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Contains(t, buf.String(), "msg=killing")
+	assert.Contains(t, buf.String(), "signal=SIGUSR1")
+}
+
+// TestWithSetsid tests starting commands in a new session.
+func TestWithSetsid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc/self/stat")
+	}
+
+	tests := map[string]struct {
+		setsid         bool
+		wantOwnSession bool
+	}{
+		"process group only": {setsid: false, wantOwnSession: false},
+		"new session":        {setsid: true, wantOwnSession: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Print the process ID and session ID of the shell.
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs([]string{"-c", `echo "$$:$(cut -d ' ' -f 6 /proc/$$/stat)"`}),
+				cmdgroup.WithSetsid(tt.setsid),
+				cmdgroup.WithLogOutput(true),
+			)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+			require.NoError(t, group.Run(t.Context()))
+
+			match := regexp.MustCompile(`line=(\d+):(\d+)`).FindStringSubmatch(buf.String())
+			require.Len(t, match, 3, buf.String())
+			assert.Equal(t, tt.wantOwnSession, match[1] == match[2])
+		})
+	}
+}
+
+// TestGroupReload tests restarting all instances with each reload strategy.
+func TestGroupReload(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		strategy string
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		"parallel": {strategy: "parallel", wantErr: assert.NoError},
+		"rolling":  {strategy: "rolling", wantErr: assert.NoError},
+		"invalid":  {strategy: "random", wantErr: assert.Error},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var instances []*cmdgroup.Instance
+			for range 3 {
+				instances = append(instances, &cmdgroup.Instance{
+					Name:           "fake",
+					Watch:          true,
+					CommandFactory: fakeFactory(fakeCmd{delay: time.Hour}),
+				})
+			}
+			group := &cmdgroup.Group{Instances: instances, ReloadStrategy: tt.strategy}
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+
+			done := make(chan error, 1)
+			go func() { done <- group.Run(ctx) }()
+
+			require.Eventually(t, func() bool {
+				return group.Running() == len(instances)
+			}, 5*time.Second, 10*time.Millisecond)
+
+			err := group.Reload(ctx)
+			tt.wantErr(t, err)
+			if err == nil {
+				if tt.strategy == "rolling" {
+					// A rolling reload returns once all instances restarted.
+					for _, instance := range instances {
+						assert.Equal(t, 2, instance.Stats().Starts)
+					}
+				}
+				require.Eventually(t, func() bool {
+					for _, instance := range instances {
+						if instance.Stats().Starts != 2 || !instance.Running() {
+							return false
+						}
+					}
+
+					return true
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+
+			cancel()
+			require.NoError(t, <-done)
+		})
+	}
+}
+
+// TestGroupReloadRollingNotRestarted tests that a rolling reload moves on
+// from an instance that is no longer run instead of waiting for it to start.
+func TestGroupReloadRollingNotRestarted(t *testing.T) {
+	t.Parallel()
+
+	var starts atomic.Int64
+	first := &cmdgroup.Instance{
+		Name:           "fake",
+		Watch:          true,
+		CommandFactory: fakeFactory(fakeCmd{delay: time.Hour}),
+		PreStart: func(context.Context) error {
+			if starts.Add(1) > 1 {
+				return errors.New("not again")
+			}
+
+			return nil
+		},
+		RestartPolicy: cmdgroup.RestartPolicy{MaxAttempts: 1},
+	}
+	second := &cmdgroup.Instance{
+		Name:           "fake",
+		Watch:          true,
+		CommandFactory: fakeFactory(fakeCmd{delay: time.Hour}),
+	}
+	group := &cmdgroup.Group{Instances: []*cmdgroup.Instance{first, second}, ReloadStrategy: "rolling"}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	reloadCtx, reloadCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer reloadCancel()
+	require.NoError(t, group.Reload(reloadCtx))
+	assert.Equal(t, 1, first.Stats().Starts)
+
+	cancel()
+	<-done
+}
+
+// TestInstanceStatsExits tests keeping a bounded history of exits.
+func TestInstanceStatsExits(t *testing.T) {
+	t.Parallel()
+
+	t.Run("signal", func(t *testing.T) {
+		t.Parallel()
+
+		shPath, err := exec.LookPath("sh")
+		require.NoError(t, err)
+
+		instance := &cmdgroup.Instance{Name: shPath, Args: []string{"-c", "kill -KILL $$"}}
+		require.Error(t, instance.Run(t.Context()))
+
+		exits := instance.Stats().Exits
+		require.Len(t, exits, 1)
+		assert.Equal(t, -1, exits[0].ExitCode)
+		assert.Equal(t, "SIGKILL", exits[0].Signal)
+		assert.False(t, exits[0].Time.IsZero())
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		t.Parallel()
+
+		instance := &cmdgroup.Instance{
+			Name:           "fake",
+			Watch:          true,
+			CommandFactory: fakeFactory(fakeCmd{delay: time.Hour}),
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		t.Cleanup(cancel)
+		done := make(chan error, 1)
+		go func() { done <- instance.Run(ctx) }()
+
+		// Restarts happen without delay.
+		for starts := 1; starts <= 15; starts++ {
+			require.Eventually(t, func() bool {
+				return instance.Stats().Starts == starts && instance.Running()
+			}, 5*time.Second, time.Millisecond)
+			instance.Restart()
+		}
+		cancel()
+		<-done
+
+		assert.Len(t, instance.Stats().Exits, 10)
+	})
+}
+
+// TestWithCleanEnv tests not inheriting the environment.
+func TestWithCleanEnv(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		options   []cmdgroup.Option
+		wantLines []string
+	}{
+		"empty": {
+			wantLines: nil,
+		},
+		"index env": {
+			options:   []cmdgroup.Option{cmdgroup.WithIndexEnv("REPLICA")},
+			wantLines: []string{"REPLICA=0"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("env", append(tt.options,
+				cmdgroup.WithCleanEnv(true),
+				cmdgroup.WithLogOutput(true),
+			)...)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			group.Instances[0].Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+			require.NoError(t, group.Run(t.Context()))
+
+			var lines []string
+			for record := range strings.Lines(buf.String()) {
+				var output struct{ Msg, Line string }
+				require.NoError(t, json.Unmarshal([]byte(record), &output))
+				if output.Msg == "output" {
+					lines = append(lines, output.Line)
+				}
+			}
+			assert.Equal(t, tt.wantLines, lines)
+		})
+	}
+}
+
+// TestWithOnReady tests notifying once all instances have started.
+func TestWithOnReady(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	group, err := cmdgroup.New("fake",
+		cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithCommandFactory(func(ctx context.Context, instance *cmdgroup.Instance) cmdgroup.Cmd {
+			delay := time.Hour
+			if instance.Args[0] == "a" {
+				// Keeps restarting, so it starts repeatedly.
+				delay = 0
+			}
+
+			return fakeFactory(fakeCmd{delay: delay})(ctx, instance)
+		}),
+		cmdgroup.WithOnReady(func() { calls.Add(1) }),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	time.AfterFunc(1500*time.Millisecond, cancel)
+	require.NoError(t, group.Run(ctx))
+
+	assert.GreaterOrEqual(t, group.Instances[0].Stats().Starts, 2)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+// TestInstanceUptime tests the uptime of the running process.
+func TestInstanceUptime(t *testing.T) {
+	t.Parallel()
+
+	instance := &cmdgroup.Instance{
+		Name:           "fake",
+		Watch:          true,
+		CommandFactory: fakeFactory(fakeCmd{delay: time.Hour}),
+	}
+	assert.Zero(t, instance.Uptime())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- instance.Run(ctx) }()
+
+	require.Eventually(t, instance.Running, 5*time.Second, time.Millisecond)
+	first := instance.Uptime()
+	require.Eventually(t, func() bool {
+		return instance.Uptime() >= first+50*time.Millisecond
+	}, 5*time.Second, 10*time.Millisecond)
+
+	instance.Restart()
+	require.Eventually(t, func() bool {
+		return instance.Stats().Starts == 2 && instance.Running()
+	}, 5*time.Second, time.Millisecond)
+	assert.Less(t, instance.Uptime(), 50*time.Millisecond)
+	assert.True(t, instance.Stats().StartedAt.After(instance.Stats().FirstStart))
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	assert.Zero(t, instance.Uptime())
+}
+
+// TestWithForceKill tests stopping an unresponsive command without a grace
+// period.
+func TestWithForceKill(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "trap '' TERM; while :; do sleep 0.1; done"}),
+		cmdgroup.WithForceKill(0),
+	)
+	require.NoError(t, err)
+	assert.True(t, group.Instances[0].ForceKill)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, group.Instances[0].Running, 5*time.Second, time.Millisecond)
+	start := time.Now()
+	cancel()
+	require.NoError(t, <-done)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "SIGKILL", group.Instances[0].Stats().Exits[0].Signal)
+}
+
+// TestWithArgValidator tests rejecting disallowed arguments.
+func TestWithArgValidator(t *testing.T) {
+	t.Parallel()
+
+	errDisallowed := errors.New("disallowed")
+	validator := func(_ int, args []string) error {
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "/etc/") {
+				return fmt.Errorf("%w: %s", errDisallowed, arg)
+			}
+		}
+
+		return nil
+	}
+
+	tests := map[string]struct {
+		args      []string
+		provider  func(attempt int) []string
+		wantNewIs error
+		wantRunIs error
+	}{
+		"allowed": {
+			args: []string{"--", "a", "--", "b"},
+		},
+		"static args rejected": {
+			args:      []string{"--", "a", "--", "/etc/shadow"},
+			wantNewIs: errDisallowed,
+		},
+		"dynamic args rejected": {
+			args:      []string{"a"},
+			provider:  func(int) []string { return []string{"/etc/shadow"} },
+			wantRunIs: errDisallowed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			options := []cmdgroup.Option{
+				cmdgroup.WithArgs(tt.args),
+				cmdgroup.WithArgValidator(validator),
+			}
+			if tt.provider != nil {
+				options = append(options, cmdgroup.WithArgsProvider(0, tt.provider))
+			}
+
+			group, err := cmdgroup.New("echo", options...)
+			if tt.wantNewIs != nil {
+				require.ErrorIs(t, err, tt.wantNewIs)
+				return
+			}
+			require.NoError(t, err)
+
+			err = group.Run(t.Context())
+			if tt.wantRunIs != nil {
+				require.ErrorIs(t, err, tt.wantRunIs)
+				assert.Zero(t, group.Instances[0].Stats().Starts)
+
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestInstanceString tests rendering the command line of an instance.
+func TestInstanceString(t *testing.T) {
+	t.Parallel()
+
+	echoPath, err := exec.LookPath("echo")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		instance *cmdgroup.Instance
+		want     string
+	}{
+		"no args": {
+			instance: &cmdgroup.Instance{Name: echoPath},
+			want:     exec.CommandContext(t.Context(), echoPath).String(),
+		},
+		"args": {
+			instance: &cmdgroup.Instance{Name: echoPath, Args: []string{"-n", "a b", ""}},
+			want:     exec.CommandContext(t.Context(), echoPath, "-n", "a b", "").String(),
+		},
+		"builtin": {
+			instance: &cmdgroup.Instance{Name: "/nonexistent/true", Args: []string{"a"}, Builtin: func(context.Context, []string) error { return nil }},
+			want:     "builtin /nonexistent/true a",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.instance.String())
+		})
+	}
+}
+
+// TestNewAllErrors tests reporting all configuration problems at once.
+func TestNewAllErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := cmdgroup.New("/nonexistent/binary",
+		cmdgroup.WithArgs([]string{"--port={{.port}}"}),
+		cmdgroup.WithTemplateValues([]map[string]string{{"name": "http"}}),
+		cmdgroup.WithWatch("5"),
+	)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "look path")
+	assert.ErrorContains(t, err, "expand template")
+	assert.ErrorContains(t, err, "parse watch: index out of range: 5")
+	assert.Len(t, strings.Split(err.Error(), "\n"), 3)
+}
+
+// TestNewIndexOutOfRange tests explaining how many instances were generated,
+// and from what, when an index exceeds them.
+func TestNewIndexOutOfRange(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		options []cmdgroup.Option
+		wantErr string
+	}{
+		"static": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+				cmdgroup.WithWatch("2"),
+			},
+			wantErr: "parse watch: index out of range: 2 (2 instances generated from 2 argument sections)",
+		},
+		"single": {
+			options: []cmdgroup.Option{cmdgroup.WithStopGroupOnExit("1")},
+			wantErr: "parse stop group on exit: index out of range: 1 (1 instance generated from 1 argument section)",
+		},
+		"templates": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "{{.port}}"}),
+				cmdgroup.WithTemplateValues([]map[string]string{{"port": "80"}, {"port": "443"}}),
+				cmdgroup.WithWatch("0,3"),
+			},
+			wantErr: "parse watch: index out of range: 3 (2 instances generated from " +
+				"1 argument section and 2 template value sets)",
+		},
+		"globs": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "/nonexistent/*"}),
+				cmdgroup.WithGlobExpand("per-instance", false),
+				cmdgroup.WithWatch("1"),
+			},
+			wantErr: "parse watch: index out of range: 1 (1 instance generated from " +
+				"1 argument section with per-instance glob expansion)",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cmdgroup.New("echo", tt.options...)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestNewMulti tests creating a group of instances running different commands.
+func TestNewMulti(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		commands [][]string
+		options  []cmdgroup.Option
+		wantErr  string
+	}{
+		"mixed": {
+			commands: [][]string{{"echo", "hello", "world"}, {"true"}},
+			options:  []cmdgroup.Option{cmdgroup.WithCaptureOutput(0)},
+		},
+		"empty command": {
+			commands: [][]string{{"echo"}, {}},
+			wantErr:  "command 1: no command specified",
+		},
+		"missing command": {
+			commands: [][]string{{"echo"}, {"nonexistent-command-xyz"}},
+			wantErr:  "look path",
+		},
+		"watch out of range": {
+			commands: [][]string{{"echo"}, {"true"}},
+			options:  []cmdgroup.Option{cmdgroup.WithWatch("2")},
+			wantErr:  "parse watch: index out of range: 2 (2 instances generated from 2 commands)",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.NewMulti(tt.commands, tt.options...)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, group.Instances, 2)
+
+			echoPath, err := exec.LookPath("echo")
+			require.NoError(t, err)
+			truePath, err := exec.LookPath("true")
+			require.NoError(t, err)
+			assert.Equal(t, echoPath, group.Instances[0].Name)
+			assert.Equal(t, []string{"hello", "world"}, group.Instances[0].Args)
+			assert.Equal(t, truePath, group.Instances[1].Name)
+			assert.Empty(t, group.Instances[1].Args)
+
+			require.NoError(t, group.Run(t.Context()))
+			assert.Equal(t, []byte("hello world\n"), group.Output(0))
+		})
+	}
+}
+
+// TestWithGroupRestartLimit tests limiting restarts across instances.
+func TestWithGroupRestartLimit(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("fake",
+		cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithCommandFactory(fakeFactory(fakeCmd{exitErr: errors.New("crash")})),
+		cmdgroup.WithGroupRestartLimit(2, time.Minute),
+	)
+	require.NoError(t, err)
+
+	var buf syncBuffer
+	group.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	// Without the limit, each instance would restart twice.
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	time.AfterFunc(2500*time.Millisecond, cancel)
+	require.NoError(t, group.Run(ctx))
+
+	starts := group.Instances[0].Stats().Starts + group.Instances[1].Stats().Starts
+	assert.Equal(t, 4, starts)
+	assert.Contains(t, buf.String(), "level=WARN msg=\"group restart limit reached, pausing restarts\" limit=2")
+}
+
+// TestGroupWatchedIndices tests reporting the effective watch set.
+func TestGroupWatchedIndices(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		options []cmdgroup.Option
+		want    []int
+	}{
+		"none": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch("none")},
+			want:    nil,
+		},
+		"all": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch("all")},
+			want:    []int{0, 1, 2},
+		},
+		"indexes": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch("2,0")},
+			want:    []int{0, 2},
+		},
+		"duplicate indexes with spaces": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch(" 1, 1 ,")},
+			want:    []int{1},
+		},
+		"oneshot excluded": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch("all"), cmdgroup.WithOneshot(1, false)},
+			want:    []int{0, 2},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			options := append([]cmdgroup.Option{cmdgroup.WithArgs([]string{"--", "a", "--", "b", "--", "c"})}, tt.options...)
+			group, err := cmdgroup.New("echo", options...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, group.WatchedIndices())
+		})
+	}
+}
+
+// TestWithWatchFunc tests selecting the watched instances by a predicate, and
+// the last of it and WithWatch applying.
+func TestWithWatchFunc(t *testing.T) {
+	t.Parallel()
+
+	hasArg := func(arg string) func(i *cmdgroup.Instance) bool {
+		return func(i *cmdgroup.Instance) bool {
+			return slices.Contains(i.Args, arg)
+		}
+	}
+
+	tests := map[string]struct {
+		options []cmdgroup.Option
+		want    []int
+		wantErr string
+	}{
+		"predicate": {
+			options: []cmdgroup.Option{cmdgroup.WithWatchFunc(hasArg("-serve"))},
+			want:    []int{0, 2},
+		},
+		"none selected": {
+			options: []cmdgroup.Option{cmdgroup.WithWatchFunc(hasArg("-missing"))},
+		},
+		"replaces watch": {
+			options: []cmdgroup.Option{cmdgroup.WithWatch("all"), cmdgroup.WithWatchFunc(hasArg("-serve"))},
+			want:    []int{0, 2},
+		},
+		"replaced by watch": {
+			options: []cmdgroup.Option{cmdgroup.WithWatchFunc(hasArg("-serve")), cmdgroup.WithWatch("1")},
+			want:    []int{1},
+		},
+		"labels": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithWatchFunc(func(i *cmdgroup.Instance) bool { return i.Label != "" }),
+				cmdgroup.WithLabels([]string{"", "migrate"}),
+			},
+			want: []int{1},
+		},
+		"selects oneshot": {
+			options: []cmdgroup.Option{cmdgroup.WithOneshot(2, false), cmdgroup.WithWatchFunc(hasArg("-serve"))},
+			wantErr: "instance 2: watched and oneshot",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			args := []string{"--", "-serve", "a", "--", "-migrate", "--", "-serve", "b"}
+			group, err := cmdgroup.New("echo", append([]cmdgroup.Option{cmdgroup.WithArgs(args)}, tt.options...)...)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, group.WatchedIndices())
+		})
+	}
+}
+
+// TestNewOneshotWatch tests rejecting one-shot instances selected to be
+// watched, when creating a group and when changing its watch set.
+func TestNewOneshotWatch(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		watch    string
+		setWatch string
+		wantErr  string
+	}{
+		"watched by index": {
+			watch:   "0,1",
+			wantErr: "instance 1: watched and oneshot",
+		},
+		"watched by label": {
+			watch:   "init",
+			wantErr: "instance 1: watched and oneshot",
+		},
+		"watch all": {
+			watch: "all",
+		},
+		"unwatched": {
+			watch: "0",
+		},
+		"set watch by index": {
+			watch:    "0",
+			setWatch: "1",
+			wantErr:  "set watch: instance 1: watched and oneshot",
+		},
+		"set watch all": {
+			watch:    "none",
+			setWatch: "all",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("echo",
+				cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+				cmdgroup.WithLabels([]string{"app", "init"}),
+				cmdgroup.WithOneshot(1, false),
+				cmdgroup.WithWatch(tt.watch),
+			)
+			if tt.setWatch == "" && tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.setWatch == "" {
+				return
+			}
+
+			before := group.WatchedIndices()
+			err = group.SetWatch(tt.setWatch)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				assert.Equal(t, before, group.WatchedIndices())
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, []int{0}, group.WatchedIndices())
+		})
+	}
+}
+
+// TestWithInitialDelay tests delaying the start of all instances.
+func TestWithInitialDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		stopAfter  time.Duration
+		wantStarts int
+	}{
+		"started after delay":  {stopAfter: 500 * time.Millisecond, wantStarts: 1},
+		"stopped during delay": {stopAfter: 100 * time.Millisecond, wantStarts: 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("fake",
+				cmdgroup.WithCommandFactory(fakeFactory(fakeCmd{delay: time.Hour})),
+				cmdgroup.WithInitialDelay(300*time.Millisecond),
+			)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			time.AfterFunc(tt.stopAfter, cancel)
+
+			done := make(chan error, 1)
+			go func() { done <- group.Run(ctx) }()
+
+			time.Sleep(200 * time.Millisecond)
+			assert.Zero(t, group.Instances[0].Stats().Starts)
+
+			require.NoError(t, <-done)
+			assert.Equal(t, tt.wantStarts, group.Instances[0].Stats().Starts)
+		})
+	}
+}
+
+// TestWithProcessGroup tests running commands in the process group of the
+// caller.
+func TestWithProcessGroup(t *testing.T) {
+	t.Parallel()
+
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc/self/stat")
+	}
+
+	tests := map[string]struct {
+		processGroup bool
+		wantShared   bool
+	}{
+		"own process group":    {processGroup: true, wantShared: false},
+		"shared process group": {processGroup: false, wantShared: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Print the process group ID of the shell.
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs([]string{"-c", `echo "pgid:$(cut -d ' ' -f 5 /proc/$$/stat)"`}),
+				cmdgroup.WithProcessGroup(tt.processGroup),
+				cmdgroup.WithLogOutput(true),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantShared, group.Instances[0].SharedProcessGroup)
+
+			var buf bytes.Buffer
+			group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+			require.NoError(t, group.Run(t.Context()))
+
+			wantLine := "line=pgid:" + strconv.Itoa(syscall.Getpgrp()) + "\n"
+			if tt.wantShared {
+				assert.Contains(t, buf.String(), wantLine)
+			} else {
+				assert.NotContains(t, buf.String(), wantLine)
+			}
+		})
+	}
+}
+
+// TestWithProcessGroupStop tests stopping a command in a shared process
+// group.
+func TestWithProcessGroupStop(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"60"}),
+		cmdgroup.WithProcessGroup(false),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, group.Instances[0].Running, 5*time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, "SIGTERM", group.Instances[0].Stats().Exits[0].Signal)
+}
+
+// TestWithCaptureOutput tests capturing the output of instances.
+func TestWithCaptureOutput(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("echo",
+		cmdgroup.WithArgs([]string{"--", "a", "--", "b"}),
+		cmdgroup.WithCaptureOutput(0),
+	)
+	require.NoError(t, err)
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Equal(t, []byte("a\n"), group.Output(0))
+	assert.Nil(t, group.Output(1))
+	assert.Nil(t, group.Output(2))
+}
+
+// TestWithQuiet tests discarding the output of all instances while still
+// logging their lifecycle.
+func TestWithQuiet(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "echo out; echo err >&2", "--", "--"}),
+		cmdgroup.WithQuiet(true),
+		cmdgroup.WithLogOutput(true),
+	)
+	require.NoError(t, err)
+
+	var bufs [2]bytes.Buffer
+	for idx, instance := range group.Instances {
+		assert.True(t, instance.DiscardOutput)
+		instance.Logger = slog.New(slog.NewTextHandler(&bufs[idx], nil))
+	}
+	require.NoError(t, group.Run(t.Context()))
+
+	for idx := range bufs {
+		assert.Contains(t, bufs[idx].String(), "msg=started")
+		assert.Contains(t, bufs[idx].String(), "msg=exited")
+		assert.NotContains(t, bufs[idx].String(), "line=")
+		assert.Zero(t, group.Instances[idx].Stats().Stdout)
+		assert.Zero(t, group.Instances[idx].Stats().Stderr)
+	}
+}
+
+// TestWithDiscardOutput tests connecting the output of an instance to the null
+// device instead of the console, unless it is captured.
+func TestWithDiscardOutput(t *testing.T) {
+	t.Parallel()
+
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("/proc not available")
+	}
+
+	tests := map[string]struct {
+		capture    bool
+		wantStdout string
+		wantOutput []byte
+	}{
+		"discard": {
+			wantStdout: "/dev/null",
+			wantOutput: nil,
+		},
+		"discard with capture": {
+			capture:    true,
+			wantStdout: "pipe:",
+			wantOutput: []byte("done\n"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fds := filepath.Join(t.TempDir(), "fds")
+			options := []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{
+					"-c", `fds=$(readlink /proc/$$/fd/1 /proc/$$/fd/2); echo "$fds" >"$0"; echo done`, fds,
+				}),
+				cmdgroup.WithLogOutput(true),
+				cmdgroup.WithDiscardOutput(0),
+			}
+			if tt.capture {
+				options = append(options, cmdgroup.WithCaptureOutput(0))
+			}
+			group, err := cmdgroup.New("sh", options...)
+			require.NoError(t, err)
+			assert.True(t, group.Instances[0].DiscardOutput)
+
+			var buf bytes.Buffer
+			group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+			require.NoError(t, group.Run(t.Context()))
+
+			got, err := os.ReadFile(fds)
+			require.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+			require.Len(t, lines, 2)
+			assert.Contains(t, lines[0], tt.wantStdout)
+			assert.Equal(t, "/dev/null", lines[1])
+			assert.NotContains(t, buf.String(), "msg=output")
+			assert.Equal(t, tt.wantOutput, group.Output(0))
+		})
+	}
+}
+
+// TestWithMaxOutputBytes tests dropping output beyond the limit of each run.
+func TestWithMaxOutputBytes(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "printf abc; printf def; printf ghi"}),
+		cmdgroup.WithMaxOutputBytes(4),
+		cmdgroup.WithCaptureOutput(0),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), group.Instances[0].MaxOutputBytes)
+
+	var buf syncBuffer
+	group.Instances[0].Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	require.NoError(t, group.Run(t.Context()))
+
+	assert.Equal(t, []byte("abcd"), group.Output(0))
+	assert.Equal(t, 1, strings.Count(buf.String(), `msg="output truncated"`))
+	assert.Contains(t, buf.String(), "max_bytes=4")
+}
+
+// TestOutputStats tests counting the bytes and lines of output on each
+// stream.
+func TestOutputStats(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		options    []cmdgroup.Option
+		wantStdout cmdgroup.OutputStats
+		wantStderr cmdgroup.OutputStats
+	}{
+		"logged": {
+			options:    []cmdgroup.Option{cmdgroup.WithLogOutput(true)},
+			wantStdout: cmdgroup.OutputStats{Bytes: 13, Lines: 2},
+			wantStderr: cmdgroup.OutputStats{Bytes: 4, Lines: 1},
+		},
+		"captured": {
+			options:    []cmdgroup.Option{cmdgroup.WithCaptureOutput(0)},
+			wantStdout: cmdgroup.OutputStats{Bytes: 13, Lines: 2},
+		},
+		"limited": {
+			options:    []cmdgroup.Option{cmdgroup.WithCaptureOutput(0), cmdgroup.WithMaxOutputBytes(4)},
+			wantStdout: cmdgroup.OutputStats{Bytes: 13, Lines: 2},
+			wantStderr: cmdgroup.OutputStats{Bytes: 4, Lines: 1},
+		},
+		"discarded": {
+			options: []cmdgroup.Option{cmdgroup.WithLogOutput(true), cmdgroup.WithDiscardOutput(0)},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("sh", append(tt.options,
+				cmdgroup.WithArgs([]string{"-c", `printf 'one\ntwo\nthree'; printf 'err\n' >&2`}),
+				cmdgroup.WithLogger(slog.New(slog.DiscardHandler)),
+			)...)
+			require.NoError(t, err)
+			require.NoError(t, group.Run(t.Context()))
+
+			stats := group.Instances[0].Stats()
+			assert.Equal(t, tt.wantStdout, stats.Stdout)
+			assert.Equal(t, tt.wantStderr, stats.Stderr)
+		})
+	}
+}
+
+// TestWithCaptureOutputWatched tests keeping the output of the most recent
+// process of a watched instance.
+func TestWithCaptureOutputWatched(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("echo",
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithCaptureOutput(0),
+		cmdgroup.WithArgsProvider(0, func(attempt int) []string {
+			return []string{"run", strconv.Itoa(attempt)}
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	time.AfterFunc(1500*time.Millisecond, cancel)
+	require.NoError(t, group.Run(ctx))
+
+	assert.Equal(t, []byte("run 1\n"), group.Output(0))
+}
+
+// TestWithShutdownOrder tests stopping instances one at a time in order, each
+// within its own time to exit.
+func TestWithShutdownOrder(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{
+			"-c",
+			"--", "exec sleep 60",
+			"--", "trap 'sleep 0.3; exit 0' TERM; while :; do sleep 0.1; done",
+			"--", "exec sleep 60",
+		}),
+		cmdgroup.WithShutdownOrder([]int{1, 0}, 0),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 0}, group.ShutdownOrder)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == len(group.Instances)
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	exited := func(idx int) time.Time {
+		exits := group.Instances[idx].Stats().Exits
+		require.Len(t, exits, 1)
+
+		return exits[0].Time
+	}
+	assert.True(t, exited(1).Before(exited(0)))
+	assert.False(t, exited(2).Before(exited(0)))
+}
+
+// TestWithShutdownOrderTimeout tests killing instances still running once the
+// shutdown timeout has passed.
+func TestWithShutdownOrderTimeout(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{
+			"-c",
+			"--", "trap '' TERM; while :; do sleep 0.1; done",
+			"--", "exec sleep 60",
+		}),
+		cmdgroup.WithShutdownOrder([]int{0, 1}, 300*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Running() == len(group.Instances)
+	}, 5*time.Second, 10*time.Millisecond)
+	start := time.Now()
+	cancel()
+	require.Error(t, <-done)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, "SIGKILL", group.Instances[0].Stats().Exits[0].Signal)
+	assert.False(t, group.Instances[1].Running())
+}
+
+// TestWithShutdownOrderInvalid tests rejecting shutdown orders that do not
+// refer to each instance at most once.
+func TestWithShutdownOrderInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		order   []int
+		wantErr string
+	}{
+		"out of range": {
+			order:   []int{0, 2},
+			wantErr: "shutdown order: index out of range: 2",
+		},
+		"duplicate": {
+			order:   []int{1, 0, 1},
+			wantErr: "shutdown order: duplicate index: 1",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cmdgroup.New("sleep",
+				cmdgroup.WithArgs([]string{"--", "1", "--", "2"}),
+				cmdgroup.WithShutdownOrder(tt.order, 0),
+			)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestWithStrictExit tests counting a termination by the stop signal as
+// failed unless it was sent on shutdown.
+func TestWithStrictExit(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		args    []string
+		strict  bool
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"lenient sigterm": {
+			args:    []string{"-c", "kill -TERM $$"},
+			wantErr: assert.NoError,
+		},
+		"strict sigterm": {
+			args:    []string{"-c", "kill -TERM $$"},
+			strict:  true,
+			wantErr: assert.Error,
+		},
+		"strict shutdown": {
+			args:    []string{"-c", "exec sleep 60"},
+			strict:  true,
+			wantErr: assert.NoError,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs(tt.args),
+				cmdgroup.WithStrictExit(tt.strict),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, tt.strict, group.Instances[0].StrictExit)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			time.AfterFunc(300*time.Millisecond, cancel)
+
+			tt.wantErr(t, group.Run(ctx))
+			assert.Equal(t, "SIGTERM", group.Instances[0].Stats().Exits[0].Signal)
+		})
+	}
+}
+
+// TestGroupPIDs tests reporting the process IDs of running instances.
+func TestGroupPIDs(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep", cmdgroup.WithArgs([]string{"--", "60", "--", "0"}))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int{0: 0, 1: 0}, group.PIDs())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return group.Instances[0].Running() && group.Instances[1].Stats().Starts == 1 &&
+			!group.Instances[1].Running()
+	}, 5*time.Second, time.Millisecond)
+	pids := group.PIDs()
+	assert.Positive(t, pids[0])
+	assert.Zero(t, pids[1])
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, map[int]int{0: 0, 1: 0}, group.PIDs())
+}
+
+// TestWithRestartPolicyObject tests giving up on a watched instance once its
+// restart policy allows no more attempts.
+func TestWithRestartPolicyObject(t *testing.T) {
+	t.Parallel()
+
+	policy := cmdgroup.RestartPolicy{MaxAttempts: 2, InitialDelay: 10 * time.Millisecond}
+	group, err := cmdgroup.New("false",
+		cmdgroup.WithWatch("all"),
+		cmdgroup.WithRestartPolicyObject(policy),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, policy, group.Instances[0].RestartPolicy)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	require.Error(t, group.Run(ctx))
+	require.NoError(t, ctx.Err())
+	assert.Equal(t, 3, group.Instances[0].Stats().Starts)
+}
+
+// TestGroupTerminationReason tests reporting why a group stopped running.
+func TestGroupTerminationReason(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name    string
+		options []cmdgroup.Option
+		cancel  bool
+		invalid bool
+		want    cmdgroup.TerminationReason
+	}{
+		"completed": {
+			name: "true",
+			want: cmdgroup.TerminationCompleted,
+		},
+		"canceled": {
+			name:    "sleep",
+			options: []cmdgroup.Option{cmdgroup.WithArgs([]string{"60"})},
+			cancel:  true,
+			want:    cmdgroup.TerminationCanceled,
+		},
+		"failed": {
+			name:    "sh",
+			options: []cmdgroup.Option{cmdgroup.WithArgs([]string{"--", "-c", "exit 1", "--", "-c", "sleep 60"})},
+			want:    cmdgroup.TerminationFailed,
+		},
+		"stop group on exit": {
+			name: "sh",
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "-c", "exit 0", "--", "-c", "sleep 60"}),
+				cmdgroup.WithStopGroupOnExit("0"),
+			},
+			want: cmdgroup.TerminationStopGroupOnExit,
+		},
+		"first success": {
+			name: "sh",
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"--", "-c", "exit 0", "--", "-c", "sleep 60"}),
+				cmdgroup.WithStopOnFirstSuccess(true),
+			},
+			want: cmdgroup.TerminationFirstSuccess,
+		},
+		"max runtime": {
+			name: "sleep",
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{"60"}),
+				cmdgroup.WithMaxRuntime(50 * time.Millisecond),
+			},
+			want: cmdgroup.TerminationMaxRuntime,
+		},
+		"setup failed": {
+			name:    "true",
+			invalid: true,
+			want:    cmdgroup.TerminationSetupFailed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New(tt.name, tt.options...)
+			require.NoError(t, err)
+			assert.Empty(t, group.TerminationReason())
+			if tt.invalid {
+				group.ShutdownOrder = []int{1}
+			}
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			if tt.cancel {
+				group.OnReady = cancel
+			}
+
+			_ = group.Run(ctx)
+			assert.Equal(t, tt.want, group.TerminationReason())
+		})
+	}
+}
+
+// TestWithStopOnFirstSuccess tests stopping the slow instances once one
+// exited successfully, and not stopping on failures or one-shot exits.
+func TestWithStopOnFirstSuccess(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		args    []string
+		options []cmdgroup.Option
+		// winner is the index of the instance exiting successfully, and
+		// failed are those of the instances failing before.
+		winner   int
+		failed   []int
+		wantErr  bool
+		wantSlow bool
+	}{
+		"fast success": {
+			args:   []string{"--", "-c", "sleep 60", "--", "-c", "exit 0", "--", "-c", "sleep 60", "--", "-c", "sleep 60"},
+			winner: 1,
+		},
+		"fast failure and slower success": {
+			args:   []string{"--", "-c", "sleep 60", "--", "-c", "exit 1", "--", "-c", "sleep 0.2", "--", "-c", "sleep 60"},
+			winner: 2,
+			failed: []int{1},
+		},
+		"all failures": {
+			args:    []string{"--", "-c", "exit 1", "--", "-c", "sleep 0.2; exit 2"},
+			winner:  -1,
+			failed:  []int{0, 1},
+			wantErr: true,
+		},
+		"oneshot success": {
+			args:     []string{"--", "-c", "sleep 60", "--", "-c", "exit 0"},
+			options:  []cmdgroup.Option{cmdgroup.WithOneshot(1, true)},
+			wantSlow: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			options := append([]cmdgroup.Option{
+				cmdgroup.WithArgs(tt.args),
+				cmdgroup.WithStopOnFirstSuccess(true),
+			}, tt.options...)
+			group, err := cmdgroup.New("sh", options...)
+			require.NoError(t, err)
+			assert.True(t, group.StopOnFirstSuccess)
+
+			ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+			t.Cleanup(cancel)
+
+			start := time.Now()
+			err = group.Run(ctx)
+			if tt.wantSlow {
+				require.NoError(t, err)
+				assert.Equal(t, cmdgroup.TerminationCanceled, group.TerminationReason())
+
+				return
+			}
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, ctx.Err())
+			assert.Less(t, time.Since(start), 5*time.Second)
+
+			for idx, instance := range group.Instances {
+				stats := instance.Stats()
+				if stats.Starts == 0 {
+					// Stopped before it started.
+					assert.NotEqual(t, tt.winner, idx)
+					assert.NotContains(t, tt.failed, idx)
+
+					continue
+				}
+				exit := stats.Exits[len(stats.Exits)-1]
+				switch {
+				case idx == tt.winner:
+					assert.Zero(t, exit.ExitCode, "instance %d", idx)
+				case slices.Contains(tt.failed, idx):
+					assert.Positive(t, exit.ExitCode, "instance %d", idx)
+					assert.Empty(t, exit.Signal, "instance %d", idx)
+				default:
+					assert.NotEmpty(t, exit.Signal, "instance %d", idx)
+				}
+			}
+		})
+	}
+}
+
+// TestGroupEvents tests the lifecycle events of a short-lived instance.
+func TestGroupEvents(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("true", cmdgroup.WithStopGroupOnExit("all"))
+	require.NoError(t, err)
+
+	events := group.Events()
+	require.NoError(t, group.Run(t.Context()))
+
+	type event struct {
+		Type  cmdgroup.EventType
+		Index int
+	}
+	var got []event
+	for e := range events {
+		assert.False(t, e.Time.IsZero())
+		if e.Type == cmdgroup.EventStarted {
+			assert.Positive(t, e.PID)
+		}
+		got = append(got, event{Type: e.Type, Index: e.Index})
+	}
+
+	assert.Equal(t, []event{
+		{Type: cmdgroup.EventStarted, Index: 0},
+		{Type: cmdgroup.EventReady, Index: -1},
+		{Type: cmdgroup.EventExited, Index: 0},
+		{Type: cmdgroup.EventShutdown, Index: -1},
+	}, got)
+	assert.Zero(t, group.DroppedEvents())
+}
+
+// TestGroupEventsDropped tests dropping events a consumer falls behind on
+// instead of blocking.
+func TestGroupEventsDropped(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("true", cmdgroup.WithArgs(slices.Repeat([]string{"--"}, 40)))
+	require.NoError(t, err)
+
+	events := group.Events()
+	require.NoError(t, group.Run(t.Context()))
+
+	var n int
+	for range events {
+		n++
+	}
+	assert.Equal(t, 64, n)
+	assert.Equal(t, 40*2+1-64, group.DroppedEvents())
+}
+
+// TestWithExitCodeMap tests interpreting exit codes as mapped.
+func TestWithExitCodeMap(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		codes        map[int]int
+		wantErr      assert.ErrorAssertionFunc
+		wantStatus   string
+		wantExitCode int
+	}{
+		"unmapped": {
+			codes:        map[int]int{1: 0},
+			wantErr:      assert.Error,
+			wantStatus:   "failed",
+			wantExitCode: 2,
+		},
+		"mapped to success": {
+			codes:        map[int]int{2: 0},
+			wantErr:      assert.NoError,
+			wantStatus:   "exited",
+			wantExitCode: 0,
+		},
+		"mapped to failure": {
+			codes:        map[int]int{2: 3},
+			wantErr:      assert.Error,
+			wantStatus:   "failed",
+			wantExitCode: 3,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := cmdgroup.New("sh",
+				cmdgroup.WithArgs([]string{"-c", "exit 2"}),
+				cmdgroup.WithExitCodeMap(0, tt.codes),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, tt.codes, group.Instances[0].ExitCodeMap)
+
+			tt.wantErr(t, group.Run(t.Context()))
+			summary := group.Summary().Instances[0]
+			assert.Equal(t, tt.wantStatus, summary.Status)
+			assert.Equal(t, tt.wantExitCode, summary.ExitCode)
+		})
+	}
+}
+
+// TestGroupPause tests pausing an instance and resuming it.
+func TestGroupPause(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"60"}),
+		cmdgroup.WithWatch("all"),
+	)
+	require.NoError(t, err)
+	require.Error(t, group.Pause(1))
+	require.Error(t, group.Resume(-1))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	instance := group.Instances[0]
+	for attempt := 1; attempt <= 2; attempt++ {
+		require.Eventually(t, instance.Running, 5*time.Second, time.Millisecond)
+		require.NoError(t, group.Pause(0))
+		require.Eventually(t, func() bool { return !instance.Running() }, 5*time.Second, time.Millisecond)
+		assert.True(t, instance.Paused())
+		assert.Equal(t, "paused", group.Summary().Instances[0].Status)
+
+		// A watched instance would be restarted after 1s if not paused.
+		time.Sleep(1500 * time.Millisecond)
+		assert.False(t, instance.Running())
+		assert.Equal(t, attempt, instance.Stats().Starts)
+
+		require.NoError(t, group.Resume(0))
+		assert.False(t, instance.Paused())
+	}
+
+	require.Eventually(t, instance.Running, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 3, instance.Stats().Starts)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// TestWithLazyLookup tests picking up a command that appears earlier in PATH
+// between restarts only with lazy lookup. It cannot run in parallel since it
+// changes PATH.
+func TestWithLazyLookup(t *testing.T) {
+	tests := map[string]struct {
+		lazy bool
+		want string
+	}{
+		"eager": {lazy: false, want: "old\n"},
+		"lazy":  {lazy: true, want: "new\n"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			newDir, oldDir := t.TempDir(), t.TempDir()
+			t.Setenv("PATH", newDir+string(os.PathListSeparator)+oldDir)
+			writeScript := func(dir, output string) {
+				script := []byte("#!/bin/sh\necho " + output + "\n")
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "tool"), script, 0o700)) // #nosec G306 -- the script must be executable
+			}
+			writeScript(oldDir, "old")
+
+			group, err := cmdgroup.New("tool",
+				cmdgroup.WithWatch("all"),
+				cmdgroup.WithCaptureOutput(0),
+				cmdgroup.WithLazyLookup(tt.lazy),
+				cmdgroup.WithLogger(slog.New(slog.DiscardHandler)),
+			)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			events := group.Events()
+			done := make(chan error, 1)
+			go func() { done <- group.Run(ctx) }()
+
+			var exits int
+			for e := range events {
+				if e.Type != cmdgroup.EventExited {
+					continue
+				}
+				exits++
+				if exits == 1 {
+					assert.Equal(t, []byte("old\n"), group.Output(0))
+					writeScript(newDir, "new")
+				} else {
+					cancel()
+				}
+			}
+			require.NoError(t, <-done)
+
+			assert.Equal(t, []byte(tt.want), group.Output(0))
+		})
+	}
+
+	_, err := cmdgroup.New("missing-tool", cmdgroup.WithLazyLookup(true))
+	require.Error(t, err)
+}
+
+// TestGroupSetWatch tests changing which instances are watched while the
+// group runs.
+func TestGroupSetWatch(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep", cmdgroup.WithArgs([]string{"--", "60", "--", "60"}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	instance := group.Instances[0]
+	require.Eventually(t, instance.Running, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, group.SetWatch("0"))
+	assert.Equal(t, []int{0}, group.WatchedIndices())
+	require.ErrorContains(t, group.SetWatch("0,2"), "index out of range: 2")
+	assert.Equal(t, []int{0}, group.WatchedIndices())
+
+	require.NoError(t, syscall.Kill(instance.PID(), syscall.SIGKILL))
+	require.Eventually(t, func() bool {
+		return instance.Running() && instance.Stats().Starts == 2
+	}, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, group.SetWatch(""))
+	assert.Empty(t, group.WatchedIndices())
+	require.NoError(t, syscall.Kill(instance.PID(), syscall.SIGKILL))
+	require.Error(t, <-done)
+	assert.Equal(t, 2, instance.Stats().Starts)
+}
+
+// TestGroupStop tests stopping single instances while the others keep
+// running.
+func TestGroupStop(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"--", "60", "--", "60", "--", "60"}),
+		cmdgroup.WithWatch("0"),
+		cmdgroup.WithStopGroupOnExit("1"),
+	)
+	require.NoError(t, err)
+	require.ErrorContains(t, group.Stop(0), "group not running")
+	require.Error(t, group.Stop(3))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	for idx := range group.Instances {
+		require.NoError(t, group.WaitReady(ctx, idx))
+	}
+
+	// Neither restarted although watched, nor stopping the group although
+	// set to.
+	require.NoError(t, group.Stop(0))
+	require.NoError(t, group.Stop(1))
+	require.Eventually(t, func() bool {
+		return !group.Instances[0].Running() && !group.Instances[1].Running()
+	}, 5*time.Second, time.Millisecond)
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.False(t, group.Instances[0].Running())
+	assert.Equal(t, 1, group.Instances[0].Stats().Starts)
+	assert.True(t, group.Instances[2].Running())
+	assert.Equal(t, "stopped", group.Summary().Instances[0].Status)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.ErrorContains(t, group.Stop(2), "group not running")
+}
+
+// TestGroupWaitReady tests waiting for an instance to be running.
+func TestGroupWaitReady(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	group, err := cmdgroup.New("sleep",
+		cmdgroup.WithArgs([]string{"--", "60", "--", "60"}),
+		cmdgroup.WithPreStart(1, func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+				return nil
+			}
+		}),
+	)
+	require.NoError(t, err)
+	require.Error(t, group.WaitReady(t.Context(), 2))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(waitCancel)
+	require.NoError(t, group.WaitReady(waitCtx, 0))
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	t.Cleanup(timeoutCancel)
+	require.ErrorIs(t, group.WaitReady(timeoutCtx, 1), context.DeadlineExceeded)
+	assert.False(t, group.Instances[1].Running())
+
+	close(release)
+	start := time.Now()
+	require.NoError(t, group.WaitReady(waitCtx, 1))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.True(t, group.Instances[1].Running())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// TestGroupWaitReadyExited tests waiting for an instance whose run ended
+// before the call, either after or without becoming ready.
+func TestGroupWaitReadyExited(t *testing.T) {
+	t.Parallel()
+
+	errPreStart := errors.New("pre-start failed")
+
+	tests := map[string]struct {
+		preStart func(context.Context) error
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		"ready": {
+			wantErr: assert.NoError,
+		},
+		"never ready": {
+			preStart: func(context.Context) error { return errPreStart },
+			wantErr:  assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var options []cmdgroup.Option
+			if tt.preStart != nil {
+				options = append(options, cmdgroup.WithPreStart(0, tt.preStart))
+			}
+			group, err := cmdgroup.New("true", options...)
+			require.NoError(t, err)
+			_ = group.Run(t.Context())
+			assert.False(t, group.Instances[0].Running())
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			t.Cleanup(cancel)
+			tt.wantErr(t, group.WaitReady(ctx, 0))
+			require.NoError(t, ctx.Err())
+		})
+	}
+}
+
+// TestWithArgMax tests rejecting instances whose arguments and environment
+// exceed the limit.
+func TestWithArgMax(t *testing.T) {
+	t.Parallel()
+
+	huge := slices.Repeat([]string{strings.Repeat("x", 100*1024)}, 30)
+
+	tests := map[string]struct {
+		options []cmdgroup.Option
+		wantErr string
+	}{
+		"default limit": {
+			options: []cmdgroup.Option{cmdgroup.WithArgs(slices.Concat([]string{"--", "small", "--"}, huge))},
+			wantErr: "instance 1: arguments and environment take",
+		},
+		"custom limit": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{strings.Repeat("x", 100)}),
+				cmdgroup.WithCleanEnv(true),
+				cmdgroup.WithArgMax(100),
+			},
+			wantErr: "exceeding the limit of 100 bytes",
+		},
+		"within limit": {
+			options: []cmdgroup.Option{
+				cmdgroup.WithArgs([]string{strings.Repeat("x", 10)}),
+				cmdgroup.WithCleanEnv(true),
+				cmdgroup.WithArgMax(100),
+			},
+		},
+		"disabled": {
+			options: []cmdgroup.Option{cmdgroup.WithArgs(huge), cmdgroup.WithArgMax(-1)},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cmdgroup.New("echo", tt.options...)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+			assert.Len(t, strings.Split(err.Error(), "\n"), 1)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ANSI escape sequences used to color log levels.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiGray   = "\x1b[90m"
+)
+
+// labelColors are the ANSI escape sequences instance labels are colored with,
+// avoiding the colors of levels.
+var labelColors = []string{"\x1b[32m", "\x1b[35m", "\x1b[36m", "\x1b[92m", "\x1b[95m", "\x1b[96m"} //nolint:gochecknoglobals // constant table
+
+// colorHandler is a [slog.Handler] that writes text records prefixed with an
+// ANSI-colored level and, for the logs of a labeled instance, its colored
+// label. The remainder of each record is formatted by a [slog.TextHandler].
+type colorHandler struct {
+	out      io.Writer
+	mu       *sync.Mutex
+	buf      *bytes.Buffer
+	inner    slog.Handler
+	instance string
+	grouped  bool
+}
+
+// newLogHandler returns a handler writing records of at least level to f in
+// the given format ("json" or "text"). Color ("auto", "always", or "never")
+// only applies to text output. In auto mode, color is used if f is a terminal
+// and the NO_COLOR environment variable is unset or empty.
+func newLogHandler(f *os.File, format, color string, level slog.Leveler) (slog.Handler, error) {
+	var useColor bool
+	switch color {
+	case "auto":
+		useColor = os.Getenv("NO_COLOR") == "" && isTerminal(f)
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	default:
+		return nil, fmt.Errorf("invalid color mode: %q", color)
+	}
+
+	switch format {
+	case "json":
+		return slog.NewJSONHandler(f, &slog.HandlerOptions{Level: level}), nil
+	case "text":
+		if useColor {
+			return newColorHandler(f, level), nil
+		}
+
+		return slog.NewTextHandler(f, &slog.HandlerOptions{Level: level}), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %q", format)
+	}
+}
+
+// newColorHandler returns a [colorHandler] writing records of at least level
+// to out.
+func newColorHandler(out io.Writer, level slog.Leveler) *colorHandler {
+	buf := &bytes.Buffer{}
+
+	return &colorHandler{
+		out: out,
+		mu:  &sync.Mutex{},
+		buf: buf,
+		inner: slog.NewTextHandler(buf, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.LevelKey {
+					return slog.Attr{} // Written by Handle.
+				}
+
+				return a
+			},
+		}),
+	}
+}
+
+// Enabled implements [slog.Handler].
+func (h *colorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+// Handle implements [slog.Handler].
+func (h *colorHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return fmt.Errorf("format record: %w", err)
+	}
+
+	var label string
+	if h.instance != "" {
+		label = "instance=" + labelColor(h.instance) + quoteLabel(h.instance) + ansiReset + " "
+	}
+
+	if _, err := fmt.Fprintf(h.out, "%s%-5s%s %s%s", levelColor(r.Level), r.Level, ansiReset, label, h.buf); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+
+	return nil
+}
+
+// WithAttrs implements [slog.Handler]. The instance attribute added outside of
+// any group is written colored by Handle instead of by the text handler.
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	if !h.grouped {
+		attrs = slices.DeleteFunc(slices.Clone(attrs), func(a slog.Attr) bool {
+			if a.Key != "instance" {
+				return false
+			}
+			clone.instance = a.Value.String()
+
+			return true
+		})
+	}
+	clone.inner = h.inner.WithAttrs(attrs)
+
+	return &clone
+}
+
+// WithGroup implements [slog.Handler].
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.inner = h.inner.WithGroup(name)
+	clone.grouped = true
+
+	return &clone
+}
+
+// labelColor returns the ANSI color sequence for an instance label, which is
+// the same for the same label, so the logs of an instance stand out.
+func labelColor(label string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(label))
+
+	return labelColors[hash.Sum32()%uint32(len(labelColors))]
+}
+
+// quoteLabel quotes label like the text handler quotes values, if needed.
+func quoteLabel(label string) string {
+	if strings.ContainsFunc(label, func(r rune) bool {
+		return r == '=' || r == '"' || !unicode.IsPrint(r) || unicode.IsSpace(r)
+	}) {
+		return strconv.Quote(label)
+	}
+
+	return label
+}
+
+// levelColor returns the ANSI color sequence for a log level.
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return ansiRed
+	case level >= slog.LevelWarn:
+		return ansiYellow
+	case level >= slog.LevelInfo:
+		return ansiBlue
+	default:
+		return ansiGray
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewLogHandler tests selecting a log handler by format and color mode.
+func TestNewLogHandler(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.CreateTemp(t.TempDir(), "log")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	tests := map[string]struct {
+		format   string
+		color    string
+		wantType slog.Handler
+		wantErr  assert.ErrorAssertionFunc
+	}{
+		"json": {
+			format:   "json",
+			color:    "always",
+			wantType: &slog.JSONHandler{},
+			wantErr:  assert.NoError,
+		},
+		"text auto not a terminal": {
+			format:   "text",
+			color:    "auto",
+			wantType: &slog.TextHandler{},
+			wantErr:  assert.NoError,
+		},
+		"text always": {
+			format:   "text",
+			color:    "always",
+			wantType: &colorHandler{},
+			wantErr:  assert.NoError,
+		},
+		"text never": {
+			format:   "text",
+			color:    "never",
+			wantType: &slog.TextHandler{},
+			wantErr:  assert.NoError,
+		},
+		"invalid format": {
+			format:  "xml",
+			color:   "auto",
+			wantErr: assert.Error,
+		},
+		"invalid color": {
+			format:  "text",
+			color:   "sometimes",
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handler, err := newLogHandler(f, tt.format, tt.color, nil)
+			tt.wantErr(t, err)
+			if tt.wantType != nil {
+				assert.IsType(t, tt.wantType, handler)
+			}
+		})
+	}
+}
+
+// TestColorHandler tests writing records with colored levels.
+func TestColorHandler(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := slog.New(newColorHandler(&buf, nil)).With("cmd", "echo")
+
+	logger.Debug("hidden")
+	assert.Empty(t, buf.String())
+
+	logger.Error("exited", "cause", "crash")
+	got := buf.String()
+	assert.Contains(t, got, ansiRed+"ERROR"+ansiReset)
+	assert.Contains(t, got, "msg=exited cmd=echo cause=crash\n")
+	assert.NotContains(t, got, "level=")
+
+	buf.Reset()
+	logger.WithGroup("usage").Info("exited", "max_rss", 1)
+	assert.Contains(t, buf.String(), ansiBlue+"INFO "+ansiReset)
+	assert.Contains(t, buf.String(), "usage.max_rss=1")
+
+	buf.Reset()
+	logger.With("instance", "web").Info("started")
+	got = buf.String()
+	assert.Contains(t, got, ansiBlue+"INFO "+ansiReset+" instance="+labelColor("web")+"web"+ansiReset+" time=")
+	assert.Contains(t, got, "msg=started cmd=echo\n")
+
+	buf.Reset()
+	logger.WithGroup("usage").With("instance", "web").Info("exited")
+	assert.Contains(t, buf.String(), "usage.instance=web")
+}
+
+// TestIsTerminal tests that character devices other than terminals, such as
+// /dev/null, are not terminals.
+func TestIsTerminal(t *testing.T) {
+	t.Parallel()
+
+	devNull, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = devNull.Close() })
+
+	assert.False(t, isTerminal(devNull))
+
+	handler, err := newLogHandler(devNull, "text", "auto", nil)
+	require.NoError(t, err)
+	assert.IsType(t, &slog.TextHandler{}, handler)
+}
@@ -3,60 +3,504 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/exec"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const gokrazyDoNotSuperviseExitCode = 125
 
 func main() {
 	ctx := context.Background()
-	os.Exit(run(ctx, os.Args))
+	os.Exit(run(ctx, os.Args, os.Getenv, os.Stdout))
 }
 
-func run(ctx context.Context, args []string) int {
+// run runs the CLI with the given arguments and environment lookup function,
+// writing reports such as the summary to stdout. CMDGROUP_WATCH sets the
+// default of -watch, and CMDGROUP_ARGS provides the command line, split like a
+// shell, if there are no positional arguments.
+func run(ctx context.Context, args []string, getenv func(string) string, stdout io.Writer) int {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 
 	flagSet := flag.NewFlagSet("cmdgroup", flag.ContinueOnError)
-	watch := flagSet.String("watch", "none", "watch none, all, or 0,1,... instances")
+	watch := flagSet.String("watch", cmp.Or(getenv("CMDGROUP_WATCH"), "none"), "watch none, all, or 0,1,... instances by index or label (env CMDGROUP_WATCH)")
+	labels := flagSet.String("labels", "", "label the instances in order, comma-separated, for logs, -watch, and the summary")
+	glob := flagSet.String("glob", "none", "expand glob patterns in arguments: none, inline, or per-instance")
+	globFailNoMatch := flagSet.Bool("glob-fail-no-match", false, "fail if a glob pattern has no matches")
+	stopGroupOnExit := flagSet.String("stop-group-on-exit", "none", "stop the group when none, all, or 0,1,... instances exit")
+	firstSuccess := flagSet.Bool("stop-on-first-success", false, "stop the group once any instance exits successfully")
+	strictWatch := flagSet.Bool("strict-watch", false, "reject empty instances and duplicate indexes in -watch")
+	logFormat := flagSet.String("log-format", "json", "log format: json or text")
+	color := flagSet.String("color", "auto", "color text logs: auto, always, or never")
+	logOutput := flagSet.Bool("log-output", false, "log each line of the commands' output instead of passing it through")
+	quiet := flagSet.Bool("quiet", false, "discard the output of all commands, logging only their lifecycle")
+	logRunTimes := flagSet.Bool("log-run-times", false, "log how long each command took to start and ran when it exits")
+	maxLineBytes := flagSet.Int("max-line-bytes", 0, "truncate logged output lines longer than this (default 65536)")
+	maxOutputBytes := flagSet.Int64("max-output-bytes", 0, "drop output of each command run beyond this many bytes (0 means no limit)")
+	outputFlush := flagSet.Duration("output-flush", 0, "log output lines together at this interval instead of one by one (0 disables)")
+	outputOverflow := flagSet.String("output-overflow", "block", "when logging output falls behind: block the commands or drop output")
+	subreaper := flagSet.Bool("subreaper", false, "reap orphaned descendants as a child subreaper (Linux only)")
+	cgroup := flagSet.String("cgroup", "", "move the commands into this cgroup v2 directory (Linux only)")
+	maxRuntime := flagSet.Duration("max-runtime", 0, "stop all instances after this duration (0 means no limit)")
+	killGraceFlag := flagSet.Duration("kill-grace", 0, "kill commands not exiting this long after their stop signal (default 10s, env GOKRAZY_STOP_TIMEOUT)")
+	summary := flagSet.String("summary", "", "print a run summary to stdout on exit: json")
+	stdin := flagSet.Bool("stdin", false, "read one command line per instance from stdin")
+	setsid := flagSet.Bool("setsid", false, "start commands in a new session, detached from the controlling terminal")
+	skipLookup := flagSet.Bool("skip-lookup", false, "do not resolve command names (the group cannot run; use with -dry-run)")
+	dryRun := flagSet.Bool("dry-run", false, "print the instances to stdout and exit without running them")
+	showSecrets := flagSet.Bool("show-secrets", false, "show values of secret-looking environment variables in -dry-run output")
+	restartSignals := flagSet.Bool("restart-signals", false, "restart instance 0 on SIGUSR1, instance 1 on SIGUSR2, and all on SIGHUP")
+	exitMode := flagSet.String("exit-mode", "fixed", "exit code on failure: fixed (1), child (the failed command's), or nosupervise (-nosupervise-code)")
+	printVersion := flagSet.Bool("version", false, "print the version and exit")
+	debugSignal := flagSet.Bool("debug-signal", false, "toggle debug logging on SIGUSR1")
+	reexec := flagSet.Bool("reexec", false, "on SIGUSR2, stop all instances and re-execute cmdgroup with the same arguments")
+	restartWindow := flagSet.String("restart-window", "", "allow automatic restarts only at these daily times: HH:MM-HH:MM,...")
+	reloadStrategy := flagSet.String("reload-strategy", "parallel", "restart all instances on SIGHUP: parallel or rolling")
+	watchConfigFile := flagSet.String("watch-config-file", "", "reload all instances when this file changes, as on SIGHUP")
+	finalizer := flagSet.String("finalizer", "", "run this command line once all commands exited on their own, with their exit codes in CMDGROUP_EXIT_CODES")
+	controlSocket := flagSet.String("control-socket", "", "accept control commands on this Unix socket: restart N, stop N, pause N, resume N, reload, status")
+	nosuperviseCode := flagSet.Int("nosupervise-code", gokrazyDoNotSuperviseExitCode, "exit code telling the supervisor not to restart cmdgroup, used on setup failures and by -exit-mode nosupervise")
 	if err := flagSet.Parse(args[1:]); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return 0
 		}
 		logger.ErrorContext(ctx, "parsing flags", "error", err)
 
-		return gokrazyDoNotSuperviseExitCode
+		return *nosuperviseCode
 	}
 
-	positionalArgs := flagSet.Args()
-	if len(positionalArgs) == 0 {
-		logger.ErrorContext(ctx, "no command specified")
+	if *nosuperviseCode < 1 || *nosuperviseCode > 255 {
+		logger.ErrorContext(ctx, "invalid nosupervise code", "nosupervise_code", *nosuperviseCode)
 		return gokrazyDoNotSuperviseExitCode
 	}
 
-	group, err := New(
-		positionalArgs[0],
-		WithArgs(positionalArgs[1:]),
+	if *printVersion {
+		if _, err := fmt.Fprintln(stdout, version()); err != nil {
+			logger.ErrorContext(ctx, "printing version", "error", err)
+			return 1
+		}
+
+		return 0
+	}
+
+	level := new(slog.LevelVar)
+	handler, err := newLogHandler(os.Stderr, *logFormat, *color, level)
+	if err != nil {
+		logger.ErrorContext(ctx, "creating log handler", "error", err)
+		return *nosuperviseCode
+	}
+	logger = slog.New(handler)
+	logger.InfoContext(ctx, "starting", "version", version())
+
+	if *summary != "" && *summary != "json" {
+		logger.ErrorContext(ctx, "invalid summary format", "summary", *summary)
+		return *nosuperviseCode
+	}
+
+	if *exitMode != "fixed" && *exitMode != "child" && *exitMode != "nosupervise" {
+		logger.ErrorContext(ctx, "invalid exit mode", "exit_mode", *exitMode)
+		return *nosuperviseCode
+	}
+
+	if *reexec && *restartSignals {
+		logger.ErrorContext(ctx, "-reexec and -restart-signals both use SIGUSR2")
+		return *nosuperviseCode
+	}
+
+	if *debugSignal && *restartSignals {
+		logger.ErrorContext(ctx, "-debug-signal and -restart-signals both use SIGUSR1")
+		return *nosuperviseCode
+	}
+
+	schedule, err := parseRestartSchedule(*restartWindow)
+	if err != nil {
+		logger.ErrorContext(ctx, "invalid restart window", "error", err)
+		return *nosuperviseCode
+	}
+
+	grace, err := killGrace(*killGraceFlag, getenv)
+	if err != nil {
+		logger.ErrorContext(ctx, "invalid kill grace", "error", err)
+		return *nosuperviseCode
+	}
+
+	options := []Option{
 		WithWatch(*watch),
+		WithGlobExpand(*glob, *globFailNoMatch),
+		WithStrictWatch(*strictWatch),
+		WithStopGroupOnExit(*stopGroupOnExit),
+		WithStopOnFirstSuccess(*firstSuccess),
 		WithLogger(logger),
-	)
+		WithLogOutput(*logOutput),
+		WithLogRunTimes(*logRunTimes),
+		WithQuiet(*quiet),
+		WithMaxLineBytes(*maxLineBytes),
+		WithOutputFlush(*outputFlush),
+		WithOutputOverflow(*outputOverflow),
+		WithMaxOutputBytes(*maxOutputBytes),
+		WithSubreaper(*subreaper),
+		WithCgroup(*cgroup),
+		WithMaxRuntime(*maxRuntime),
+		WithSkipLookup(*skipLookup),
+		WithSetsid(*setsid),
+		WithReloadStrategy(*reloadStrategy),
+		WithControlSocket(*controlSocket),
+		WithWatchConfigFile(*watchConfigFile),
+		WithRestartWindow(schedule),
+		WithKillGrace(grace),
+	}
+	if *labels != "" {
+		options = append(options, WithLabels(strings.Split(*labels, ",")))
+	}
+	if *finalizer != "" {
+		words, err := splitCommandLine(*finalizer)
+		if err != nil || len(words) == 0 {
+			logger.ErrorContext(ctx, "parsing finalizer", "error", cmp.Or(err, errors.New("empty command")))
+			return *nosuperviseCode
+		}
+		options = append(options, WithFinalizer(words[0], words[1:]...))
+	}
+
+	positional := flagSet.Args()
+	if envArgs := getenv("CMDGROUP_ARGS"); len(positional) == 0 && envArgs != "" && !*stdin {
+		if positional, err = splitCommandLine(envArgs); err != nil {
+			logger.ErrorContext(ctx, "parsing CMDGROUP_ARGS", "error", err)
+			return *nosuperviseCode
+		}
+	}
+
+	var group *Group
+	if *stdin {
+		group, err = newGroupFromReader(os.Stdin, positional, options)
+	} else {
+		group, err = newGroupFromArgs(positional, options)
+	}
 	if err != nil {
 		logger.ErrorContext(ctx, "creating new command group", "error", err)
-		return gokrazyDoNotSuperviseExitCode
+		return *nosuperviseCode
+	}
+
+	if *dryRun {
+		if err := describeGroup(stdout, group, *showSecrets); err != nil {
+			logger.ErrorContext(ctx, "describing command group", "error", err)
+			return 1
+		}
+
+		return 0
 	}
 
 	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := group.Run(ctx); err != nil {
-		logger.ErrorContext(ctx, "running command group", "error", err)
+	// Without a handler, the runtime exits on writing to a broken stdout or
+	// stderr pipe. With one, such writes fail with EPIPE and forwarding of
+	// output stops, while commands keep the default disposition.
+	sigpipe := make(chan os.Signal, 1)
+	signal.Notify(sigpipe, syscall.SIGPIPE)
+	defer signal.Stop(sigpipe)
+
+	if *restartSignals {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+		defer signal.Stop(sigs)
+
+		go handleRestartSignals(ctx, sigs, group, logger)
+	}
+
+	if *debugSignal {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGUSR1)
+		defer signal.Stop(sigs)
+
+		go toggleDebugLevel(ctx, sigs, level, logger)
+	}
+
+	runCtx := ctx
+	if *reexec {
+		var stopReexec context.CancelFunc
+		runCtx, stopReexec = signal.NotifyContext(ctx, syscall.SIGUSR2)
+		defer stopReexec()
+	}
+
+	runErr := group.Run(runCtx)
+
+	if *summary == "json" {
+		if err := json.NewEncoder(stdout).Encode(group.Summary()); err != nil {
+			logger.ErrorContext(ctx, "writing summary", "error", err)
+		}
+	}
+
+	if runErr != nil {
+		logger.ErrorContext(ctx, "running command group", "error", runErr, "reason", group.TerminationReason())
+		return failureExitCode(group, runErr, *exitMode, *nosuperviseCode)
+	}
+
+	if runCtx.Err() != nil && ctx.Err() == nil {
+		logger.InfoContext(ctx, "re-executing")
+
+		// Only returns on failure.
+		err := reexecSelf(args)
+		logger.ErrorContext(ctx, "re-executing failed", "error", err)
+
 		return 1
 	}
 
 	return 0
 }
+
+// version returns the module version cmdgroup was built from, such as
+// "v1.2.3" when installed with go install or "(devel)" when built from a
+// checkout, or "unknown" if the binary has no build information.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+
+	return info.Main.Version
+}
+
+// newGroupFromArgs creates a command group from positional arguments, where
+// the first argument is the command name.
+func newGroupFromArgs(args []string, options []Option) (*Group, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+
+	return New(args[0], append(options, WithArgs(args[1:]))...)
+}
+
+// newGroupFromReader creates a command group from command lines read from r.
+// Positional arguments are not allowed.
+func newGroupFromReader(r io.Reader, args []string, options []Option) (*Group, error) {
+	if len(args) > 0 {
+		return nil, errors.New("positional arguments are not allowed with -stdin")
+	}
+
+	lines, err := readCommandLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no command specified")
+	}
+
+	return NewMulti(lines, options...)
+}
+
+// killGrace returns the grace period of the -kill-grace flag if it is set, or
+// else of the GOKRAZY_STOP_TIMEOUT environment variable, given as a duration
+// or a number of seconds. Zero selects the default.
+func killGrace(flagValue time.Duration, getenv func(string) string) (time.Duration, error) {
+	env := getenv("GOKRAZY_STOP_TIMEOUT")
+	if flagValue != 0 || env == "" {
+		return flagValue, nil
+	}
+
+	if seconds, err := strconv.Atoi(env); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		return 0, fmt.Errorf("parse GOKRAZY_STOP_TIMEOUT: %w", err)
+	}
+
+	return d, nil
+}
+
+// describeGroup writes one line per instance to w with its index, its label
+// if it has one, whether it is watched, its stop signal, restart policy,
+// working directory, the variables added to its environment, and its command
+// line. Values of variables that look secret are redacted unless showSecrets
+// is set.
+func describeGroup(w io.Writer, group *Group, showSecrets bool) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get working directory: %w", err)
+	}
+
+	for idx, instance := range group.Instances {
+		var label string
+		if instance.Label != "" {
+			label = "label=" + instance.Label + "\t"
+		}
+
+		env := instance.Env
+		if !showSecrets {
+			env = redactEnv(env)
+		}
+
+		if _, err := fmt.Fprintf(w, "%d\t%swatch=%t\tstop_signal=%s\trestart_policy=%s\tdir=%s\tclean_env=%t\tenv=%q\t%s\n",
+			idx, label, instance.Watch, signalName(instance.stopSignal()), instance.RestartPolicy, dir,
+			instance.CleanEnv, env, instance); err != nil {
+			return fmt.Errorf("write instance: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// redactEnv returns env with the values of variables whose names suggest
+// they hold secrets, such as API_TOKEN or DB_PASSWORD, replaced.
+func redactEnv(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		upper := strings.ToUpper(key)
+		for _, pattern := range []string{"TOKEN", "PASSWORD", "PASSWD", "SECRET", "CREDENTIAL", "API_KEY", "PRIVATE_KEY"} {
+			if strings.Contains(upper, pattern) {
+				entry = key + "=REDACTED"
+				break
+			}
+		}
+
+		redacted = append(redacted, entry)
+	}
+
+	return redacted
+}
+
+// failureExitCode returns the exit code of cmdgroup after the group failed.
+// In "child" mode, it is the exit code of the first failed instance, or 128
+// plus the signal number if a signal terminated it, like a shell, or else the
+// exit code of a failed finalizer in runErr. In "nosupervise" mode, it is
+// nosuperviseCode, which tells the supervisor not to restart cmdgroup.
+// Otherwise, it is 1.
+func failureExitCode(group *Group, runErr error, mode string, nosuperviseCode int) int {
+	switch mode {
+	case "nosupervise":
+		return nosuperviseCode
+	case "child":
+		for _, instance := range group.Instances {
+			stats := instance.Stats()
+			if instance.checkErr(stats.LastErr) == nil {
+				continue
+			}
+
+			if sig, ok := exitSignal(stats.LastErr); ok {
+				return 128 + int(sig)
+			}
+			if stats.ExitCode > 0 {
+				return stats.ExitCode
+			}
+
+			return 1
+		}
+
+		if exitErr, ok := errors.AsType[*exec.ExitError](runErr); ok && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+
+		return 1
+	default:
+		return 1
+	}
+}
+
+// toggleDebugLevel switches level between info and debug on every signal
+// received from sigs until ctx is done. Loggers derived from a handler using
+// level follow the change.
+func toggleDebugLevel(ctx context.Context, sigs <-chan os.Signal, level *slog.LevelVar, logger *slog.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			if level.Level() == slog.LevelDebug {
+				level.Set(slog.LevelInfo)
+			} else {
+				level.Set(slog.LevelDebug)
+			}
+
+			logger.InfoContext(ctx, "log level changed", "signal", sig, "level", level.Level())
+		}
+	}
+}
+
+// reexecSelf replaces the current process with a new execution of its own
+// executable with args. It only returns on failure.
+func reexecSelf(args []string) error {
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find executable: %w", err)
+	}
+
+	// #nosec G204 -- re-executes this program with its own arguments
+	if err := syscall.Exec(path, args, os.Environ()); err != nil {
+		return fmt.Errorf("exec %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// handleRestartSignals restarts instances on receiving signals until ctx is
+// done. SIGUSR1 restarts instance 0, SIGUSR2 restarts instance 1, and SIGHUP
+// reloads the group. A reload runs in the background, so restart signals are
+// still handled while it waits for instances, and SIGHUP is ignored until it
+// is done.
+func handleRestartSignals(ctx context.Context, sigs <-chan os.Signal, group *Group, logger *slog.Logger) {
+	var reloading atomic.Bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if !reloading.CompareAndSwap(false, true) {
+					logger.WarnContext(ctx, "ignoring reload signal", "signal", sig, "reason", "reload in progress")
+					continue
+				}
+
+				logger.InfoContext(ctx, "reload requested", "signal", sig, "strategy", group.ReloadStrategy)
+				go func() {
+					defer reloading.Store(false)
+
+					if err := group.Reload(ctx); err != nil {
+						logger.WarnContext(ctx, "reload failed", "signal", sig, "error", err)
+					}
+				}()
+
+				continue
+			}
+
+			index, ok := restartSignalIndex(sig)
+			if !ok {
+				continue
+			}
+
+			if err := group.Restart(index); err != nil {
+				logger.WarnContext(ctx, "ignoring restart signal", "signal", sig, "error", err)
+				continue
+			}
+
+			logger.InfoContext(ctx, "restart requested", "signal", sig, "index", index)
+		}
+	}
+}
+
+// restartSignalIndex returns the index of the instance restarted by sig.
+func restartSignalIndex(sig os.Signal) (int, bool) {
+	switch sig {
+	case syscall.SIGUSR1:
+		return 0, true
+	case syscall.SIGUSR2:
+		return 1, true
+	default:
+		return 0, false
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestRun tests the CLI entry point with various argument combinations.
@@ -11,8 +20,10 @@ func TestRun(t *testing.T) {
 	t.Parallel()
 
 	tests := map[string]struct {
-		args     []string
-		wantCode int
+		args       []string
+		env        map[string]string
+		wantCode   int
+		wantStdout string
 	}{
 		"no command specified": {
 			args:     []string{"cmdgroup"},
@@ -22,6 +33,22 @@ func TestRun(t *testing.T) {
 			args:     []string{"cmdgroup", "-help"},
 			wantCode: 0,
 		},
+		"custom nosupervise code": {
+			args:     []string{"cmdgroup", "-nosupervise-code", "3", "/nonexistent/binary"},
+			wantCode: 3,
+		},
+		"custom nosupervise code invalid flag": {
+			args:     []string{"cmdgroup", "-nosupervise-code", "3", "-nonexistent"},
+			wantCode: 3,
+		},
+		"custom nosupervise code exit mode": {
+			args:     []string{"cmdgroup", "-nosupervise-code", "3", "-exit-mode", "nosupervise", "false"},
+			wantCode: 3,
+		},
+		"invalid nosupervise code": {
+			args:     []string{"cmdgroup", "-nosupervise-code", "0", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
 		"invalid flag": {
 			args:     []string{"cmdgroup", "-nonexistent"},
 			wantCode: gokrazyDoNotSuperviseExitCode,
@@ -34,6 +61,159 @@ func TestRun(t *testing.T) {
 			args:     []string{"cmdgroup", "-watch", "abc", "true"},
 			wantCode: gokrazyDoNotSuperviseExitCode,
 		},
+		"invalid log format": {
+			args:     []string{"cmdgroup", "-log-format", "xml", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"invalid color mode": {
+			args:     []string{"cmdgroup", "-color", "sometimes", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"text log format": {
+			args:     []string{"cmdgroup", "-log-format", "text", "-color", "never", "true"},
+			wantCode: 0,
+		},
+		"strict watch trailing separator": {
+			args:     []string{"cmdgroup", "-watch", "1", "-strict-watch", "true", "--", "a", "--"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"stop group on exit": {
+			args:     []string{"cmdgroup", "-stop-group-on-exit", "0", "sleep", "--", "0", "--", "60"},
+			wantCode: 0,
+		},
+		"finalizer": {
+			args:     []string{"cmdgroup", "-finalizer", "true", "true"},
+			wantCode: 0,
+		},
+		"finalizer child exit code": {
+			args:     []string{"cmdgroup", "-exit-mode", "child", "-finalizer", "sh -c 'exit 7'", "true"},
+			wantCode: 7,
+		},
+		"invalid finalizer": {
+			args:     []string{"cmdgroup", "-finalizer", "'unterminated", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"finalizer not found": {
+			args:     []string{"cmdgroup", "-finalizer", "/nonexistent/binary", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"invalid summary format": {
+			args:     []string{"cmdgroup", "-summary", "xml", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"json summary": {
+			args:       []string{"cmdgroup", "-summary", "json", "true"},
+			wantCode:   0,
+			wantStdout: `{"instances":[{"index":0,`,
+		},
+		"max runtime": {
+			args:     []string{"cmdgroup", "-max-runtime", "100ms", "-watch", "all", "sleep", "60"},
+			wantCode: 0,
+		},
+		"invalid glob mode": {
+			args:     []string{"cmdgroup", "-glob", "all", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"glob no match": {
+			args:     []string{"cmdgroup", "-glob", "inline", "-glob-fail-no-match", "true", "/nonexistent/*"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"dry run": {
+			args:     []string{"cmdgroup", "-dry-run", "-watch", "all", "false"},
+			wantCode: 0,
+		},
+		"dry run skip lookup": {
+			args:     []string{"cmdgroup", "-dry-run", "-skip-lookup", "/nonexistent/binary", "--", "a", "--", "b"},
+			wantCode: 0,
+		},
+		"skip lookup without dry run": {
+			args:     []string{"cmdgroup", "-skip-lookup", "true"},
+			wantCode: 1,
+		},
+		"invalid reload strategy": {
+			args:     []string{"cmdgroup", "-reload-strategy", "random", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"env args": {
+			args:     []string{"cmdgroup"},
+			env:      map[string]string{"CMDGROUP_ARGS": "sh -c 'exit 3'"},
+			wantCode: 1,
+		},
+		"invalid stop timeout env": {
+			args:     []string{"cmdgroup", "true"},
+			env:      map[string]string{"GOKRAZY_STOP_TIMEOUT": "soon"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"env args invalid": {
+			args:     []string{"cmdgroup"},
+			env:      map[string]string{"CMDGROUP_ARGS": "sh -c 'exit 3"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"flag args override env args": {
+			args:     []string{"cmdgroup", "true"},
+			env:      map[string]string{"CMDGROUP_ARGS": "false"},
+			wantCode: 0,
+		},
+		"env watch": {
+			args:     []string{"cmdgroup", "-max-runtime", "100ms", "false"},
+			env:      map[string]string{"CMDGROUP_WATCH": "all"},
+			wantCode: 0,
+		},
+		"env watch invalid": {
+			args:     []string{"cmdgroup", "true"},
+			env:      map[string]string{"CMDGROUP_WATCH": "abc"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"flag watch overrides env watch": {
+			args:     []string{"cmdgroup", "-watch", "none", "true"},
+			env:      map[string]string{"CMDGROUP_WATCH": "abc"},
+			wantCode: 0,
+		},
+		"reexec with restart signals": {
+			args:     []string{"cmdgroup", "-reexec", "-restart-signals", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"reexec not requested": {
+			args:     []string{"cmdgroup", "-reexec", "true"},
+			wantCode: 0,
+		},
+		"version": {
+			args:       []string{"cmdgroup", "-version"},
+			wantCode:   0,
+			wantStdout: version() + "\n",
+		},
+		"invalid exit mode": {
+			args:     []string{"cmdgroup", "-exit-mode", "random", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"debug signal with restart signals": {
+			args:     []string{"cmdgroup", "-debug-signal", "-restart-signals", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"invalid restart window": {
+			args:     []string{"cmdgroup", "-restart-window", "22:00", "true"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
+		"exit mode fixed": {
+			args:     []string{"cmdgroup", "-exit-mode", "fixed", "sh", "-c", "exit 3"},
+			wantCode: 1,
+		},
+		"exit mode child": {
+			args:     []string{"cmdgroup", "-exit-mode", "child", "sh", "-c", "exit 3"},
+			wantCode: 3,
+		},
+		"exit mode child signal": {
+			args:     []string{"cmdgroup", "-exit-mode", "child", "sh", "-c", "kill -KILL $$"},
+			wantCode: 128 + 9,
+		},
+		"exit mode child success": {
+			args:     []string{"cmdgroup", "-exit-mode", "child", "true"},
+			wantCode: 0,
+		},
+		"exit mode nosupervise": {
+			args:     []string{"cmdgroup", "-exit-mode", "nosupervise", "false"},
+			wantCode: gokrazyDoNotSuperviseExitCode,
+		},
 		"successful command": {
 			args:     []string{"cmdgroup", "true"},
 			wantCode: 0,
@@ -47,8 +227,255 @@ func TestRun(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			code := run(t.Context(), tt.args)
+			getenv := func(key string) string { return tt.env[key] }
+			var stdout bytes.Buffer
+			code := run(t.Context(), tt.args, getenv, &stdout)
 			assert.Equal(t, tt.wantCode, code)
+			assert.Contains(t, stdout.String(), tt.wantStdout)
+		})
+	}
+}
+
+// TestKillGrace tests taking the kill grace period from the flag or else the
+// environment into the WaitDelay of the commands.
+func TestKillGrace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		flag          time.Duration
+		env           string
+		want          time.Duration
+		wantWaitDelay time.Duration
+	}{
+		"default": {
+			wantWaitDelay: 10 * time.Second,
+		},
+		"env duration": {
+			env:           "30s",
+			want:          30 * time.Second,
+			wantWaitDelay: 30 * time.Second,
+		},
+		"env seconds": {
+			env:           "5",
+			want:          5 * time.Second,
+			wantWaitDelay: 5 * time.Second,
+		},
+		"flag overrides env": {
+			flag:          2 * time.Second,
+			env:           "30s",
+			want:          2 * time.Second,
+			wantWaitDelay: 2 * time.Second,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			getenv := func(key string) string {
+				return map[string]string{"GOKRAZY_STOP_TIMEOUT": tt.env}[key]
+			}
+			got, err := killGrace(tt.flag, getenv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			group, err := New("true", WithKillGrace(got))
+			require.NoError(t, err)
+			cmd, _ := group.Instances[0].newCmd(t.Context(), nil, nil)
+			assert.Equal(t, tt.wantWaitDelay, cmd.WaitDelay)
+		})
+	}
+
+	_, err := killGrace(0, func(string) string { return "soon" })
+	require.ErrorContains(t, err, "parse GOKRAZY_STOP_TIMEOUT")
+}
+
+// TestDescribeGroup tests describing the instances of a group, redacting
+// secrets unless they are to be shown.
+func TestDescribeGroup(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		showSecrets bool
+		wantEnv     string
+	}{
+		"redacted": {
+			showSecrets: false,
+			wantEnv:     `["ID=1" "API_TOKEN=REDACTED" "db_password=REDACTED"]`,
+		},
+		"shown": {
+			showSecrets: true,
+			wantEnv:     `["ID=1" "API_TOKEN=abc" "db_password=hunter2"]`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			group, err := New("/nonexistent/binary",
+				WithArgs([]string{"-v", "--", "a", "--", "b"}),
+				WithWatch("b"),
+				WithLabels([]string{"", "b"}),
+				WithIndexEnv("ID"),
+				WithEnv([]string{"API_TOKEN=abc", "db_password=hunter2"}),
+				WithStopSignal(syscall.SIGINT),
+				WithRestartPolicyObject(RestartPolicy{Multiplier: 2, MaxAttempts: 3}),
+				WithSkipLookup(true),
+			)
+			require.NoError(t, err)
+
+			var buf strings.Builder
+			require.NoError(t, describeGroup(&buf, group, tt.showSecrets))
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			require.Len(t, lines, 2)
+			assert.Equal(t, "1\tlabel=b\twatch=true\tstop_signal=SIGINT\t"+
+				"restart_policy=delay=1s,multiplier=2,max_attempts=3\tdir="+dir+"\tclean_env=false\t"+
+				"env="+tt.wantEnv+"\t/nonexistent/binary -v b", lines[1])
+			assert.True(t, strings.HasPrefix(lines[0], "0\twatch=false\t"))
+			assert.True(t, strings.HasSuffix(lines[0], "\t/nonexistent/binary -v a"))
+		})
+	}
+}
+
+// TestVersion tests reporting the build version.
+func TestVersion(t *testing.T) {
+	t.Parallel()
+	assert.NotEmpty(t, version())
+}
+
+// TestToggleDebugLevel tests toggling debug logging on signals for loggers
+// derived from the handler.
+func TestToggleDebugLevel(t *testing.T) {
+	t.Parallel()
+
+	// Loggers derived from the same handler serialize their writes.
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level}))
+	instanceLogger := logger.With("cmd", "echo")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	sigs := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toggleDebugLevel(ctx, sigs, level, logger)
+	}()
+
+	instanceLogger.DebugContext(ctx, "first")
+	sigs <- syscall.SIGUSR1
+	require.Eventually(t, func() bool { return level.Level() == slog.LevelDebug }, 5*time.Second, time.Millisecond)
+	instanceLogger.DebugContext(ctx, "second")
+	sigs <- syscall.SIGUSR1
+	require.Eventually(t, func() bool { return level.Level() == slog.LevelInfo }, 5*time.Second, time.Millisecond)
+	instanceLogger.DebugContext(ctx, "third")
+	cancel()
+	<-done
+
+	assert.NotContains(t, buf.String(), "msg=first")
+	assert.Contains(t, buf.String(), "msg=second cmd=echo")
+	assert.NotContains(t, buf.String(), "msg=third")
+	assert.Equal(t, 2, strings.Count(buf.String(), `msg="log level changed"`))
+}
+
+// TestHandleRestartSignals tests restarting instances on signals.
+func TestHandleRestartSignals(t *testing.T) {
+	t.Parallel()
+
+	sleepPath, err := exec.LookPath("sleep")
+	require.NoError(t, err)
+
+	instance := &Instance{Name: sleepPath, Args: []string{"60"}, Watch: false}
+	group := &Group{Instances: []*Instance{instance}}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	sigs := make(chan os.Signal, 1)
+	go handleRestartSignals(ctx, sigs, group, slog.New(slog.DiscardHandler))
+
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	// Instance 1 does not exist and is ignored.
+	sigs <- syscall.SIGUSR2
+
+	// Wait for the first process to start, then restart it.
+	require.Eventually(t, func() bool {
+		sigs <- syscall.SIGUSR1
+		return instance.LastUsage() != Usage{}
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// TestNewGroupFromReader tests creating a group from command lines.
+func TestNewGroupFromReader(t *testing.T) {
+	t.Parallel()
+
+	echoPath, err := exec.LookPath("echo")
+	require.NoError(t, err)
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		input         string
+		args          []string
+		options       []Option
+		wantInstances [][]string
+		wantErr       assert.ErrorAssertionFunc
+	}{
+		"one instance per line": {
+			input:         "echo 'a b'\n\n# comment\ntrue\n",
+			options:       []Option{WithWatch("1")},
+			wantInstances: [][]string{{echoPath, "a b"}, {truePath}},
+			wantErr:       assert.NoError,
+		},
+		"no lines": {
+			input:   "# comment\n",
+			wantErr: assert.Error,
+		},
+		"positional arguments": {
+			input:   "true\n",
+			args:    []string{"echo"},
+			wantErr: assert.Error,
+		},
+		"invalid line": {
+			input:   "echo 'a\n",
+			wantErr: assert.Error,
+		},
+		"command not found": {
+			input:   "/nonexistent/binary\n",
+			wantErr: assert.Error,
+		},
+		"watch index out of range": {
+			input:   "true\n",
+			options: []Option{WithWatch("1")},
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			group, err := newGroupFromReader(strings.NewReader(tt.input), tt.args, tt.options)
+			tt.wantErr(t, err)
+			if group == nil {
+				return
+			}
+
+			var got [][]string
+			for _, instance := range group.Instances {
+				got = append(got, append([]string{instance.Name}, instance.Args...))
+			}
+			assert.Equal(t, tt.wantInstances, got)
+			assert.True(t, group.Instances[1].Watch)
 		})
 	}
 }
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMaxLineBytes is the default maximum length of a forwarded
+	// output line.
+	defaultMaxLineBytes = 64 * 1024
+
+	// outputQueueLen is how many records of logged output are queued for a
+	// slow log handler, which bounds the memory held to this many times the
+	// maximum line length.
+	outputQueueLen = 64
+)
+
+type (
+	// lineLogger is an [io.Writer] that logs each line written to it as a
+	// record. Lines longer than the maximum length are logged truncated,
+	// with the rest of the line discarded, so memory use is bounded
+	// regardless of the output. Once logging a line fails, such as when
+	// the log destination is a closed pipe, all further output is
+	// discarded. With a positive flushInterval, lines are collected and
+	// logged together as one record at that interval, or once they reach
+	// the maximum length, timed by clk. With a queue, records are handled
+	// by its goroutine instead of the writer.
+	lineLogger struct {
+		logger        *slog.Logger
+		maxLineBytes  int
+		flushInterval time.Duration
+		clk           clock
+		queue         *outputQueue
+		broken        atomic.Bool
+
+		mu               sync.Mutex
+		buf              []byte
+		discarding       bool
+		pending          []string
+		pendingBytes     int
+		pendingTruncated bool
+		timer            timer
+	}
+
+	// outputQueue hands the records of [lineLogger]s to their handlers
+	// from a goroutine of its own, so a burst of output does not wait for a
+	// slow handler until outputQueueLen records are queued. Once the queue
+	// is full, writers wait for room, so the command is slowed down by its
+	// full pipe, or with drop set, further records are dropped and
+	// counted until there is room again.
+	outputQueue struct {
+		records chan queuedRecord
+		drop    bool
+		dropped atomic.Int64
+		done    chan struct{}
+	}
+
+	// queuedRecord is a record waiting in an [outputQueue] to be handled
+	// by the handler of logger.
+	queuedRecord struct {
+		logger *lineLogger
+		record slog.Record
+	}
+
+	// outputLimit counts the output of a process across its streams and
+	// drops what exceeds the maximum, logging that once.
+	outputLimit struct {
+		logger   *slog.Logger
+		maxBytes int64
+
+		mu        sync.Mutex
+		written   int64
+		truncated bool
+	}
+
+	// limitedWriter is an [io.Writer] forwarding output to w within the
+	// limit.
+	limitedWriter struct {
+		limit *outputLimit
+		w     io.Writer
+	}
+
+	// countingWriter is an [io.Writer] forwarding output to w and passing
+	// what was forwarded to count.
+	countingWriter struct {
+		w     io.Writer
+		count func(p []byte)
+	}
+
+	// outputBuffer is an [io.Writer] collecting output in memory. It is
+	// safe for concurrent use.
+	outputBuffer struct {
+		mu  sync.Mutex
+		buf bytes.Buffer
+	}
+)
+
+// newLineLogger returns a [lineLogger] logging lines of the given stream. A
+// non-positive maxLineBytes selects the default.
+func newLineLogger(logger *slog.Logger, stream string, maxLineBytes int) *lineLogger {
+	if maxLineBytes <= 0 {
+		maxLineBytes = defaultMaxLineBytes
+	}
+
+	return &lineLogger{
+		logger:       logger.With("stream", stream),
+		maxLineBytes: maxLineBytes,
+		clk:          realClock{},
+	}
+}
+
+// Write implements [io.Writer]. It never fails, so the command writing its
+// output is not affected by a broken log destination.
+func (w *lineLogger) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	if w.broken.Load() {
+		return n, nil
+	}
+
+	for len(p) > 0 {
+		chunk, rest, found := bytes.Cut(p, []byte("\n"))
+		p = rest
+
+		if !w.discarding {
+			if room := w.maxLineBytes - len(w.buf); len(chunk) > room {
+				w.buf = append(w.buf, chunk[:room]...)
+				w.emit(true)
+				w.discarding = true
+			} else {
+				w.buf = append(w.buf, chunk...)
+			}
+		}
+
+		if found {
+			if !w.discarding {
+				w.emit(false)
+			}
+			w.discarding = false
+		}
+	}
+
+	return n, nil
+}
+
+// Flush logs a pending partial line and collected lines, if any.
+func (w *lineLogger) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 && !w.discarding {
+		w.emit(false)
+	}
+	w.discarding = false
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.flushPending()
+}
+
+// emit logs the buffered line, or collects it if lines are flushed
+// periodically, and resets the buffer.
+func (w *lineLogger) emit(truncated bool) {
+	defer func() { w.buf = w.buf[:0] }()
+
+	if w.flushInterval <= 0 {
+		w.log([]string{string(w.buf)}, truncated)
+		return
+	}
+
+	w.pending = append(w.pending, string(w.buf))
+	w.pendingBytes += len(w.buf)
+	w.pendingTruncated = w.pendingTruncated || truncated
+	if w.pendingBytes >= w.maxLineBytes {
+		w.flushPending()
+		return
+	}
+
+	if w.timer == nil {
+		w.timer = w.clk.AfterFunc(w.flushInterval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			w.timer = nil
+			w.flushPending()
+		})
+	}
+}
+
+// flushPending logs the collected lines, if any, and resets them.
+func (w *lineLogger) flushPending() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	w.log(w.pending, w.pendingTruncated)
+	w.pending, w.pendingBytes, w.pendingTruncated = w.pending[:0], 0, false
+}
+
+// log logs lines as one record, through the queue if there is one. If the
+// handler fails, the logger is marked broken.
+func (w *lineLogger) log(lines []string, truncated bool) {
+	if w.broken.Load() {
+		return
+	}
+
+	if !w.logger.Handler().Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+
+	record := slog.NewRecord(w.clk.Now(), slog.LevelInfo, "output", 0)
+	record.AddAttrs(slog.String("line", strings.Join(lines, "\n")))
+	if len(lines) > 1 {
+		record.AddAttrs(slog.Int("lines", len(lines)))
+	}
+	if truncated {
+		record.AddAttrs(slog.Bool("truncated", true))
+	}
+
+	if w.queue != nil {
+		w.queue.send(w, record)
+		return
+	}
+
+	if !w.handle(record) {
+		w.buf = nil
+		w.pending = nil
+	}
+}
+
+// handle passes record to the handler unless the logger is broken, and marks
+// it broken if the handler fails. It reports whether the logger still works.
+func (w *lineLogger) handle(record slog.Record) bool {
+	if w.broken.Load() {
+		return false
+	}
+
+	if err := w.logger.Handler().Handle(context.Background(), record); err != nil {
+		w.broken.Store(true)
+		return false
+	}
+
+	return true
+}
+
+// newOutputQueue returns an [outputQueue] handling records until it is
+// closed. With drop set, records that do not fit are dropped.
+func newOutputQueue(drop bool) *outputQueue {
+	q := &outputQueue{
+		records: make(chan queuedRecord, outputQueueLen),
+		drop:    drop,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(q.done)
+
+		for queued := range q.records {
+			queued.logger.handle(queued.record)
+		}
+	}()
+
+	return q
+}
+
+// send queues record for the handler of w, waiting for room unless records
+// are dropped.
+func (q *outputQueue) send(w *lineLogger, record slog.Record) {
+	queued := queuedRecord{logger: w, record: record}
+	if !q.drop {
+		q.records <- queued
+		return
+	}
+
+	select {
+	case q.records <- queued:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// close waits until the queued records are handled and returns how many
+// were dropped. Nothing may be sent afterwards.
+func (q *outputQueue) close() int64 {
+	close(q.records)
+	<-q.done
+
+	return q.dropped.Load()
+}
+
+// newOutputLimit returns an [outputLimit] allowing maxBytes of output.
+func newOutputLimit(logger *slog.Logger, maxBytes int64) *outputLimit {
+	return &outputLimit{logger: logger, maxBytes: maxBytes}
+}
+
+// writer returns a writer forwarding output to w within the limit.
+func (l *outputLimit) writer(w io.Writer) io.Writer {
+	return &limitedWriter{limit: l, w: w}
+}
+
+// reserve returns how many of n bytes of output may still be forwarded and
+// counts them. Once output is dropped, it logs that the output is truncated.
+func (l *outputLimit) reserve(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	allowed := int(min(int64(n), max(l.maxBytes-l.written, 0)))
+	l.written += int64(allowed)
+	if allowed < n && !l.truncated {
+		l.truncated = true
+		l.logger.Warn("output truncated", "max_bytes", l.maxBytes)
+	}
+
+	return allowed
+}
+
+// Write implements [io.Writer]. Output beyond the limit is dropped without
+// failing, so the command is not affected.
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	allowed := w.limit.reserve(len(p))
+	if allowed == 0 {
+		return len(p), nil
+	}
+
+	if n, err := w.w.Write(p[:allowed]); err != nil {
+		return n, err //nolint:wrapcheck // passthrough
+	}
+
+	return len(p), nil
+}
+
+// Write implements [io.Writer].
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.count(p[:n])
+
+	return n, err //nolint:wrapcheck // passthrough
+}
+
+// Write implements [io.Writer].
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p) //nolint:wrapcheck // never fails
+}
+
+// Bytes returns a copy of the collected output.
+func (b *outputBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return bytes.Clone(b.buf.Bytes())
+}
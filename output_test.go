@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// recordHandler is a [slog.Handler] that collects records. It is safe
+	// for concurrent use.
+	recordHandler struct {
+		mu      sync.Mutex
+		records []slog.Record
+	}
+
+	// gatedHandler is a [slog.Handler] that, like a slow log destination,
+	// handles no record until its gate is closed, counting the records.
+	gatedHandler struct {
+		gate    chan struct{}
+		handled atomic.Int64
+	}
+
+	// brokenWriter is an [io.Writer] that fails like a pipe whose reader
+	// has gone away, counting the attempted writes.
+	brokenWriter struct {
+		writes int
+	}
+)
+
+func (*recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler     { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler          { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, r)
+	return nil
+}
+
+// count returns the number of records with the given message.
+func (h *recordHandler) count(msg string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var n int
+	for _, r := range h.records {
+		if r.Message == msg {
+			n++
+		}
+	}
+
+	return n
+}
+
+// lines returns the line attribute of each record, marking truncated lines
+// with a trailing "...".
+func (h *recordHandler) lines() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var lines []string
+	for _, r := range h.records {
+		var line string
+		r.Attrs(func(a slog.Attr) bool {
+			switch a.Key {
+			case "line":
+				line = a.Value.String()
+			case "truncated":
+				line += "..."
+			}
+
+			return true
+		})
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func (*gatedHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *gatedHandler) WithAttrs([]slog.Attr) slog.Handler     { return h }
+func (h *gatedHandler) WithGroup(string) slog.Handler          { return h }
+
+func (h *gatedHandler) Handle(context.Context, slog.Record) error {
+	<-h.gate
+	h.handled.Add(1)
+
+	return nil
+}
+
+func (w *brokenWriter) Write([]byte) (int, error) {
+	w.writes++
+	return 0, syscall.EPIPE
+}
+
+// TestLineLogger tests logging written output line by line.
+func TestLineLogger(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		writes       []string
+		maxLineBytes int
+		want         []string
+	}{
+		"no output": {
+			writes: nil,
+			want:   nil,
+		},
+		"single line": {
+			writes: []string{"hello\n"},
+			want:   []string{"hello"},
+		},
+		"multiple lines in one write": {
+			writes: []string{"a\nb\n\nc\n"},
+			want:   []string{"a", "b", "", "c"},
+		},
+		"line across writes": {
+			writes: []string{"hel", "lo\nwor", "ld\n"},
+			want:   []string{"hello", "world"},
+		},
+		"partial line flushed": {
+			writes: []string{"a\nb"},
+			want:   []string{"a", "b"},
+		},
+		"exactly max length": {
+			writes:       []string{"abcd\n"},
+			maxLineBytes: 4,
+			want:         []string{"abcd"},
+		},
+		"truncated line": {
+			writes:       []string{"abcdef\nxy\n"},
+			maxLineBytes: 4,
+			want:         []string{"abcd...", "xy"},
+		},
+		"truncated line across writes": {
+			writes:       []string{"ab", "cd", "ef", "gh\nxy"},
+			maxLineBytes: 4,
+			want:         []string{"abcd...", "xy"},
+		},
+		"truncated partial line": {
+			writes:       []string{"abcdef"},
+			maxLineBytes: 4,
+			want:         []string{"abcd..."},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			handler := &recordHandler{}
+			w := newLineLogger(slog.New(handler), "stdout", tt.maxLineBytes)
+			for _, s := range tt.writes {
+				n, err := w.Write([]byte(s))
+				require.NoError(t, err)
+				assert.Equal(t, len(s), n)
+			}
+			w.Flush()
+			assert.Equal(t, tt.want, handler.lines())
+		})
+	}
+}
+
+// TestLineLoggerLongLine tests bounding memory for a multi-megabyte line.
+func TestLineLoggerLongLine(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordHandler{}
+	w := newLineLogger(slog.New(handler), "stdout", 0)
+
+	chunk := bytes.Repeat([]byte("x"), 1024*1024)
+	for range 4 {
+		_, err := w.Write(chunk)
+		require.NoError(t, err)
+	}
+	_, err := w.Write([]byte("\nnext\n"))
+	require.NoError(t, err)
+
+	lines := handler.lines()
+	require.Len(t, lines, 2)
+	assert.Equal(t, strings.Repeat("x", defaultMaxLineBytes)+"...", lines[0])
+	assert.Equal(t, "next", lines[1])
+	assert.LessOrEqual(t, cap(w.buf), 2*defaultMaxLineBytes)
+}
+
+// TestLineLoggerBrokenDestination tests discarding output once the log
+// destination fails.
+func TestLineLoggerBrokenDestination(t *testing.T) {
+	t.Parallel()
+
+	dst := &brokenWriter{}
+	w := newLineLogger(slog.New(slog.NewTextHandler(dst, nil)), "stdout", 0)
+
+	for range 100 {
+		n, err := w.Write([]byte("line\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+	}
+	w.Flush()
+
+	assert.Equal(t, 1, dst.writes)
+	assert.Empty(t, w.buf)
+}
+
+// TestLineLoggerFlushInterval tests logging collected lines together once the
+// flush interval has passed.
+func TestLineLoggerFlushInterval(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordHandler{}
+	w := newLineLogger(slog.New(handler), "stdout", 0)
+	w.flushInterval = 50 * time.Millisecond
+
+	_, err := w.Write([]byte("a\nb\nc"))
+	require.NoError(t, err)
+	assert.Empty(t, handler.lines())
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"a\nb"}, handler.lines())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w.Flush()
+	assert.Equal(t, []string{"a\nb", "c"}, handler.lines())
+}
+
+// TestLineLoggerFlushOnExit tests logging collected lines right away on flush,
+// as when the command exits, and once they reach the maximum length.
+func TestLineLoggerFlushOnExit(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordHandler{}
+	w := newLineLogger(slog.New(handler), "stdout", 8)
+	w.flushInterval = time.Hour
+
+	_, err := w.Write([]byte("abcd\nefgh\nab\ncd\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcd\nefgh"}, handler.lines())
+
+	w.Flush()
+	assert.Equal(t, []string{"abcd\nefgh", "ab\ncd"}, handler.lines())
+	assert.Nil(t, w.timer)
+}
+
+// TestOutputQueue tests bounding the records queued for a slow log handler
+// under a flood of output, waiting for room or dropping records.
+func TestOutputQueue(t *testing.T) {
+	t.Parallel()
+
+	const lines = 10 * outputQueueLen
+
+	tests := map[string]struct {
+		drop bool
+	}{
+		"block": {drop: false},
+		"drop":  {drop: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := &gatedHandler{gate: make(chan struct{})}
+			queue := newOutputQueue(tt.drop)
+			w := newLineLogger(slog.New(handler), "stdout", 0)
+			w.queue = queue
+
+			var written atomic.Int64
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+
+				for range lines {
+					_, _ = w.Write([]byte("line\n"))
+					written.Add(1)
+				}
+			}()
+
+			if tt.drop {
+				// Never waits for the handler.
+				<-done
+			} else {
+				// One record is being handled and the queue is
+				// full, so the next write waits.
+				require.Eventually(t, func() bool {
+					return written.Load() == outputQueueLen+1
+				}, 5*time.Second, time.Millisecond)
+				time.Sleep(50 * time.Millisecond)
+				assert.Equal(t, int64(outputQueueLen+1), written.Load())
+			}
+			assert.LessOrEqual(t, len(queue.records), outputQueueLen)
+
+			close(handler.gate)
+			<-done
+			w.Flush()
+			dropped := queue.close()
+
+			assert.Equal(t, int64(lines), handler.handled.Load()+dropped)
+			if tt.drop {
+				assert.Positive(t, dropped)
+				assert.LessOrEqual(t, handler.handled.Load(), int64(outputQueueLen+1))
+			} else {
+				assert.Zero(t, dropped)
+			}
+		})
+	}
+}
+
+// TestOutputLimit tests dropping output beyond the limit across streams and
+// logging that once.
+func TestOutputLimit(t *testing.T) {
+	t.Parallel()
+
+	handler := &recordHandler{}
+	limit := newOutputLimit(slog.New(handler), 6)
+	var stdout, stderr bytes.Buffer
+	outw, errw := limit.writer(&stdout), limit.writer(&stderr)
+
+	for _, write := range []struct {
+		w io.Writer
+		s string
+	}{
+		{outw, "abcd"},
+		{errw, "efgh"},
+		{outw, "ijkl"},
+		{errw, "mnop"},
+	} {
+		n, err := write.w.Write([]byte(write.s))
+		require.NoError(t, err)
+		assert.Equal(t, len(write.s), n)
+	}
+
+	assert.Equal(t, "abcd", stdout.String())
+	assert.Equal(t, "ef", stderr.String())
+	require.Len(t, handler.records, 1)
+	assert.Equal(t, "output truncated", handler.records[0].Message)
+}
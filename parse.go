@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"iter"
 	"slices"
 	"strconv"
@@ -13,6 +16,24 @@ func parseArgs(args []string) [][]string {
 	return slices.Collect(slicesSplitSeq(args, "--"))
 }
 
+// instanceArgs returns the arguments of each instance. Arguments before the
+// first "--" are prepended to every instance. Without "--" separators, a
+// single instance receives all arguments.
+func instanceArgs(args []string) [][]string {
+	sections := parseArgs(args)
+	globalArgs := sections[0] // parseArgs always returns at least one element
+	if len(sections) == 1 {
+		return [][]string{globalArgs}
+	}
+
+	argSets := make([][]string, 0, len(sections)-1)
+	for _, section := range sections[1:] {
+		argSets = append(argSets, slices.Concat(globalArgs, section))
+	}
+
+	return argSets
+}
+
 // slicesSplitSeq returns an iterator over sub-slices of s split around the
 // separator element. It behaves like [strings.SplitSeq] but operates on slices
 // of a comparable type.
@@ -31,9 +52,11 @@ func slicesSplitSeq[E comparable](s []E, sep E) iter.Seq[[]E] {
 	}
 }
 
-// parseInts parses a comma-separated list of integers.
-func parseInts(s string) ([]int, error) {
-	var ints []int
+// parseIndexes parses a comma-separated list of instance indexes. Each
+// element is an integer or one of the instance labels, which labels maps to
+// their indexes.
+func parseIndexes(s string, labels map[string]int) ([]int, error) {
+	var indexes []int
 
 	for part := range strings.SplitSeq(s, ",") {
 		part = strings.TrimSpace(part)
@@ -41,13 +64,108 @@ func parseInts(s string) ([]int, error) {
 			continue
 		}
 
+		if index, ok := labels[part]; ok {
+			indexes = append(indexes, index)
+			continue
+		}
+
 		n, err := strconv.Atoi(part)
 		if err != nil {
-			return nil, fmt.Errorf("parse int: %w", err)
+			return nil, fmt.Errorf("parse index: not an index or instance label: %q", part)
+		}
+
+		indexes = append(indexes, n)
+	}
+
+	return indexes, nil
+}
+
+// readCommandLines reads one command line per line from r and splits each
+// into words. Blank lines and lines starting with "#" are skipped.
+func readCommandLines(r io.Reader) ([][]string, error) {
+	var lines [][]string
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, err := splitCommandLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
 		}
 
-		ints = append(ints, n)
+		lines = append(lines, words)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read command lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+// splitCommandLine splits a command line into words separated by unquoted
+// whitespace. Like a POSIX shell, single quotes preserve their content
+// literally, double quotes allow backslash escapes of '"' and '\', and an
+// unquoted backslash escapes the following character. No expansion is
+// performed.
+func splitCommandLine(line string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+	for _, r := range line {
+		switch {
+		case escape:
+			if quote == '"' && r != '"' && r != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escape = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escape = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escape {
+		return nil, errors.New("unterminated escape")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
 	}
 
-	return ints, nil
+	return words, nil
 }
@@ -2,11 +2,46 @@ package main
 
 import (
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// TestInstanceArgs tests splitting arguments into per-instance arguments.
+func TestInstanceArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		args []string
+		want [][]string
+	}{
+		"nil input": {
+			args: nil,
+			want: [][]string{nil},
+		},
+		"no separator": {
+			args: []string{"arg1", "-flag1"},
+			want: [][]string{{"arg1", "-flag1"}},
+		},
+		"global args": {
+			args: []string{"-v", "--", "arg1", "--", "arg2"},
+			want: [][]string{{"-v", "arg1"}, {"-v", "arg2"}},
+		},
+		"trailing separator": {
+			args: []string{"--", "arg1", "--"},
+			want: [][]string{{"arg1"}, nil},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, instanceArgs(tt.args))
+		})
+	}
+}
+
 // TestSlicesSplitSeq tests splitting slices around a separator element.
 func TestSlicesSplitSeq(t *testing.T) {
 	t.Parallel()
@@ -62,8 +97,8 @@ func TestSlicesSplitSeq(t *testing.T) {
 	}
 }
 
-// TestParseInts tests parsing comma-separated integers.
-func TestParseInts(t *testing.T) {
+// TestParseIndexes tests parsing comma-separated indexes and labels.
+func TestParseIndexes(t *testing.T) {
 	t.Parallel()
 
 	tests := map[string]struct {
@@ -96,7 +131,12 @@ func TestParseInts(t *testing.T) {
 			want:    []int{0, 2},
 			wantErr: assert.NoError,
 		},
-		"non-numeric": {
+		"labels": {
+			input:   "web, 1,worker",
+			want:    []int{0, 1, 2},
+			wantErr: assert.NoError,
+		},
+		"unknown label": {
 			input:   "a",
 			want:    nil,
 			wantErr: assert.Error,
@@ -106,7 +146,75 @@ func TestParseInts(t *testing.T) {
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			got, err := parseInts(tt.input)
+			got, err := parseIndexes(tt.input, map[string]int{"web": 0, "worker": 2})
+			assert.Equal(t, tt.want, got)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+// TestSplitCommandLine tests splitting command lines into words.
+func TestSplitCommandLine(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		line    string
+		want    []string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"empty":               {line: "", want: nil, wantErr: assert.NoError},
+		"whitespace":          {line: " \t ", want: nil, wantErr: assert.NoError},
+		"words":               {line: "echo  hello\tworld", want: []string{"echo", "hello", "world"}, wantErr: assert.NoError},
+		"single quotes":       {line: `echo 'a "b" \c'`, want: []string{"echo", `a "b" \c`}, wantErr: assert.NoError},
+		"double quotes":       {line: `echo "a 'b' \"c\" \\ \d"`, want: []string{"echo", `a 'b' "c" \ \d`}, wantErr: assert.NoError},
+		"escaped space":       {line: `echo a\ b`, want: []string{"echo", "a b"}, wantErr: assert.NoError},
+		"empty quotes":        {line: `echo ''`, want: []string{"echo", ""}, wantErr: assert.NoError},
+		"adjacent quotes":     {line: `echo a'b'"c"`, want: []string{"echo", "abc"}, wantErr: assert.NoError},
+		"unterminated quote":  {line: `echo 'a`, want: nil, wantErr: assert.Error},
+		"unterminated escape": {line: `echo a\`, want: nil, wantErr: assert.Error},
+		"unterminated dquote": {line: `echo "a`, want: nil, wantErr: assert.Error},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := splitCommandLine(tt.line)
+			assert.Equal(t, tt.want, got)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+// TestReadCommandLines tests reading command lines.
+func TestReadCommandLines(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input   string
+		want    [][]string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"empty": {
+			input:   "",
+			want:    nil,
+			wantErr: assert.NoError,
+		},
+		"comments and blank lines": {
+			input:   "# comment\n\necho a\n  # indented comment\nsleep 'b c'\n",
+			want:    [][]string{{"echo", "a"}, {"sleep", "b c"}},
+			wantErr: assert.NoError,
+		},
+		"invalid line": {
+			input:   "echo a\necho 'b\n",
+			want:    nil,
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := readCommandLines(strings.NewReader(tt.input))
 			assert.Equal(t, tt.want, got)
 			tt.wantErr(t, err)
 		})
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reaper reaps zombie descendants reparented to this process as a child
+// subreaper. Managed children are left to their [Cmd.Wait].
+type reaper struct {
+	logger *slog.Logger
+	// wasSubreaper is whether this process was a child subreaper already
+	// before the reaper made it one.
+	wasSubreaper bool
+
+	// mu serializes starting managed children with reaping, so a managed
+	// child that exits immediately is registered before it can be reaped.
+	mu      sync.Mutex
+	managed map[int]bool
+}
+
+// newReaper marks this process as a child subreaper and returns a reaper.
+func newReaper(logger *slog.Logger) (*reaper, error) {
+	wasSubreaper, err := isSubreaper()
+	if err != nil {
+		return nil, fmt.Errorf("get subreaper: %w", err)
+	}
+
+	if err := setSubreaper(true); err != nil {
+		return nil, fmt.Errorf("set subreaper: %w", err)
+	}
+
+	return &reaper{logger: logger, wasSubreaper: wasSubreaper, managed: make(map[int]bool)}, nil
+}
+
+// close reaps the remaining orphaned zombies and restores whether this process
+// is a child subreaper, so orphans are reparented to init again afterwards.
+func (r *reaper) close(ctx context.Context) {
+	r.reap(ctx)
+
+	if r.wasSubreaper {
+		return
+	}
+
+	if err := setSubreaper(false); err != nil {
+		r.logger.WarnContext(ctx, "unset subreaper", "error", err)
+	}
+}
+
+// run reaps orphaned zombies on every SIGCHLD until ctx is done.
+func (r *reaper) run(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+	defer signal.Stop(sigs)
+
+	for {
+		r.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigs:
+		}
+	}
+}
+
+// reap waits for all zombie children that are not managed.
+func (r *reaper) reap(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pids, err := zombieChildren("/proc", os.Getpid())
+	if err != nil {
+		r.logger.WarnContext(ctx, "listing zombie children", "error", err)
+		return
+	}
+
+	for _, pid := range pids {
+		if r.managed[pid] {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
+			r.logger.WarnContext(ctx, "reaping orphan", "pid", pid, "error", err)
+			continue
+		}
+
+		r.logger.DebugContext(ctx, "reaped orphan", "pid", pid, "status", int(status))
+	}
+}
+
+// start starts cmd and registers its process as managed.
+func (r *reaper) start(cmd Cmd) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err //nolint:wrapcheck // wrapped by caller
+	}
+
+	if pid := cmd.Pid(); pid > 0 {
+		r.managed[pid] = true
+	}
+
+	return nil
+}
+
+// done unregisters a managed process after it has been waited for.
+func (r *reaper) done(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.managed, pid)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// PR_SET_CHILD_SUBREAPER and PR_GET_CHILD_SUBREAPER from linux/prctl.h.
+const (
+	prSetChildSubreaper = 36
+	prGetChildSubreaper = 37
+)
+
+// setSubreaper sets whether this process is a child subreaper, to which
+// orphaned descendants are reparented rather than to init.
+func setSubreaper(enabled bool) error {
+	var arg uintptr
+	if enabled {
+		arg = 1
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, arg, 0); errno != 0 {
+		return fmt.Errorf("prctl: %w", errno)
+	}
+
+	return nil
+}
+
+// isSubreaper reports whether this process is a child subreaper.
+func isSubreaper() (bool, error) {
+	var enabled int32
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prGetChildSubreaper,
+		uintptr(unsafe.Pointer(&enabled)), 0) // #nosec G103 -- enabled outlives the call
+	if errno != 0 {
+		return false, fmt.Errorf("prctl: %w", errno)
+	}
+
+	return enabled != 0, nil
+}
+
+// zombieChildren returns the PIDs of zombie processes in procDir whose parent
+// is ppid.
+func zombieChildren(procDir string, ppid int) ([]int, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("read proc: %w", err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // Not a process.
+		}
+
+		stat, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "stat"))
+		if err != nil {
+			continue // Exited and reaped meanwhile.
+		}
+
+		state, parent, ok := parseProcStat(stat)
+		if ok && state == 'Z' && parent == ppid {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// parseProcStat returns the state and parent PID from the contents of a
+// /proc/<pid>/stat file: "pid (comm) state ppid ...". The command name may
+// contain spaces and parentheses, so parsing starts after the last ')'.
+func parseProcStat(stat []byte) (byte, int, bool) {
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, 0, false
+	}
+
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return 0, 0, false
+	}
+
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return fields[0][0], ppid, true
+}
+
+// processZombie reports whether the process pid has exited but was not reaped
+// yet.
+func processZombie(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+
+	state, _, ok := parseProcStat(stat)
+
+	return ok && state == 'Z'
+}
+
+// parentPID returns the parent process ID of the process pid, if it exists.
+func parentPID(pid int) (int, bool) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, false
+	}
+
+	_, ppid, ok := parseProcStat(stat)
+
+	return ppid, ok
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseProcStat tests parsing state and parent PID from /proc stat files.
+func TestParseProcStat(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		stat      string
+		wantState byte
+		wantPPID  int
+		wantOK    bool
+	}{
+		"running": {
+			stat:      "42 (sleep) S 1 42 42 0 -1",
+			wantState: 'S',
+			wantPPID:  1,
+			wantOK:    true,
+		},
+		"zombie": {
+			stat:      "43 (sh) Z 7 43 43 0 -1",
+			wantState: 'Z',
+			wantPPID:  7,
+			wantOK:    true,
+		},
+		"comm with spaces and parens": {
+			stat:      "44 (a (b) c) Z 7 44",
+			wantState: 'Z',
+			wantPPID:  7,
+			wantOK:    true,
+		},
+		"missing comm": {
+			stat:   "45 S 1",
+			wantOK: false,
+		},
+		"truncated": {
+			stat:   "46 (sh) Z",
+			wantOK: false,
+		},
+		"invalid ppid": {
+			stat:   "47 (sh) Z x",
+			wantOK: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			state, ppid, ok := parseProcStat([]byte(tt.stat))
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantState, state)
+			assert.Equal(t, tt.wantPPID, ppid)
+		})
+	}
+}
+
+// TestZombieChildren tests listing zombie children from a proc directory.
+func TestZombieChildren(t *testing.T) {
+	t.Parallel()
+
+	procDir := t.TempDir()
+	for pid, stat := range map[string]string{
+		"10":   "10 (child) Z 7 10",
+		"11":   "11 (child) S 7 11",
+		"12":   "12 (other) Z 8 12",
+		"self": "13 (self) Z 7 13",
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(procDir, pid), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(stat), 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(procDir, "14"), 0o700)) // No stat file.
+
+	pids, err := zombieChildren(procDir, 7)
+	require.NoError(t, err)
+	assert.Equal(t, []int{10}, pids)
+
+	_, err = zombieChildren(filepath.Join(procDir, "nonexistent"), 7)
+	require.Error(t, err)
+}
+
+// TestWithSubreaper tests reaping a grandchild orphaned by an instance, and
+// no longer being a subreaper once the group stopped.
+//
+//nolint:paralleltest // The reaper reaps any zombie child of the test process.
+func TestWithSubreaper(t *testing.T) {
+	wasSubreaper, err := isSubreaper()
+	require.NoError(t, err)
+	require.False(t, wasSubreaper)
+
+	// The subshell forks the grandchild and exits, orphaning it, while the
+	// instance keeps running until the grandchild has exited.
+	pidFile := filepath.Join(t.TempDir(), "grandchild.pid")
+	group, err := New("sh",
+		WithArgs([]string{"-c", `(sleep 0.5 & echo $! >"$0"); sleep 3`, pidFile}),
+		WithSubreaper(true),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+
+	var pid int
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	// Reparented once the subshell has exited.
+	require.Eventually(t, func() bool {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return false
+		}
+		_, ppid, ok := parseProcStat(stat)
+
+		return ok && ppid == os.Getpid()
+	}, 5*time.Second, time.Millisecond)
+
+	// Reaped, so gone from /proc rather than left as a zombie.
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+		return errors.Is(err, fs.ErrNotExist)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, group.Instances[0].Running())
+
+	cancel()
+	require.NoError(t, <-done)
+
+	isSub, err := isSubreaper()
+	require.NoError(t, err)
+	assert.False(t, isSub)
+}
+
+// TestWithSubreaperFinalizer tests that the reaper leaves the finalizer to be
+// waited for, so its exit status is reported.
+//
+//nolint:paralleltest // The reaper reaps any zombie child of the test process.
+func TestWithSubreaperFinalizer(t *testing.T) {
+	for range 200 {
+		group, err := New("true",
+			WithSubreaper(true),
+			WithFinalizer("sh", "-c", "exit 5"),
+		)
+		require.NoError(t, err)
+
+		err = group.Run(t.Context())
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 5, exitErr.ExitCode())
+	}
+}
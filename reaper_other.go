@@ -0,0 +1,32 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// setSubreaper is only supported on Linux.
+func setSubreaper(bool) error {
+	return errors.ErrUnsupported
+}
+
+// isSubreaper is only supported on Linux.
+func isSubreaper() (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// zombieChildren is only supported on Linux.
+func zombieChildren(string, int) ([]int, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// processZombie is only supported on Linux, where zombies can be told apart.
+func processZombie(int) bool {
+	return false
+}
+
+// parentPID is only supported on Linux.
+func parentPID(int) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// restartLimiter limits the restarts of all instances of a group to a number
+// per sliding window.
+type restartLimiter struct {
+	logger *slog.Logger
+	clock  clock
+	limit  int
+	window time.Duration
+
+	mu       sync.Mutex
+	restarts []time.Time
+	paused   bool
+}
+
+// newRestartLimiter returns a limiter allowing limit restarts per window, as
+// told by clock.
+func newRestartLimiter(logger *slog.Logger, clock clock, limit int, window time.Duration) *restartLimiter {
+	return &restartLimiter{logger: logger, clock: clock, limit: limit, window: window}
+}
+
+// wait waits until a restart is within the limit and records it. It returns
+// the context error if ctx is done first.
+func (l *restartLimiter) wait(ctx context.Context) error {
+	for {
+		delay, pausing := l.reserve(l.clock.Now())
+		if delay <= 0 {
+			return nil
+		}
+
+		if pausing {
+			l.logger.WarnContext(ctx, "group restart limit reached, pausing restarts",
+				"limit", l.limit, "window", l.window, "pause", delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.clock.After(delay):
+		}
+	}
+}
+
+// reserve records a restart at now if it is within the limit and returns 0.
+// Otherwise, it returns how long to wait before trying again and whether this
+// starts a pause of restarts.
+func (l *restartLimiter) reserve(now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	for len(l.restarts) > 0 && !l.restarts[0].After(cutoff) {
+		l.restarts = l.restarts[1:]
+	}
+
+	if len(l.restarts) < l.limit {
+		l.restarts = append(l.restarts, now)
+		l.paused = false
+
+		return 0, false
+	}
+
+	pausing := !l.paused
+	l.paused = true
+
+	return l.restarts[0].Add(l.window).Sub(now), pausing
+}
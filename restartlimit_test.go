@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRestartLimiterReserve tests limiting restarts per sliding window.
+func TestRestartLimiterReserve(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	l := newRestartLimiter(nil, realClock{}, 2, time.Minute)
+
+	delay, pausing := l.reserve(start)
+	assert.Zero(t, delay)
+	assert.False(t, pausing)
+
+	delay, _ = l.reserve(start.Add(10 * time.Second))
+	assert.Zero(t, delay)
+
+	delay, pausing = l.reserve(start.Add(20 * time.Second))
+	assert.Equal(t, 40*time.Second, delay)
+	assert.True(t, pausing)
+
+	delay, pausing = l.reserve(start.Add(30 * time.Second))
+	assert.Equal(t, 30*time.Second, delay)
+	assert.False(t, pausing, "pause already started")
+
+	delay, pausing = l.reserve(start.Add(time.Minute))
+	assert.Zero(t, delay)
+	assert.False(t, pausing)
+}
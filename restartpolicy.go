@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstanceResult describes an exit of the command of a watched instance, as
+// passed to the function set by [WithShouldRestart].
+type InstanceResult struct {
+	// ExitCode is the exit code of the process, after ExitCodeMap, or -1
+	// if it was terminated by a signal or did not report one.
+	ExitCode int
+	// Signal is the name of the signal that terminated the process, or
+	// empty if there is none.
+	Signal string
+	// Err is the exit error of the process, or nil for a clean exit.
+	Err error
+	// Duration is how long the process ran.
+	Duration time.Duration
+	// Attempt is the number of consecutive restarts including the one
+	// being decided on, starting at 1.
+	Attempt int
+}
+
+// RestartPolicy decides whether and when a watched instance is restarted
+// automatically. Explicit restarts are not subject to it. The zero value
+// restarts indefinitely after a fixed delay of 1s.
+type RestartPolicy struct {
+	// MaxAttempts, if positive, is how many consecutive restarts are made
+	// before the instance is given up on.
+	MaxAttempts int
+	// InitialDelay is the delay before the first restart. If zero, 1s is
+	// used.
+	InitialDelay time.Duration
+	// MaxDelay, if positive, caps the delay.
+	MaxDelay time.Duration
+	// Multiplier, if greater than 1, is the factor by which the delay
+	// grows with every consecutive restart.
+	Multiplier float64
+	// Jitter, if positive, is the fraction of the delay by which it is
+	// randomly increased, so instances failing together do not restart in
+	// lockstep.
+	Jitter float64
+	// ResetAfter, if positive, is how long a process must run for the
+	// next restart to count as the first again.
+	ResetAfter time.Duration
+}
+
+// NextDelay returns the delay before the restart of a process that ran for
+// uptime, where attempt is the number of consecutive restarts including this
+// one, starting at 1. It returns false if the instance must not be restarted.
+func (p RestartPolicy) NextDelay(attempt int, uptime time.Duration) (time.Duration, bool) {
+	if p.resets(uptime) {
+		attempt = 1
+	}
+
+	if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := float64(cmp.Or(max(p.InitialDelay, 0), cmdRestartDelay))
+	if p.Multiplier > 1 && attempt > 1 {
+		delay *= math.Pow(p.Multiplier, float64(attempt-1))
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, float64(p.MaxDelay))
+	}
+	if p.Jitter > 0 {
+		// #nosec G404 -- jitter needs no unpredictable randomness
+		delay += delay * p.Jitter * rand.Float64()
+	}
+	if delay >= math.MaxInt64 {
+		// Converting would overflow.
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(delay), true
+}
+
+// String returns the settings of the policy that apply, such as
+// "delay=1s,multiplier=2,max_delay=1m0s".
+func (p RestartPolicy) String() string {
+	settings := []string{"delay=" + cmp.Or(max(p.InitialDelay, 0), cmdRestartDelay).String()}
+	if p.Multiplier > 1 {
+		settings = append(settings, "multiplier="+strconv.FormatFloat(p.Multiplier, 'g', -1, 64))
+	}
+	if p.MaxDelay > 0 {
+		settings = append(settings, "max_delay="+p.MaxDelay.String())
+	}
+	if p.Jitter > 0 {
+		settings = append(settings, "jitter="+strconv.FormatFloat(p.Jitter, 'g', -1, 64))
+	}
+	if p.MaxAttempts > 0 {
+		settings = append(settings, "max_attempts="+strconv.Itoa(p.MaxAttempts))
+	}
+	if p.ResetAfter > 0 {
+		settings = append(settings, "reset_after="+p.ResetAfter.String())
+	}
+
+	return strings.Join(settings, ",")
+}
+
+// resets reports whether a process that ran for uptime resets the count of
+// consecutive restarts.
+func (p RestartPolicy) resets(uptime time.Duration) bool {
+	return p.ResetAfter > 0 && uptime >= p.ResetAfter
+}
+
+// nextRestart returns the delay before restarting the instance after a process
+// that ran for uptime exited with err, where attempt is the number of
+// consecutive restarts including this one. It is decided by ShouldRestart if
+// set, or else by RestartPolicy. It returns the reason if the instance must not
+// be restarted. Once ctx is done, ShouldRestart is not called, since the
+// instance is stopped anyway.
+func (i *Instance) nextRestart(ctx context.Context, err error, uptime time.Duration, attempt int) (time.Duration, error) {
+	if i.ShouldRestart == nil {
+		delay, ok := i.RestartPolicy.NextDelay(attempt, uptime)
+		if !ok {
+			return 0, errRestartAttempts
+		}
+
+		return delay, nil
+	}
+
+	if ctx.Err() != nil {
+		return 0, nil
+	}
+
+	result := InstanceResult{ExitCode: exitCode(err), Err: err, Duration: uptime, Attempt: attempt}
+	if sig, ok := exitSignal(err); ok {
+		result.Signal = signalName(sig)
+	}
+
+	restart, delay := i.ShouldRestart(i, result)
+	if !restart {
+		return 0, errRestartDeclined
+	}
+
+	return max(delay, 0), nil
+}
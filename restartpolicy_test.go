@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRestartPolicyString tests describing the settings of a policy.
+func TestRestartPolicyString(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		policy RestartPolicy
+		want   string
+	}{
+		"zero value": {
+			want: "delay=1s",
+		},
+		"all settings": {
+			policy: RestartPolicy{
+				MaxAttempts:  5,
+				InitialDelay: 100 * time.Millisecond,
+				MaxDelay:     time.Minute,
+				Multiplier:   1.5,
+				Jitter:       0.1,
+				ResetAfter:   10 * time.Minute,
+			},
+			want: "delay=100ms,multiplier=1.5,max_delay=1m0s,jitter=0.1,max_attempts=5,reset_after=10m0s",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.policy.String())
+		})
+	}
+}
+
+// TestRestartPolicyNextDelay tests computing the delay before a restart.
+func TestRestartPolicyNextDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		policy    RestartPolicy
+		attempt   int
+		uptime    time.Duration
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		"zero value": {
+			attempt:   5,
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		"initial delay": {
+			policy:    RestartPolicy{InitialDelay: 100 * time.Millisecond},
+			attempt:   1,
+			wantDelay: 100 * time.Millisecond,
+			wantOK:    true,
+		},
+		"multiplier": {
+			policy:    RestartPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2},
+			attempt:   4,
+			wantDelay: 800 * time.Millisecond,
+			wantOK:    true,
+		},
+		"max delay": {
+			policy:    RestartPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: 500 * time.Millisecond},
+			attempt:   4,
+			wantDelay: 500 * time.Millisecond,
+			wantOK:    true,
+		},
+		"huge attempt": {
+			policy:    RestartPolicy{Multiplier: 10, MaxDelay: time.Hour},
+			attempt:   1000,
+			wantDelay: time.Hour,
+			wantOK:    true,
+		},
+		"huge attempt without max delay": {
+			policy:    RestartPolicy{Multiplier: 10},
+			attempt:   1000,
+			wantDelay: math.MaxInt64,
+			wantOK:    true,
+		},
+		"within max attempts": {
+			policy:    RestartPolicy{MaxAttempts: 3},
+			attempt:   3,
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		"max attempts exceeded": {
+			policy:  RestartPolicy{MaxAttempts: 3},
+			attempt: 4,
+			wantOK:  false,
+		},
+		"reset after uptime": {
+			policy:    RestartPolicy{MaxAttempts: 3, Multiplier: 2, ResetAfter: time.Minute},
+			attempt:   4,
+			uptime:    time.Minute,
+			wantDelay: time.Second,
+			wantOK:    true,
+		},
+		"uptime below reset": {
+			policy:  RestartPolicy{MaxAttempts: 3, ResetAfter: time.Minute},
+			attempt: 4,
+			uptime:  time.Second,
+			wantOK:  false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			delay, ok := tt.policy.NextDelay(tt.attempt, tt.uptime)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantDelay, delay)
+		})
+	}
+}
+
+// TestRestartPolicyNextDelayJitter tests randomly increasing the delay by up
+// to the jitter fraction.
+func TestRestartPolicyNextDelayJitter(t *testing.T) {
+	t.Parallel()
+
+	policy := RestartPolicy{InitialDelay: time.Second, Jitter: 0.5}
+	for range 100 {
+		delay, ok := policy.NextDelay(1, 0)
+		assert.True(t, ok)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 1500*time.Millisecond)
+	}
+}
+
+// TestWithShouldRestart tests restarting a watched instance only while it exits
+// with code 42, with a delay growing with every attempt.
+func TestWithShouldRestart(t *testing.T) {
+	t.Parallel()
+
+	// The command exits with 42 on its first two runs, then with 1.
+	counter := filepath.Join(t.TempDir(), "runs")
+	script := `n=$(cat "$0" 2>/dev/null || echo 0); n=$((n+1)); echo $n >"$0"; [ $n -lt 3 ] && exit 42; exit 1`
+
+	var (
+		mu      sync.Mutex
+		results []InstanceResult
+	)
+	group, err := New("sh",
+		WithArgs([]string{"-c", script, counter}),
+		WithWatch("all"),
+		WithShouldRestart(func(_ *Instance, result InstanceResult) (bool, time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			results = append(results, result)
+
+			return result.ExitCode == 42, time.Duration(result.Attempt) * 100 * time.Millisecond
+		}),
+	)
+	require.NoError(t, err)
+	instance := group.Instances[0]
+	require.NotNil(t, instance.ShouldRestart)
+
+	require.EqualError(t, group.Run(t.Context()), "exit status 1")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, results, 3)
+	for idx, result := range results {
+		assert.Equal(t, idx+1, result.Attempt)
+		assert.Empty(t, result.Signal)
+		assert.GreaterOrEqual(t, result.Duration, time.Duration(0))
+		require.Error(t, result.Err)
+	}
+	assert.Equal(t, 42, results[0].ExitCode)
+	assert.Equal(t, 42, results[1].ExitCode)
+	assert.Equal(t, 1, results[2].ExitCode)
+
+	// Each restart waited for the delay of its attempt.
+	stats := instance.Stats()
+	assert.Equal(t, 3, stats.Starts)
+	require.Len(t, stats.Exits, 3)
+	for idx := range 2 {
+		gap := stats.Exits[idx+1].StartedAt.Sub(stats.Exits[idx].Time)
+		assert.GreaterOrEqual(t, gap, time.Duration(idx+1)*100*time.Millisecond, "restart %d", idx+1)
+	}
+}
+
+// TestWithWarmup tests that a process exiting within the warmup period keeps
+// the restart backoff growing.
+func TestWithWarmup(t *testing.T) {
+	t.Parallel()
+
+	group, err := New("sleep",
+		WithArgs([]string{"0.2"}),
+		WithWatch("all"),
+		WithWarmup(time.Hour),
+		WithRestartPolicyObject(RestartPolicy{InitialDelay: time.Minute, Multiplier: 2, ResetAfter: time.Second}),
+	)
+	require.NoError(t, err)
+	instance := group.Instances[0]
+	assert.Equal(t, time.Hour, instance.RestartPolicy.ResetAfter)
+
+	clk := newFakeClock()
+	instance.clk = clk
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- instance.Run(ctx) }()
+
+	// waitDelay lets the process of the given start run for 2s, longer than
+	// the ResetAfter of the policy but within the warmup, and waits until the
+	// instance waits for its restart delay.
+	waitDelay := func(starts int) {
+		t.Helper()
+
+		require.Eventually(t, func() bool {
+			return instance.Stats().Starts == starts && instance.Running()
+		}, 5*time.Second, time.Millisecond)
+		clk.Advance(2 * time.Second)
+		require.Eventually(t, func() bool {
+			return clk.Waiters() == 1
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	waitDelay(1)
+	for starts, delay := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		clk.Advance(delay - time.Second)
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, starts+1, instance.Stats().Starts, "restarted before %v", delay)
+
+		clk.Advance(time.Second)
+		waitDelay(starts + 2)
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
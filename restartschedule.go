@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// RestartSchedule lists the daily time ranges in which a watched instance
+	// may be restarted automatically. An empty schedule allows restarts at
+	// any time.
+	RestartSchedule []ClockRange
+
+	// ClockRange is a daily range of local clock times from Start,
+	// inclusive, to End, exclusive, each given as the time since midnight.
+	// A range ending before it starts spans midnight, and a range ending
+	// when it starts spans the whole day.
+	ClockRange struct {
+		Start time.Duration
+		End   time.Duration
+	}
+)
+
+// parseRestartSchedule parses a comma-separated list of HH:MM-HH:MM clock
+// ranges, such as "22:00-06:00,12:00-13:00".
+func parseRestartSchedule(s string) (RestartSchedule, error) {
+	var schedule RestartSchedule
+
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("parse clock range %q: missing \"-\"", part)
+		}
+
+		var clockRange ClockRange
+		for _, clock := range []struct {
+			s string
+			d *time.Duration
+		}{{start, &clockRange.Start}, {end, &clockRange.End}} {
+			t, err := time.Parse("15:04", strings.TrimSpace(clock.s))
+			if err != nil {
+				return nil, fmt.Errorf("parse clock range %q: %w", part, err)
+			}
+			*clock.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+
+		schedule = append(schedule, clockRange)
+	}
+
+	return schedule, nil
+}
+
+// Next returns the earliest time at or after t within the schedule. Clock
+// times are those of the location of t, so on days with a daylight saving
+// time transition, ranges still start and end at their clock times.
+func (s RestartSchedule) Next(t time.Time) time.Time {
+	if len(s) == 0 {
+		return t
+	}
+
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	var next time.Time
+	for _, r := range s {
+		if r.contains(clock) {
+			return t
+		}
+
+		start := clockTime(t, 0, r.Start)
+		if r.Start < clock {
+			start = clockTime(t, 1, r.Start)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+
+	return next
+}
+
+// clockTime returns the time at the given clock time, as the time since
+// midnight, days after the day of t in the location of t.
+func clockTime(t time.Time, days int, clock time.Duration) time.Time {
+	year, month, day := t.Date()
+
+	return time.Date(year, month, day+days,
+		int(clock/time.Hour), int(clock%time.Hour/time.Minute), int(clock%time.Minute/time.Second),
+		int(clock%time.Second), t.Location())
+}
+
+// contains reports whether the time since midnight is within the range.
+func (r ClockRange) contains(clock time.Duration) bool {
+	switch {
+	case r.Start == r.End:
+		return true
+	case r.Start < r.End:
+		return r.Start <= clock && clock < r.End
+	default:
+		return clock >= r.Start || clock < r.End
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // for the daylight saving time transitions
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseRestartSchedule tests parsing lists of clock ranges.
+func TestParseRestartSchedule(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		s       string
+		want    RestartSchedule
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"empty": {
+			s:       "",
+			want:    nil,
+			wantErr: assert.NoError,
+		},
+		"single": {
+			s:       "01:30-02:45",
+			want:    RestartSchedule{{Start: 90 * time.Minute, End: 165 * time.Minute}},
+			wantErr: assert.NoError,
+		},
+		"multiple": {
+			s: "22:00-06:00, 12:00-13:00",
+			want: RestartSchedule{
+				{Start: 22 * time.Hour, End: 6 * time.Hour},
+				{Start: 12 * time.Hour, End: 13 * time.Hour},
+			},
+			wantErr: assert.NoError,
+		},
+		"missing end": {
+			s:       "22:00",
+			wantErr: assert.Error,
+		},
+		"invalid clock": {
+			s:       "22:00-25:00",
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseRestartSchedule(tt.s)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestRestartScheduleNext tests finding the next time within a schedule.
+func TestRestartScheduleNext(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	at := func(clock string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("15:04", clock)
+		require.NoError(t, err)
+
+		return day.Add(time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute)
+	}
+
+	tests := map[string]struct {
+		schedule RestartSchedule
+		t        time.Time
+		want     time.Time
+	}{
+		"empty": {
+			t:    at("15:00"),
+			want: at("15:00"),
+		},
+		"inside": {
+			schedule: RestartSchedule{{Start: 12 * time.Hour, End: 13 * time.Hour}},
+			t:        at("12:30"),
+			want:     at("12:30"),
+		},
+		"at end": {
+			schedule: RestartSchedule{{Start: 12 * time.Hour, End: 13 * time.Hour}},
+			t:        at("13:00"),
+			want:     at("12:00").AddDate(0, 0, 1),
+		},
+		"before": {
+			schedule: RestartSchedule{{Start: 12 * time.Hour, End: 13 * time.Hour}},
+			t:        at("09:15"),
+			want:     at("12:00"),
+		},
+		"spanning midnight after midnight": {
+			schedule: RestartSchedule{{Start: 22 * time.Hour, End: 6 * time.Hour}},
+			t:        at("05:59"),
+			want:     at("05:59"),
+		},
+		"spanning midnight outside": {
+			schedule: RestartSchedule{{Start: 22 * time.Hour, End: 6 * time.Hour}},
+			t:        at("06:00"),
+			want:     at("22:00"),
+		},
+		"earliest of multiple": {
+			schedule: RestartSchedule{
+				{Start: 22 * time.Hour, End: 23 * time.Hour},
+				{Start: 14 * time.Hour, End: 15 * time.Hour},
+			},
+			t:    at("13:00"),
+			want: at("14:00"),
+		},
+		"whole day": {
+			schedule: RestartSchedule{{Start: 3 * time.Hour, End: 3 * time.Hour}},
+			t:        at("01:00"),
+			want:     at("01:00"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.schedule.Next(tt.t))
+		})
+	}
+}
+
+// TestRestartScheduleNextDST tests that ranges start and end at their clock
+// times on days with a daylight saving time transition.
+func TestRestartScheduleNextDST(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	schedule := RestartSchedule{{Start: 12 * time.Hour, End: 13 * time.Hour}}
+
+	tests := map[string]struct {
+		t    time.Time
+		want time.Time
+	}{
+		"spring forward before": {
+			t:    time.Date(2024, time.March, 10, 9, 0, 0, 0, loc),
+			want: time.Date(2024, time.March, 10, 12, 0, 0, 0, loc),
+		},
+		"spring forward at end": {
+			t:    time.Date(2024, time.March, 10, 13, 0, 0, 0, loc),
+			want: time.Date(2024, time.March, 11, 12, 0, 0, 0, loc),
+		},
+		"fall back inside": {
+			t:    time.Date(2024, time.November, 3, 12, 30, 0, 0, loc),
+			want: time.Date(2024, time.November, 3, 12, 30, 0, 0, loc),
+		},
+		"fall back before": {
+			t:    time.Date(2024, time.November, 3, 11, 30, 0, 0, loc),
+			want: time.Date(2024, time.November, 3, 12, 0, 0, 0, loc),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, schedule.Next(tt.t))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"syscall"
+)
+
+// signalGroup sends sig to the process group of pid, so descendants of the
+// process are signaled as well. If the group cannot be determined, it signals
+// the group led by pid, which is the group of a process started with Setpgid.
+// Only if that fails too, it signals the process alone. getpgid and kill are
+// [syscall.Getpgid] and [syscall.Kill] outside of tests.
+func signalGroup(
+	pid int,
+	sig syscall.Signal,
+	getpgid func(pid int) (int, error),
+	kill func(pid int, sig syscall.Signal) error,
+	logger *slog.Logger,
+) error {
+	pgid, err := getpgid(pid)
+	if err != nil {
+		logger.Debug("getting process group failed, assuming own group", "pid", pid, "error", err)
+		pgid = pid
+	}
+
+	err = kill(-pgid, sig)
+	if err == nil {
+		return nil
+	}
+	logger.Debug("signaling process group failed, signaling process only", "pid", pid, "pgid", pgid, "error", err)
+
+	if err := kill(pid, sig); err != nil {
+		return fmt.Errorf("signal process %d: %w", pid, err)
+	}
+
+	return nil
+}
+
+// signalName returns the conventional name of a signal, such as "SIGSEGV",
+// or "SIG" followed by its number if it is not a common signal.
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGQUIT:
+		return "SIGQUIT"
+	case syscall.SIGILL:
+		return "SIGILL"
+	case syscall.SIGTRAP:
+		return "SIGTRAP"
+	case syscall.SIGABRT:
+		return "SIGABRT"
+	case syscall.SIGBUS:
+		return "SIGBUS"
+	case syscall.SIGFPE:
+		return "SIGFPE"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	case syscall.SIGUSR1:
+		return "SIGUSR1"
+	case syscall.SIGSEGV:
+		return "SIGSEGV"
+	case syscall.SIGUSR2:
+		return "SIGUSR2"
+	case syscall.SIGPIPE:
+		return "SIGPIPE"
+	case syscall.SIGALRM:
+		return "SIGALRM"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	default:
+		return "SIG" + strconv.Itoa(int(sig))
+	}
+}
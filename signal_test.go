@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignalName tests naming signals.
+func TestSignalName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		sig  syscall.Signal
+		want string
+	}{
+		"SIGSEGV": {sig: syscall.SIGSEGV, want: "SIGSEGV"},
+		"SIGKILL": {sig: syscall.SIGKILL, want: "SIGKILL"},
+		"SIGTERM": {sig: syscall.SIGTERM, want: "SIGTERM"},
+		"unknown": {sig: syscall.Signal(64), want: "SIG64"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, signalName(tt.sig))
+		})
+	}
+}
+
+// TestSignalGroup tests signaling a process group and its fallbacks.
+func TestSignalGroup(t *testing.T) {
+	t.Parallel()
+
+	const pid = 100
+
+	tests := map[string]struct {
+		getpgidErr error
+		killErrs   map[int]error
+		wantKills  []int
+		wantErr    assert.ErrorAssertionFunc
+	}{
+		"process group": {
+			wantKills: []int{-200},
+			wantErr:   assert.NoError,
+		},
+		"getpgid fails": {
+			getpgidErr: syscall.ESRCH,
+			wantKills:  []int{-pid},
+			wantErr:    assert.NoError,
+		},
+		"own group fails": {
+			getpgidErr: syscall.ESRCH,
+			killErrs:   map[int]error{-pid: syscall.ESRCH},
+			wantKills:  []int{-pid, pid},
+			wantErr:    assert.NoError,
+		},
+		"process fails": {
+			killErrs:  map[int]error{-200: syscall.EPERM, pid: syscall.ESRCH},
+			wantKills: []int{-200, pid},
+			wantErr:   assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			getpgid := func(int) (int, error) {
+				if tt.getpgidErr != nil {
+					return 0, tt.getpgidErr
+				}
+
+				return 200, nil
+			}
+
+			var kills []int
+			kill := func(pid int, sig syscall.Signal) error {
+				assert.Equal(t, syscall.SIGINT, sig)
+				kills = append(kills, pid)
+
+				return tt.killErrs[pid]
+			}
+
+			err := signalGroup(pid, syscall.SIGINT, getpgid, kill, slog.New(slog.DiscardHandler))
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantKills, kills)
+		})
+	}
+}
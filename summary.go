@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+)
+
+type (
+	// Summary is a machine-readable report of a group run.
+	Summary struct {
+		Instances []InstanceSummary `json:"instances"`
+	}
+
+	// InstanceSummary reports the outcome of a single instance. Status is one
+	// of "not_started", "running", "paused", "exited" (clean exit),
+	// "stopped" (terminated by shutdown), or "failed". StartedAt, ReadyAt,
+	// and ExitedAt are the times of the most recent run, each zero until it
+	// happened.
+	InstanceSummary struct {
+		Index           int       `json:"index"`
+		Label           string    `json:"label,omitempty"`
+		Cmd             string    `json:"cmd"`
+		Status          string    `json:"status"`
+		ExitCode        int       `json:"exit_code"`
+		Restarts        int       `json:"restarts"`
+		DurationSeconds float64   `json:"duration_seconds"`
+		StartedAt       time.Time `json:"started_at,omitzero"`
+		ReadyAt         time.Time `json:"ready_at,omitzero"`
+		ExitedAt        time.Time `json:"exited_at,omitzero"`
+		Error           string    `json:"error,omitempty"`
+	}
+)
+
+// Summary returns a report built from the statistics of each instance. It is
+// safe to call concurrently with [Group.Run].
+func (g *Group) Summary() Summary {
+	summary := Summary{Instances: make([]InstanceSummary, 0, len(g.Instances))}
+	for idx, instance := range g.Instances {
+		stats := instance.Stats()
+		instanceSummary := InstanceSummary{
+			Index:     idx,
+			Label:     instance.Label,
+			Cmd:       instance.String(),
+			Status:    instanceStatus(instance, stats),
+			ExitCode:  stats.ExitCode,
+			Restarts:  max(stats.Starts-1, 0),
+			StartedAt: stats.StartedAt,
+			ReadyAt:   stats.ReadyAt,
+		}
+		if !stats.LastExit.Before(stats.StartedAt) {
+			// Not the exit of an earlier run.
+			instanceSummary.ExitedAt = stats.LastExit
+		}
+		if !stats.FirstStart.IsZero() && !stats.LastExit.IsZero() {
+			instanceSummary.DurationSeconds = stats.LastExit.Sub(stats.FirstStart).Seconds()
+		}
+		if stats.LastErr != nil {
+			instanceSummary.Error = stats.LastErr.Error()
+		}
+
+		summary.Instances = append(summary.Instances, instanceSummary)
+	}
+
+	return summary
+}
+
+// instanceStatus classifies the state of an instance from its statistics.
+func instanceStatus(instance *Instance, stats Stats) string {
+	switch {
+	case instance.Running():
+		return "running"
+	case instance.Paused():
+		return "paused"
+	case stats.Starts == 0:
+		return "not_started"
+	case stats.LastErr == nil:
+		return "exited"
+	case instance.checkErr(stats.LastErr) == nil:
+		return "stopped"
+	default:
+		return "failed"
+	}
+}
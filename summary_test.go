@@ -0,0 +1,168 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cmdgroup "github.com/tho/gokrazy-cmdgroup"
+)
+
+// TestGroupSummary tests summarizing the outcome of instances.
+func TestGroupSummary(t *testing.T) {
+	t.Parallel()
+
+	truePath, err := exec.LookPath("true")
+	require.NoError(t, err)
+	falsePath, err := exec.LookPath("false")
+	require.NoError(t, err)
+	sleepPath, err := exec.LookPath("sleep")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		instance     *cmdgroup.Instance
+		timeout      time.Duration
+		wantStatus   string
+		wantExitCode int
+		wantRestarts int
+		wantError    bool
+	}{
+		"exited": {
+			instance:   &cmdgroup.Instance{Name: truePath, Args: []string{"a"}},
+			wantStatus: "exited",
+		},
+		"failed": {
+			instance:     &cmdgroup.Instance{Name: falsePath},
+			wantStatus:   "failed",
+			wantExitCode: 1,
+			wantError:    true,
+		},
+		"not started": {
+			instance:   &cmdgroup.Instance{Name: "/nonexistent/binary"},
+			wantStatus: "not_started",
+		},
+		"stopped": {
+			instance:     &cmdgroup.Instance{Name: sleepPath, Args: []string{"60"}},
+			timeout:      100 * time.Millisecond,
+			wantStatus:   "stopped",
+			wantExitCode: -1,
+			wantError:    true,
+		},
+		"restarted": {
+			instance:     &cmdgroup.Instance{Name: truePath, Watch: true},
+			timeout:      1500 * time.Millisecond,
+			wantStatus:   "exited",
+			wantRestarts: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				t.Cleanup(cancel)
+			}
+
+			group := &cmdgroup.Group{Instances: []*cmdgroup.Instance{tt.instance}}
+			_ = group.Run(ctx)
+
+			summary := group.Summary()
+			require.Len(t, summary.Instances, 1)
+			got := summary.Instances[0]
+			assert.Equal(t, tt.wantStatus, got.Status)
+			assert.Equal(t, tt.wantExitCode, got.ExitCode)
+			assert.Equal(t, tt.wantRestarts, got.Restarts)
+			assert.Equal(t, tt.wantError, got.Error != "")
+			assert.Contains(t, got.Cmd, tt.instance.Name)
+
+			_, err := json.Marshal(summary)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// TestWithLogRunTimes tests recording the start, ready, and exit times of each
+// run, and logging how long a run took to become ready and ran.
+func TestWithLogRunTimes(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	group, err := cmdgroup.New("sh",
+		cmdgroup.WithArgs([]string{"-c", "sleep 0.1"}),
+		cmdgroup.WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+		cmdgroup.WithLogRunTimes(true),
+	)
+	require.NoError(t, err)
+	assert.True(t, group.Instances[0].LogRunTimes)
+	require.NoError(t, group.Run(t.Context()))
+
+	stats := group.Instances[0].Stats()
+	require.Len(t, stats.Exits, 1)
+	exit := stats.Exits[0]
+	assert.Equal(t, stats.StartedAt, exit.StartedAt)
+	assert.Equal(t, stats.ReadyAt, exit.ReadyAt)
+	assert.Equal(t, stats.LastExit, exit.Time)
+	assert.False(t, exit.ReadyAt.Before(exit.StartedAt))
+	assert.GreaterOrEqual(t, exit.Time.Sub(exit.ReadyAt), 100*time.Millisecond)
+
+	summary := group.Summary().Instances[0]
+	assert.Equal(t, exit.StartedAt, summary.StartedAt)
+	assert.Equal(t, exit.ReadyAt, summary.ReadyAt)
+	assert.Equal(t, exit.Time, summary.ExitedAt)
+
+	var record struct {
+		Msg     string        `json:"msg"`
+		Startup time.Duration `json:"startup"`
+		RunTime time.Duration `json:"run_time"`
+	}
+	for line := range strings.Lines(buf.String()) {
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record.Msg == "exited" {
+			break
+		}
+	}
+	require.Equal(t, "exited", record.Msg)
+	assert.GreaterOrEqual(t, record.Startup, time.Duration(0))
+	assert.GreaterOrEqual(t, record.RunTime, 100*time.Millisecond)
+	assert.LessOrEqual(t, record.Startup, record.RunTime)
+}
+
+// TestGroupSummaryRunning tests that the summary of a restarted instance does
+// not report the exit of its previous run as that of the running one.
+func TestGroupSummaryRunning(t *testing.T) {
+	t.Parallel()
+
+	group, err := cmdgroup.New("sleep", cmdgroup.WithArgs([]string{"60"}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() { done <- group.Run(ctx) }()
+	require.NoError(t, group.WaitReady(ctx, 0))
+
+	group.Instances[0].Restart()
+	require.Eventually(t, func() bool {
+		return group.Instances[0].Stats().Starts == 2 && group.Instances[0].Running()
+	}, 5*time.Second, time.Millisecond)
+
+	summary := group.Summary().Instances[0]
+	assert.False(t, summary.StartedAt.IsZero())
+	assert.False(t, summary.ReadyAt.Before(summary.StartedAt))
+	assert.True(t, summary.ExitedAt.IsZero())
+
+	cancel()
+	require.NoError(t, <-done)
+}
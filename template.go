@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// startTemplates holds the templates an instance renders its arguments and
+// environment variables from at every start, see [WithStartTemplates].
+type startTemplates struct {
+	// args are the arguments the templates in argTmpls were parsed from.
+	args     []string
+	argTmpls []*template.Template
+	// env are the parsed environment variables of [WithEnv], added after
+	// the fixed ones, such as that of [WithIndexEnv].
+	env      []*template.Template
+	fixedEnv []string
+	// data holds the fields of the instance; Attempt is added per start.
+	data map[string]any
+}
+
+// instanceData returns the data templates are rendered with for the instance
+// at index running the named command, expanded with the template value set
+// values, if any.
+func instanceData(index int, name string, values map[string]string) map[string]any {
+	data := make(map[string]any, len(values)+2)
+	for key, value := range values {
+		data[key] = value
+	}
+	data["Index"], data["Name"] = index, name
+
+	return data
+}
+
+// newStartTemplates parses args for rendering them and env, after fixedEnv, at
+// every start of the instance described by data.
+func newStartTemplates(args []string, env []*template.Template, fixedEnv []string, data map[string]any) (*startTemplates, error) {
+	argTmpls, err := parseArgTemplates(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &startTemplates{args: args, argTmpls: argTmpls, env: env, fixedEnv: fixedEnv, data: data}, nil
+}
+
+// render renders the arguments and environment variables for a start, where
+// attempt is the number of previous starts. If provided is not nil, such as by
+// an ArgsProvider, it is parsed and rendered in place of the arguments.
+func (t *startTemplates) render(provided []string, attempt int) ([]string, []string, error) {
+	data := maps.Clone(t.data)
+	data["Attempt"] = attempt
+
+	args, argTmpls := t.args, t.argTmpls
+	if provided != nil {
+		var err error
+		if argTmpls, err = parseArgTemplates(provided); err != nil {
+			return nil, nil, fmt.Errorf("render start template: %w", err)
+		}
+		args = provided
+	}
+
+	var rendered []string
+	if args != nil {
+		rendered = make([]string, 0, len(args))
+	}
+	for idx, tmpl := range argTmpls {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, nil, fmt.Errorf("render start template: execute arg %q: %w", args[idx], err)
+		}
+
+		rendered = append(rendered, sb.String())
+	}
+
+	env, err := renderEnv(t.env, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("render start template: %w", err)
+	}
+
+	return rendered, slices.Concat(t.fixedEnv, env), nil
+}
+
+// parseArgTemplates parses each argument as a template.
+func parseArgTemplates(args []string) ([]*template.Template, error) {
+	tmpls := make([]*template.Template, 0, len(args))
+	for _, arg := range args {
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parse arg %q: %w", arg, err)
+		}
+
+		tmpls = append(tmpls, tmpl)
+	}
+
+	return tmpls, nil
+}
+
+// expandTemplates renders every argument set once per value set. The result
+// is ordered by argument set, then by value set. Argument sets that fail to
+// render are kept unrendered, and the errors are returned joined, so the
+// result can still be checked further.
+func expandTemplates(argSets [][]string, values []map[string]string) ([][]string, error) {
+	var errs []error
+
+	expanded := make([][]string, 0, len(argSets)*len(values))
+	for _, args := range argSets {
+		for idx, data := range values {
+			rendered, err := expandTemplate(args, data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("expand template: value set %d: %w", idx, err))
+				rendered = args
+			}
+
+			expanded = append(expanded, rendered)
+		}
+	}
+
+	return expanded, errors.Join(errs...)
+}
+
+// expandTemplate renders each argument as a template with the given data.
+// Referencing a missing key is an error.
+func expandTemplate(args []string, data map[string]string) ([]string, error) {
+	if args == nil {
+		return nil, nil
+	}
+
+	rendered := make([]string, 0, len(args))
+	for _, arg := range args {
+		tmpl, err := template.New("arg").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", arg, err)
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("execute %q: %w", arg, err)
+		}
+
+		rendered = append(rendered, sb.String())
+	}
+
+	return rendered, nil
+}
+
+// parseEnvTemplates parses each "key=value" entry of env as a template.
+func parseEnvTemplates(env []string) ([]*template.Template, error) {
+	tmpls := make([]*template.Template, 0, len(env))
+	for _, entry := range env {
+		if !strings.Contains(entry, "=") {
+			return nil, fmt.Errorf("parse env %q: missing '='", entry)
+		}
+
+		tmpl, err := template.New("env").Option("missingkey=error").Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parse env %q: %w", entry, err)
+		}
+
+		tmpls = append(tmpls, tmpl)
+	}
+
+	return tmpls, nil
+}
+
+// renderEnv renders environment variable templates with the given data.
+func renderEnv(tmpls []*template.Template, data map[string]any) ([]string, error) {
+	env := make([]string, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("render env: %w", err)
+		}
+
+		env = append(env, sb.String())
+	}
+
+	return env, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExpandTemplates tests expanding argument sets with value sets.
+func TestExpandTemplates(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		argSets [][]string
+		values  []map[string]string
+		want    [][]string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		"no placeholders": {
+			argSets: [][]string{{"serve"}},
+			values:  []map[string]string{{}, {}},
+			want:    [][]string{{"serve"}, {"serve"}},
+			wantErr: assert.NoError,
+		},
+		"named substitution": {
+			argSets: [][]string{{"serve", "--port", "{{.port}}", "--name", "{{.name}}"}},
+			values: []map[string]string{
+				{"port": "80", "name": "http"},
+				{"port": "443", "name": "https"},
+			},
+			want: [][]string{
+				{"serve", "--port", "80", "--name", "http"},
+				{"serve", "--port", "443", "--name", "https"},
+			},
+			wantErr: assert.NoError,
+		},
+		"ordered by argument set": {
+			argSets: [][]string{{"a{{.n}}"}, {"b{{.n}}"}},
+			values:  []map[string]string{{"n": "1"}, {"n": "2"}},
+			want:    [][]string{{"a1"}, {"a2"}, {"b1"}, {"b2"}},
+			wantErr: assert.NoError,
+		},
+		"nil args": {
+			argSets: [][]string{nil},
+			values:  []map[string]string{{"n": "1"}},
+			want:    [][]string{nil},
+			wantErr: assert.NoError,
+		},
+		"missing key": {
+			argSets: [][]string{{"--port={{.port}}"}},
+			values:  []map[string]string{{"port": "80"}, {"name": "http"}},
+			want:    [][]string{{"--port=80"}, {"--port={{.port}}"}},
+			wantErr: assert.Error,
+		},
+		"all errors": {
+			argSets: [][]string{{"{{.a}}"}, {"{{.b}}"}},
+			values:  []map[string]string{{}},
+			want:    [][]string{{"{{.a}}"}, {"{{.b}}"}},
+			wantErr: func(t assert.TestingT, err error, _ ...any) bool {
+				return assert.ErrorContains(t, err, `"{{.a}}"`) && assert.ErrorContains(t, err, `"{{.b}}"`)
+			},
+		},
+		"invalid template": {
+			argSets: [][]string{{"{{.port"}},
+			values:  []map[string]string{{"port": "80"}},
+			want:    [][]string{{"{{.port"}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			got, err := expandTemplates(tt.argSets, tt.values)
+			assert.Equal(t, tt.want, got)
+			tt.wantErr(t, err)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal reports whether f refers to a terminal, which is a file the
+// terminal attributes can be read from. Other character devices, such as
+// /dev/null, are not terminals.
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS,
+		uintptr(unsafe.Pointer(&termios))) // #nosec G103 -- termios outlives the call
+
+	return errno == 0
+}
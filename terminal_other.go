@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// isTerminal is only supported on Linux. Elsewhere, no file is considered a
+// terminal, so color must be enabled explicitly.
+func isTerminal(*os.File) bool {
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// TerminationReason tells why [Group.Run] returned.
+type TerminationReason string
+
+const (
+	// TerminationCompleted is the reason when all instances exited on
+	// their own and none failed.
+	TerminationCompleted TerminationReason = "completed"
+	// TerminationCanceled is the reason when the context passed to Run was
+	// done, such as on a shutdown signal.
+	TerminationCanceled TerminationReason = "canceled"
+	// TerminationFailed is the reason when the failure of an instance
+	// stopped the group, or instances failed without being restarted.
+	TerminationFailed TerminationReason = "failed"
+	// TerminationStopGroupOnExit is the reason when an instance with
+	// StopGroupOnExit exited.
+	TerminationStopGroupOnExit TerminationReason = "stop_group_on_exit"
+	// TerminationFirstSuccess is the reason when an instance exited
+	// successfully with StopOnFirstSuccess.
+	TerminationFirstSuccess TerminationReason = "first_success"
+	// TerminationMaxRuntime is the reason when MaxRuntime passed.
+	TerminationMaxRuntime TerminationReason = "max_runtime"
+	// TerminationSetupFailed is the reason when Run failed before starting
+	// any instance, such as for invalid dependencies.
+	TerminationSetupFailed TerminationReason = "setup_failed"
+)
+
+// TerminationReason returns why the most recent [Group.Run] returned, or an
+// empty reason while it runs and before it was first called. Together with the
+// error of Run, it tells apart a shutdown requested from outside from the
+// group stopping on its own, such as to decide whether to restart this
+// process. It is safe to call concurrently with [Group.Run].
+func (g *Group) TerminationReason() TerminationReason {
+	g.terminationMu.Lock()
+	defer g.terminationMu.Unlock()
+
+	return g.termination
+}
+
+// setTerminationReason records why Run returns.
+func (g *Group) setTerminationReason(reason TerminationReason) {
+	g.terminationMu.Lock()
+	defer g.terminationMu.Unlock()
+
+	g.termination = reason
+}
+
+// terminationReason classifies how a run ended from the cancellation cause
+// of the group context, which is nil if it was not canceled, the context
+// passed to Run, and the error Run returns.
+func terminationReason(cause error, parent context.Context, err error) TerminationReason {
+	switch {
+	case cause == nil && err == nil:
+		return TerminationCompleted
+	case cause == nil:
+		return TerminationFailed
+	case errors.Is(cause, errMaxRuntime):
+		return TerminationMaxRuntime
+	case errors.Is(cause, errStopGroupOnExit):
+		return TerminationStopGroupOnExit
+	case errors.Is(cause, errFirstSuccess):
+		return TerminationFirstSuccess
+	case parent.Err() != nil && errors.Is(cause, context.Cause(parent)):
+		return TerminationCanceled
+	default:
+		return TerminationFailed
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Usage is the resource usage of an exited process.
+type Usage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size in the platform's unit
+	// (kilobytes on Linux).
+	MaxRSS int64
+}
+
+// LogValue implements [slog.LogValuer].
+func (u Usage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Duration("user_time", u.UserTime),
+		slog.Duration("system_time", u.SystemTime),
+		slog.Int64("max_rss", u.MaxRSS),
+	)
+}
+
+// processUsage extracts the resource usage from a process state. It reports
+// false if the state is nil or the platform does not provide [syscall.Rusage].
+func processUsage(state *os.ProcessState) (Usage, bool) {
+	if state == nil {
+		return Usage{}, false
+	}
+
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return Usage{}, false
+	}
+
+	return Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     int64(rusage.Maxrss), //nolint:unconvert // int32 on some platforms
+	}, true
+}
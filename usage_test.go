@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestProcessUsage tests extracting resource usage from a process state.
+func TestProcessUsage(t *testing.T) {
+	t.Parallel()
+
+	t.Run("nil state", func(t *testing.T) {
+		t.Parallel()
+		_, ok := processUsage(nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("exited process", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.CommandContext(t.Context(), "true")
+		require.NoError(t, cmd.Run())
+		usage, ok := processUsage(cmd.ProcessState)
+		assert.True(t, ok)
+		assert.Positive(t, usage.MaxRSS)
+	})
+}